@@ -3,6 +3,8 @@ package main
 
 import (
 	"database/sql"
+	"time"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -13,15 +15,49 @@ type CameraInfo struct {
 	Thumbnail string `json:"thumbnail"`
 }
 type App struct {
-	DB     *sql.DB
-	Config *Config // your config struct type
+	DB        *sql.DB
+	Config    *Config       // your config struct type
+	Snapshots SnapshotStore // local disk or S3, see snapshotstore.go
+	Thumbs    *ThumbnailCache
+	LLM       LLMProvider         // see llm.go
+	Embedder  Embedder            // semantic search, nil when off (embeddings.go)
+	Prompts   *PromptSet          // chat prompt templates (prompts.go)
+	Notifiers map[string]Notifier // notification channels by name (notify.go)
+	Cache     *chatCache          // shared chat answers (chatcache.go)
+	MQTT      *MQTTClient         // nil when mqtt.broker is unset (mqtt.go)
+	Rules     *ruleEngine         // stored rules and cooldowns (rules.go)
+	Zones     *zoneStore          // zones added through /zones (zones.go)
+	Tracks    *crossingTracker    // previous event per camera, for tripwires (crossings.go)
+	Dwell     *dwellTracker       // open stays for loitering alerts (dwell.go)
+	HA        *haPublisher        // Home Assistant off-delay timers (publisher.go)
+	Hooks     *webhookStore       // webhooks added through /webhooks (webhooks.go)
+	Loc       *time.Location      // configured timezone for chat time ranges
+	Now       func() time.Time    // clock for chat; the eval command pins it
 }
 
 
-// NewApp sets up your App struct with DB + Config.
-func NewApp(db *sql.DB, cfg *Config) *App {
+// NewApp sets up your App struct with DB + Config + snapshot store + LLM.
+func NewApp(db *sql.DB, cfg *Config, snapshots SnapshotStore, llm LLMProvider) *App {
 	return &App{
-		DB:     db,
-		Config: cfg,
+		DB:        db,
+		Config:    cfg,
+		Snapshots: snapshots,
+		Thumbs:    NewThumbnailCache(cfg.Snapshots.ThumbnailDir),
+		LLM:       llm,
+		Prompts:   NewPromptSet(cfg.Prompts),
+		Cache:     newChatCache(cfg.Chat.Cache),
+		Rules:     newRuleEngine(),
+		Zones:     &zoneStore{},
+		Tracks:    newCrossingTracker(),
+		Dwell:     newDwellTracker(),
+		HA:        newHAPublisher(),
+		Hooks:     newWebhookStore(),
+		Loc:       loadLocation(cfg.Timezone),
+		Now:       time.Now,
 	}
+}
+
+// now is the current time in the configured timezone.
+func (app *App) now() time.Time {
+	return app.Now().In(app.Loc)
 }
\ No newline at end of file
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestApp returns an App on a fresh database and snapshot directory,
+// with the fake LLM and UTC as its timezone. The database is the global
+// db, so tests using it must not run in parallel.
+func newTestApp(t *testing.T, cfg *Config) *App {
+	t.Helper()
+	dir := t.TempDir()
+	openDB(filepath.Join(dir, "detections.db"))
+	t.Cleanup(func() { db.Close() })
+
+	snapshots, err := NewLocalSnapshotStore(filepath.Join(dir, "snapshots"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	cfg.Snapshots.ThumbnailDir = filepath.Join(dir, "thumbnails")
+	app := NewApp(db, cfg, snapshots, NewFakeProvider())
+	app.Loc = time.UTC
+	return app
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+cameras.go
+----------
+
+Works out which cameras a chat question is about:
+
+ 1. Cameras or groups named in the question ("anything at the garage or
+    front door?", "outside", "any camera") — matched against camera IDs,
+    names, aliases and camera_groups from config.yaml.
+ 2. Otherwise the request's camera_id and/or cameras list (IDs or groups).
+ 3. Otherwise every configured camera.
+*/
+
+// allCamerasWords in a question mean "search everywhere".
+var allCamerasWords = regexp.MustCompile(`\b(all|any|every)\s+cameras?\b|\beverywhere\b|\banywhere\b`)
+
+// cameraIDs returns the IDs of all configured cameras.
+func (app *App) cameraIDs() []string {
+	ids := make([]string, 0, len(app.Config.Cameras))
+	for _, cam := range app.Config.Cameras {
+		ids = append(ids, cam.ID)
+	}
+	return ids
+}
+
+// expandCameras turns camera IDs and group names into camera IDs.
+// Unknown names are kept as-is, since detections may come from cameras
+// that are no longer in the config.
+func (app *App) expandCameras(names []string) []string {
+	var ids []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if group, ok := app.Config.CameraGroups[name]; ok {
+			ids = append(ids, group...)
+		} else {
+			ids = append(ids, name)
+		}
+	}
+	return uniqueStrings(ids)
+}
+
+// camerasInQuestion returns the cameras the question names, in config order.
+func (app *App) camerasInQuestion(question string) []string {
+	q := strings.ToLower(question)
+	if allCamerasWords.MatchString(q) {
+		return app.cameraIDs()
+	}
+
+	var ids []string
+	for _, cam := range app.Config.Cameras {
+		terms := append([]string{cam.ID, strings.ReplaceAll(cam.ID, "_", " "), cam.Name}, cam.Aliases...)
+		if mentions(q, terms) {
+			ids = append(ids, cam.ID)
+		}
+	}
+	groups := make([]string, 0, len(app.Config.CameraGroups))
+	for name := range app.Config.CameraGroups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		if mentions(q, []string{name, strings.ReplaceAll(name, "_", " ")}) {
+			ids = append(ids, app.Config.CameraGroups[name]...)
+		}
+	}
+	return uniqueStrings(ids)
+}
+
+// resolveCameras picks the cameras a chat request searches (see above).
+func (app *App) resolveCameras(req chatQuery) []string {
+	if ids := app.camerasInQuestion(req.Message); len(ids) > 0 {
+		return ids
+	}
+	if ids := app.expandCameras(append([]string{req.CameraID}, req.Cameras...)); len(ids) > 0 {
+		return ids
+	}
+	return app.cameraIDs()
+}
+
+// mentions reports whether lower-cased text contains any term as whole words.
+func mentions(text string, terms []string) bool {
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" {
+			continue
+		}
+		if regexp.MustCompile(`\b` + regexp.QuoteMeta(term) + `\b`).MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// cameraFilter returns "camera_id IN (?, ...)" and its arguments.
+func cameraFilter(ids []string) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "camera_id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")", args
+}
+
+// uniqueStrings drops duplicates, keeping the first occurrence.
+func uniqueStrings(vals []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range vals {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+chat.go
+-------
+
+The /chat pipeline, shared by the blocking /chat endpoint and the
+streaming /chat/stream endpoint (chatstream.go):
+
+ 1. Extract object(s) from the question with the LLM.
+ 2. Look up matching detections in SQLite.
+ 3. Build the final prompt from real detections.
+ 4. Ask the LLM for the answer (blocking or streamed).
+
+With chat.tools enabled, steps 1-3 are replaced by a tool-calling loop
+(chattools.go) that usually produces the answer itself.
+*/
+
+// chatQuery is the body of /chat and /chat/stream.
+// Leave conversation_id out to start a new conversation. camera_id and
+// cameras (IDs or camera_groups) are optional; see cameras.go.
+type chatQuery struct {
+	CameraID       string   `json:"camera_id"`
+	Cameras        []string `json:"cameras,omitempty"`
+	Message        string   `json:"message"`
+	ConversationID int64    `json:"conversation_id,omitempty"`
+	NoCache        bool     `json:"no_cache,omitempty"` // don't share answers (chatcache.go)
+	Zones          []string `json:"zones,omitempty"`    // only objects in these zones (zones.go)
+
+	// Filled in by startTurn from earlier turns (see conversations.go).
+	history     []ChatMessage
+	lastObjects []string
+	created     bool // startTurn created the conversation; abandonTurn drops it
+
+	// Set by /admin/prompts/preview: nothing that calls a model runs.
+	preview bool
+}
+
+// errConversationNotFound is returned for an unknown conversation_id.
+var errConversationNotFound = errors.New("conversation not found")
+
+// chatPlan is everything prepareChat worked out before the final LLM call.
+type chatPlan struct {
+	Objects  []string
+	Context  string
+	Messages []ChatMessage // final prompt, ready for the LLM
+	// Answer is set when the tool loop already answered; step 4 is skipped.
+	Answer string
+	// Range is the time range resolved from the question, if any.
+	Range *TimeRange
+	// Cameras that were searched (see cameras.go), and the zones on them.
+	Cameras []string
+	Zones   []string
+	// Snapshots attached to the prompt for a vision model, and that model.
+	Snapshots []AttachedSnapshot
+	Model     string
+	// Citations are the detections given as context (grounding.go).
+	Citations []Citation
+}
+
+// chatProgress reports pipeline stages ("extracting", "lookup", ...) to the
+// caller. detail may be nil. Used by /chat/stream; /chat passes a no-op.
+type chatProgress func(stage string, detail map[string]interface{})
+
+// prepareChat runs steps 1-3 of the pipeline.
+func (app *App) prepareChat(ctx context.Context, req chatQuery, progress chatProgress) (*chatPlan, error) {
+	if app.Config.Chat.Tools {
+		plan, err := app.prepareToolChat(ctx, req, progress)
+		if !errors.Is(err, errToolsRejected) {
+			return plan, err
+		}
+		log.Printf("handleChat: %v — answering without tools", err)
+	}
+
+	// === STEP 1: Extract object(s) ===
+	progress("extracting", nil)
+	objects, err := app.extractObjects(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	progress("extracted", map[string]interface{}{"objects": objects})
+
+	return app.planAnswer(ctx, req, objects, progress)
+}
+
+// extractObjects asks the LLM which objects the question is about.
+func (app *App) extractObjects(ctx context.Context, req chatQuery) ([]string, error) {
+	data := app.promptData(req.Message, nil, nil, nil)
+	extractResp, err := app.LLM.Chat(ctx, LLMRequest{
+		Messages: []ChatMessage{
+			{Role: "system", Content: app.renderPrompt("extraction_system", data)},
+			{Role: "user", Content: app.renderPrompt("extraction", data)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("LLM extraction failed: %w", err)
+	}
+
+	// Parse the extracted JSON array
+	extracted := extractResp.Content
+	log.Printf("handleChat: extracted raw: %s", extracted)
+
+	var objects []string
+	if err := json.Unmarshal([]byte(extracted), &objects); err != nil {
+		log.Printf("JSON unmarshal failed: %v", err)
+		objects = []string{}
+	}
+	// Follow-ups ("and what about yesterday?") keep talking about the same objects.
+	if len(objects) == 0 && len(req.lastObjects) > 0 {
+		objects = req.lastObjects
+		log.Printf("handleChat: no objects in follow-up, reusing %v", objects)
+	}
+	log.Printf("handleChat: extracted objects: %v", objects)
+	return objects, nil
+}
+
+// planAnswer runs steps 2-3: look up detections and build the final prompt.
+// It does not call the LLM, so /admin/prompts/preview uses it too; with
+// req.preview the semantic search (which calls the embedding model) is left
+// out and a placeholder takes its place.
+func (app *App) planAnswer(ctx context.Context, req chatQuery, objects []string, progress chatProgress) (*chatPlan, error) {
+	// "last night", "between 2 and 4pm yesterday", ... (timerange.go)
+	rng, hasRange := parseTimeRange(req.Message, app.now())
+	if hasRange {
+		log.Printf("handleChat: time range: %s", rng)
+		progress("time_range", map[string]interface{}{"time_range": rng})
+	}
+
+	// === STEP 2: Query DB for matching detections on the relevant cameras ===
+	cameras := app.resolveCameras(req)
+	zones := app.resolveZones(req, cameras)
+	progress("lookup", map[string]interface{}{"cameras": cameras, "zones": zones})
+	contextString, hits := app.lookupContext(ctx, cameras, zones, objects, rng)
+	if app.Embedder != nil && req.preview {
+		contextString += semanticPlaceholder
+	} else if app.Embedder != nil {
+		// Wording the extractor misses ("a parcel delivery") (embeddings.go).
+		related, relatedHits := app.semanticContext(ctx, req.Message, cameras, rng)
+		contextString += related
+		hits = append(hits, relatedHits...)
+	}
+	// "How long has the car been there?" (dwell.go)
+	contextString += app.dwellContext(ctx, req.Message, cameras, zones, objects, rng)
+	progress("context", map[string]interface{}{"context": contextString})
+
+	// === STEP 3: Final prompt (prompts.go) ===
+	data := app.promptData(req.Message, cameras, objects, rng)
+	data.Context = contextString
+	finalPrompt := app.renderPrompt("answer", data)
+
+	log.Printf("handleChat: final prompt:\n%s", finalPrompt)
+
+	// System prompt, then earlier turns of the conversation, then this question.
+	question := ChatMessage{Role: "user", Content: finalPrompt}
+	plan := &chatPlan{
+		Objects:   objects,
+		Context:   contextString,
+		Range:     rng,
+		Cameras:   cameras,
+		Zones:     zones,
+		Citations: citationsFor(hits, app.Loc),
+	}
+
+	// Let a vision model look at the snapshots too (vision.go).
+	if app.visionEnabled() {
+		plan.Snapshots = app.attachSnapshots(ctx, &question, hits)
+		if len(plan.Snapshots) > 0 {
+			plan.Model = app.Config.Chat.Vision.Model
+			progress("snapshots", map[string]interface{}{"snapshots": plan.Snapshots})
+		}
+	}
+
+	plan.Messages = []ChatMessage{{Role: "system", Content: app.renderPrompt("system", data)}}
+	plan.Messages = append(plan.Messages, req.history...)
+	plan.Messages = append(plan.Messages, question)
+	return plan, nil
+}
+
+// lookupContext builds the detection context for the final prompt, listing
+// detections in time order with the camera that saw them:
+//   - with a time range: how many matched, and the latest 10 in it;
+//   - with an object: the last detection of it on each camera;
+//   - otherwise: the latest 5 detections.
+//
+// The listed detections are returned too, for attaching their snapshots.
+// With zones, only detections with an object in one of them count.
+func (app *App) lookupContext(ctx context.Context, cameras, zones, objects []string, rng *TimeRange) (string, []detectionHit) {
+	where, args := cameraFilter(cameras)
+	what := "Detections"
+	var out string
+	if len(zones) > 0 {
+		cond, zargs := zoneFilter(zones)
+		where += " AND " + cond
+		args = append(args, zargs...)
+		out = fmt.Sprintf("- Zones: %s\n", strings.Join(zones, ", "))
+	}
+	if len(objects) > 0 {
+		// Use first extracted object for now
+		log.Printf("Searching for object: %s on %v", objects[0], cameras)
+		where += " AND labels LIKE ?"
+		args = append(args, "%"+objects[0]+"%")
+		what = fmt.Sprintf("Detections of '%s'", objects[0])
+	}
+
+	var query string
+	switch {
+	case rng != nil:
+		where += " AND timestamp >= ? AND timestamp < ?"
+		args = append(args, float64(rng.Start.Unix()), float64(rng.End.Unix()))
+		out += fmt.Sprintf("- Time range: %s\n", rng)
+
+		counts, total, err := app.countByCamera(ctx, where, args)
+		if err != nil {
+			log.Printf("Range DB query failed: %v", err)
+			return "No detection history available.", nil
+		}
+		out += fmt.Sprintf("- %s in that range: %d", what, total)
+		if len(cameras) > 1 && total > 0 {
+			out += " (" + strings.Join(counts, ", ") + ")"
+		}
+		out += "\n"
+		if total == 0 {
+			return out, nil
+		}
+		query = "SELECT id, timestamp, camera_id, labels, COALESCE(snapshot_file, '') FROM detections WHERE " + where + " ORDER BY timestamp DESC LIMIT 10"
+
+	case len(objects) > 0:
+		// SQLite returns the other columns of the MAX(timestamp) row for each camera.
+		query = "SELECT id, MAX(timestamp), camera_id, labels, COALESCE(snapshot_file, '') FROM detections WHERE " + where + " GROUP BY camera_id"
+
+	default:
+		// No object found → fallback to latest 5
+		query = "SELECT id, timestamp, camera_id, labels, COALESCE(snapshot_file, '') FROM detections WHERE " + where + " ORDER BY timestamp DESC LIMIT 5"
+	}
+
+	rows, err := app.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Detection lookup failed: %v", err)
+		return "No detection history available.", nil
+	}
+	defer rows.Close()
+
+	var hits []detectionHit
+	for rows.Next() {
+		var h detectionHit
+		if err := rows.Scan(&h.ID, &h.Timestamp, &h.CameraID, &h.Labels, &h.SnapshotFile); err != nil {
+			log.Printf("Detection row scan failed: %v", err)
+			continue
+		}
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Timestamp < hits[j].Timestamp })
+
+	prefix := "Time"
+	if rng == nil && len(objects) > 0 {
+		prefix = "Last detection"
+	}
+	for _, h := range hits {
+		t := time.Unix(int64(h.Timestamp), 0).In(app.Loc).Format(time.RFC3339)
+		out += fmt.Sprintf("- %s: %s Camera: %s Labels: %s Event: %d\n", prefix, t, h.CameraID, h.Labels, h.ID)
+	}
+
+	if len(hits) == 0 && rng == nil {
+		if len(objects) > 0 {
+			return fmt.Sprintf("No detections found for '%s'.", objects[0]), nil
+		}
+		return "No recent detections found.", nil
+	}
+	return out, hits
+}
+
+// detectionHit is one detection listed in the chat context.
+type detectionHit struct {
+	ID           int64
+	Timestamp    float64
+	CameraID     string
+	Labels       string
+	SnapshotFile string
+}
+
+// countByCamera counts the detections matching where, per camera
+// ("garage_webcam: 3") and in total.
+func (app *App) countByCamera(ctx context.Context, where string, args []interface{}) ([]string, int, error) {
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT camera_id, COUNT(*) FROM detections WHERE "+where+" GROUP BY camera_id ORDER BY camera_id", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var counts []string
+	total := 0
+	for rows.Next() {
+		var camera string
+		var n int
+		if err := rows.Scan(&camera, &n); err != nil {
+			return nil, 0, err
+		}
+		counts = append(counts, fmt.Sprintf("%s: %d", camera, n))
+		total += n
+	}
+	return counts, total, rows.Err()
+}
+
+// noProgress is the chatProgress used when nobody is listening.
+func noProgress(string, map[string]interface{}) {}
+
+// handleChat handles POST /chat requests.
+// It receives { camera_id, message, conversation_id? } JSON and returns
+// { answer: "...", conversation_id: N, time_range: {...}|null, cameras: [...],
+// snapshots: [...]|null, citations, verification, cache } JSON.
+// The LLM is whatever provider config.yaml selects (see llm.go).
+func (app *App) handleChat(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// === Parse request ===
+	var req chatQuery
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	log.Printf("handleChat: camera_id=%s message=%s conversation=%d", req.CameraID, req.Message, req.ConversationID)
+
+	// === Resolve conversation + history ===
+	if err := app.startTurn(r.Context(), &req); err != nil {
+		if errors.Is(err, errConversationNotFound) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to load conversation", http.StatusInternalServerError)
+		log.Printf("handleChat: load conversation failed: %v", err)
+		return
+	}
+
+	// === STEPS 1-5, or the answer to an identical question (chatcache.go) ===
+	res, source, err := app.Cache.do(r.Context(), app.chatCacheKey(r.Context(), req), func() (*chatResult, error) {
+		return app.runChat(r.Context(), req)
+	})
+	if err != nil {
+		app.abandonTurn(req)
+		log.Printf("handleChat (%s): %v", app.LLM.Name(), err)
+		if errors.Is(err, errFinalCall) {
+			http.Error(w, "LLM final call failed", http.StatusInternalServerError)
+		} else {
+			http.Error(w, "LLM extraction failed", http.StatusInternalServerError)
+		}
+		return
+	}
+	answer, plan := res.Answer, res.Plan
+	log.Printf("handleChat: final answer (cache %s): %s", source, answer)
+	app.finishTurn(req, answer, plan)
+
+	// === Return to frontend ===
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"answer":          answer,
+		"conversation_id": req.ConversationID,
+		"time_range":      plan.Range,
+		"cameras":         plan.Cameras,
+		"snapshots":       plan.Snapshots,
+		"citations":       plan.Citations,
+		"verification":    res.Verification,
+		"cache":           source,
+	})
+}
+
+// errFinalCall marks a failure of the final LLM call (step 4).
+var errFinalCall = errors.New("LLM final call failed")
+
+// runChat runs steps 1-5 for /chat.
+func (app *App) runChat(ctx context.Context, req chatQuery) (*chatResult, error) {
+	// === STEPS 1-3: extract, look up, build prompt ===
+	plan, err := app.prepareChat(ctx, req, noProgress)
+	if err != nil {
+		return nil, err
+	}
+
+	// === STEP 4: Send final prompt to the LLM (unless the tools answered) ===
+	answer := plan.Answer
+	if answer == "" {
+		finalResp, err := app.LLM.Chat(ctx, LLMRequest{Messages: plan.Messages, Model: plan.Model})
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errFinalCall, err)
+		}
+		answer = finalResp.Content
+	}
+
+	// === STEP 5: Check the answer against the detections (grounding.go) ===
+	answer, verification := app.groundAnswer(ctx, req, plan, answer)
+	return &chatResult{Answer: answer, Plan: plan, Verification: verification}, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+chatcache.go
+------------
+
+The same question often comes in several times within minutes ("is the
+car home?" from everyone in the house), and each one costs the full LLM
+pipeline. /chat and /chat/stream therefore share answers:
+
+  - Cache: a finished answer is kept for chat.cache.ttl_seconds under a key
+    of the normalised question, the cameras and zones searched, the resolved
+    time range and the ID of the latest relevant detection (matching the labels
+    named in the question, or any detection when none are). A new matching
+    detection changes the key, so the next question gets a fresh answer.
+  - In-flight sharing: while one request is running the pipeline, identical
+    requests wait for its answer instead of starting their own.
+
+Only the first question of a conversation is shared; follow-ups depend on
+their history. Send "no_cache": true (or ?no_cache=1 on /chat/stream) to
+skip both. Responses say where the answer came from in "cache": miss, hit,
+shared or off. GET /admin/chat/cache returns the counters, DELETE empties
+the cache.
+*/
+
+const (
+	defaultChatCacheTTL        = 10 * time.Minute
+	defaultChatCacheMaxEntries = 256
+)
+
+// chatResult is one run of the pipeline: what the handlers return besides
+// the conversation ID. Cached results are shared, so treat them as read-only.
+type chatResult struct {
+	Answer       string
+	Plan         *chatPlan
+	Verification *Verification
+}
+
+// chatCacheEntry is a cached answer and when it was stored.
+type chatCacheEntry struct {
+	result *chatResult
+	stored time.Time
+}
+
+// chatCall is a pipeline run that identical requests can wait for.
+type chatCall struct {
+	done   chan struct{}
+	result *chatResult
+	err    error
+}
+
+// ChatCacheStats are the counters served by /admin/chat/cache.
+type ChatCacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`    // answered from the cache
+	Shared  int64   `json:"shared"`  // waited for an identical request in flight
+	Misses  int64   `json:"misses"`  // ran the pipeline
+	Expired int64   `json:"expired"` // entries dropped after ttl_seconds
+	HitRate float64 `json:"hit_rate"`
+}
+
+// chatCache holds finished answers and the requests still running.
+type chatCache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*chatCacheEntry
+	inflight map[string]*chatCall
+	stats    ChatCacheStats
+}
+
+// newChatCache returns the cache configured under chat.cache.
+func newChatCache(cfg ChatCacheConfig) *chatCache {
+	c := &chatCache{
+		ttl:      time.Duration(cfg.TTLSeconds) * time.Second,
+		max:      cfg.MaxEntries,
+		now:      time.Now,
+		entries:  map[string]*chatCacheEntry{},
+		inflight: map[string]*chatCall{},
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultChatCacheTTL
+	}
+	if c.max <= 0 {
+		c.max = defaultChatCacheMaxEntries
+	}
+	return c
+}
+
+// do returns the answer for key: from the cache ("hit"), from an identical
+// request already running ("shared"), or by running fn ("miss"). An empty
+// key runs fn without caching ("off").
+func (c *chatCache) do(ctx context.Context, key string, fn func() (*chatResult, error)) (*chatResult, string, error) {
+	if key == "" {
+		res, err := fn()
+		return res, "off", err
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if c.now().Sub(e.stored) < c.ttl {
+			c.stats.Hits++
+			c.mu.Unlock()
+			return e.result, "hit", nil
+		}
+		delete(c.entries, key)
+		c.stats.Expired++
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, "shared", ctx.Err()
+		}
+		if call.err != nil && errors.Is(call.err, context.Canceled) {
+			// The first asker went away; answer this one ourselves.
+			res, err := fn()
+			return res, "miss", err
+		}
+		c.mu.Lock()
+		c.stats.Shared++
+		c.mu.Unlock()
+		return call.result, "shared", call.err
+	}
+	call := &chatCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	call.result, call.err = fn()
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		c.store(key, call.result)
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.result, "miss", call.err
+}
+
+// store adds an entry, making room by dropping expired entries and then
+// the oldest ones. c.mu must be held.
+func (c *chatCache) store(key string, res *chatResult) {
+	now := c.now()
+	if len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if now.Sub(e.stored) >= c.ttl {
+				delete(c.entries, k)
+				c.stats.Expired++
+			}
+		}
+	}
+	for len(c.entries) >= c.max {
+		oldest := ""
+		for k, e := range c.entries {
+			if oldest == "" || e.stored.Before(c.entries[oldest].stored) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = &chatCacheEntry{result: res, stored: now}
+}
+
+// Stats returns a snapshot of the counters.
+func (c *chatCache) Stats() ChatCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = len(c.entries)
+	if total := s.Hits + s.Shared + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits+s.Shared) / float64(total)
+	}
+	return s
+}
+
+// Clear drops every cached answer (requests in flight are unaffected).
+func (c *chatCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*chatCacheEntry{}
+}
+
+// normalizeQuestion lower-cases a question and drops punctuation and extra
+// spaces, so "Is the car home?" and "is the car home" share an answer.
+// Letters and digits of any script are kept ("dónde", "車はどこ").
+func normalizeQuestion(q string) string {
+	words := strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == ':')
+	})
+	return strings.Join(words, " ")
+}
+
+// chatCacheKey returns the cache key for req, or "" when its answer must
+// not be shared: caching is off, the request opted out, it is a follow-up
+// in a conversation, or nothing of the question is left to key on.
+func (app *App) chatCacheKey(ctx context.Context, req chatQuery) string {
+	if !app.Config.Chat.Cache.Enabled || req.NoCache || len(req.history) > 0 {
+		return ""
+	}
+	question := normalizeQuestion(req.Message)
+	if question == "" {
+		return ""
+	}
+
+	cameras := app.resolveCameras(req)
+	sort.Strings(cameras)
+	zones := app.resolveZones(req, cameras)
+	sort.Strings(zones)
+	where, args := cameraFilter(cameras)
+	if len(zones) > 0 {
+		cond, zargs := zoneFilter(zones)
+		where += " AND " + cond
+		args = append(args, zargs...)
+	}
+	rangeKey := ""
+	if rng, ok := parseTimeRange(req.Message, app.now()); ok {
+		where += " AND timestamp >= ? AND timestamp < ?"
+		args = append(args, float64(rng.Start.Unix()), float64(rng.End.Unix()))
+		rangeKey = fmt.Sprintf("%d-%d", rng.Start.Unix(), rng.End.Unix())
+	}
+	var labels []string
+	for l := range labelsIn(req.Message) {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	if len(labels) > 0 {
+		var likes []string
+		for _, l := range labels {
+			likes = append(likes, "labels LIKE ?")
+			args = append(args, `%"`+l+`"%`)
+		}
+		where += " AND (" + strings.Join(likes, " OR ") + ")"
+	}
+
+	// The latest relevant detection: a new one means a new answer.
+	var latest int64
+	if err := app.DB.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM detections WHERE "+where, args...).Scan(&latest); err != nil {
+		log.Printf("Chat cache: latest detection lookup failed, not caching: %v", err)
+		return ""
+	}
+	return strings.Join([]string{question, strings.Join(cameras, ","), strings.Join(zones, ","), rangeKey, fmt.Sprint(latest)}, "|")
+}
+
+// handleChatCache handles /admin/chat/cache:
+//
+//	GET    /admin/chat/cache → counters (entries, hits, shared, misses, expired, hit_rate)
+//	DELETE /admin/chat/cache → drop all cached answers
+func (app *App) handleChatCache(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(app.Cache.Stats())
+	case http.MethodDelete:
+		app.Cache.Clear()
+		log.Printf("[ChatCache] Cleared")
+		json.NewEncoder(w).Encode(app.Cache.Stats())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
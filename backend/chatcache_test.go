@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNormalizeQuestion(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Is the car home?", "is the car home"},
+		{"  is   THE car home!!! ", "is the car home"},
+		{"what happened at 10:30?", "what happened at 10:30"},
+		{"¿Dónde está el coche?", "dónde está el coche"},
+		{"Cafe\u0301 door?", "cafe\u0301 door"}, // combining accent is kept
+		{"Где машина?", "где машина"},
+		{"車はどこ？", "車はどこ"},
+		{"?!…", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeQuestion(tt.in); got != tt.want {
+			t.Errorf("normalizeQuestion(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestChatCacheKey(t *testing.T) {
+	app := newTestApp(t, &Config{Chat: ChatConfig{Cache: ChatCacheConfig{Enabled: true}}})
+	ctx := context.Background()
+	if _, err := insertDetection(1000, "garage", `["car"]`, `[]`, `[]`, "", "[]"); err != nil {
+		t.Fatal(err)
+	}
+	base := app.chatCacheKey(ctx, chatQuery{CameraID: "garage", Message: "Is the car home?"})
+	if base == "" {
+		t.Fatal("no key for a cacheable question")
+	}
+
+	tests := []struct {
+		name string
+		req  chatQuery
+		same bool // shares the base key
+	}{
+		{"punctuation and case", chatQuery{CameraID: "garage", Message: "is the CAR home"}, true},
+		{"other camera", chatQuery{CameraID: "lounge", Message: "Is the car home?"}, false},
+		{"other question", chatQuery{CameraID: "garage", Message: "Is the car gone?"}, false},
+		{"zones", chatQuery{CameraID: "garage", Message: "Is the car home?", Zones: []string{"drive"}}, false},
+	}
+	for _, tt := range tests {
+		key := app.chatCacheKey(ctx, tt.req)
+		if (key == base) != tt.same {
+			t.Errorf("%s: key %q vs %q, want same=%v", tt.name, key, base, tt.same)
+		}
+	}
+
+	uncached := []struct {
+		name string
+		req  chatQuery
+	}{
+		{"no_cache", chatQuery{CameraID: "garage", Message: "Is the car home?", NoCache: true}},
+		{"follow-up", chatQuery{CameraID: "garage", Message: "Is the car home?", history: []ChatMessage{{Role: "user", Content: "hi"}}}},
+		{"only punctuation", chatQuery{CameraID: "garage", Message: "???"}},
+	}
+	for _, tt := range uncached {
+		if key := app.chatCacheKey(ctx, tt.req); key != "" {
+			t.Errorf("%s: key %q, want none", tt.name, key)
+		}
+	}
+
+	// A new detection of something else leaves the key alone; a new car doesn't.
+	insertDetection(1010, "garage", `["cat"]`, `[]`, `[]`, "", "[]")
+	if key := app.chatCacheKey(ctx, chatQuery{CameraID: "garage", Message: "Is the car home?"}); key != base {
+		t.Errorf("key changed after an unrelated detection: %q vs %q", key, base)
+	}
+	insertDetection(1020, "garage", `["car"]`, `[]`, `[]`, "", "[]")
+	if key := app.chatCacheKey(ctx, chatQuery{CameraID: "garage", Message: "Is the car home?"}); key == base {
+		t.Error("key unchanged after a new matching detection")
+	}
+
+	app.Config.Chat.Cache.Enabled = false
+	if key := app.chatCacheKey(ctx, chatQuery{CameraID: "garage", Message: "Is the car home?"}); key != "" {
+		t.Errorf("key %q with the cache off", key)
+	}
+}
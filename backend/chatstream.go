@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+chatstream.go
+-------------
+
+GET|POST /chat/stream runs the same pipeline as /chat but answers with
+Server-Sent Events, so the UI shows progress and tokens as they arrive:
+
+	event: conversation data: {"conversation_id":12}
+	event: progress   data: {"stage":"extracting"}
+	event: progress   data: {"stage":"extracted","objects":["car"]}
+	event: progress   data: {"stage":"time_range","time_range":{"start":"...","end":"...","phrase":"last night"}}
+	event: progress   data: {"stage":"lookup","cameras":["garage_webcam"]}
+	event: progress   data: {"stage":"context","context":"- Last detection: ..."}
+	event: progress   data: {"stage":"tool","name":"count_detections","arguments":"{...}"}  (chat.tools only)
+	event: progress   data: {"stage":"snapshots","snapshots":[{"index":1,"url":"/snapshots/..."}]}  (chat.vision only)
+	event: progress   data: {"stage":"cached","cache":"hit"}  (answer shared with an identical question, see chatcache.go)
+	event: progress   data: {"stage":"answering"}
+	event: token      data: {"text":"The"}
+	event: done       data: {"answer":"The last car ...","conversation_id":12,"time_range":{...},"citations":[...],"verification":{"grounded":true},"cache":"miss"}
+	event: error      data: {"error":"..."}
+
+POST takes the /chat JSON body (use fetch + a stream reader); GET takes
+?camera_id=...&cameras=a,b&message=...&conversation_id=...&no_cache=1 so a plain EventSource works too.
+Closing the connection cancels the LLM request.
+*/
+
+// sseWriter writes named SSE events and flushes each one immediately.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseWriter) send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// handleChatStream handles /chat/stream.
+func (app *App) handleChatStream(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	// === Parse request ===
+	var req chatQuery
+	switch r.Method {
+	case http.MethodOptions:
+		return
+	case http.MethodGet:
+		req.CameraID = r.URL.Query().Get("camera_id")
+		if cameras := r.URL.Query().Get("cameras"); cameras != "" {
+			req.Cameras = strings.Split(cameras, ",")
+		}
+		if zones := r.URL.Query().Get("zones"); zones != "" {
+			req.Zones = strings.Split(zones, ",")
+		}
+		req.Message = r.URL.Query().Get("message")
+		req.ConversationID, _ = strconv.ParseInt(r.URL.Query().Get("conversation_id"), 10, 64)
+		req.NoCache, _ = strconv.ParseBool(r.URL.Query().Get("no_cache"))
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "Missing 'message'", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // don't let a reverse proxy buffer the stream
+	w.WriteHeader(http.StatusOK)
+
+	sse := &sseWriter{w: w, flusher: flusher}
+	ctx := r.Context() // cancelled when the client disconnects
+	log.Printf("handleChatStream: camera_id=%s message=%s conversation=%d", req.CameraID, req.Message, req.ConversationID)
+
+	// === Resolve conversation + history ===
+	if err := app.startTurn(ctx, &req); err != nil {
+		if errors.Is(err, errConversationNotFound) {
+			sse.send("error", map[string]string{"error": "Conversation not found"})
+			return
+		}
+		app.streamFailed(sse, ctx.Err(), err)
+		return
+	}
+	sse.send("conversation", map[string]int64{"conversation_id": req.ConversationID})
+
+	// === STEPS 1-5 streamed, or the answer to an identical question (chatcache.go) ===
+	res, source, err := app.Cache.do(ctx, app.chatCacheKey(ctx, req), func() (*chatResult, error) {
+		return app.streamChat(ctx, req, sse)
+	})
+	if err != nil {
+		app.abandonTurn(req)
+		app.streamFailed(sse, ctx.Err(), err)
+		return
+	}
+	if source == "hit" || source == "shared" {
+		// Nothing was streamed for this request; send the answer as one token.
+		sse.send("progress", map[string]interface{}{"stage": "cached", "cache": source})
+		sse.send("progress", map[string]interface{}{"stage": "answering"})
+		sse.send("token", map[string]string{"text": res.Answer})
+	}
+	answer, plan := res.Answer, res.Plan
+	log.Printf("handleChatStream: final answer (cache %s): %s", source, answer)
+	app.finishTurn(req, answer, plan)
+	sse.send("done", map[string]interface{}{
+		"answer":          answer,
+		"conversation_id": req.ConversationID,
+		"time_range":      plan.Range,
+		"cameras":         plan.Cameras,
+		"snapshots":       plan.Snapshots,
+		"citations":       plan.Citations,
+		"verification":    res.Verification,
+		"cache":           source,
+	})
+}
+
+// streamChat runs steps 1-5 for /chat/stream, sending progress and answer
+// tokens to sse as they arrive.
+func (app *App) streamChat(ctx context.Context, req chatQuery, sse *sseWriter) (*chatResult, error) {
+	// === STEPS 1-3 with progress events ===
+	plan, err := app.prepareChat(ctx, req, func(stage string, detail map[string]interface{}) {
+		event := map[string]interface{}{"stage": stage}
+		for k, v := range detail {
+			event[k] = v
+		}
+		sse.send("progress", event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// === STEP 4: Stream the answer ===
+	sse.send("progress", map[string]interface{}{"stage": "answering"})
+	answer := plan.Answer
+	if answer != "" {
+		// The tool loop already has the whole answer; send it as one token.
+		sse.send("token", map[string]string{"text": answer})
+	} else {
+		resp, err := app.LLM.ChatStream(ctx, LLMRequest{Messages: plan.Messages, Model: plan.Model}, func(token string) error {
+			return sse.send("token", map[string]string{"text": token})
+		})
+		if err != nil {
+			return nil, err
+		}
+		answer = resp.Content
+	}
+
+	// === STEP 5: Check the streamed answer; "done" carries the final one ===
+	answer, verification := app.groundAnswer(ctx, req, plan, answer)
+	return &chatResult{Answer: answer, Plan: plan, Verification: verification}, nil
+}
+
+// streamFailed logs a pipeline error and, if the client is still there,
+// tells it with an error event.
+func (app *App) streamFailed(sse *sseWriter, ctxErr, err error) {
+	if ctxErr != nil {
+		log.Printf("handleChatStream: client went away, cancelled (%v)", ctxErr)
+		return
+	}
+	log.Printf("handleChatStream (%s): %v", app.LLM.Name(), err)
+	sse.send("error", map[string]string{"error": "LLM call failed"})
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+chattools.go
+------------
+
+Tool-calling mode for /chat (chat.tools: true in config.yaml). Instead of
+"extract objects, look up the latest match", the model gets tools over the
+detection store and the backend runs its tool calls in a bounded loop:
+
+ 1. Send the question plus the tool definitions.
+ 2. If the model asks for tools, run them against SQLite, append the
+    results and ask again — at most chat.max_tool_rounds times.
+ 3. The first reply without tool calls is the answer. If the rounds run
+    out, the normal final LLM call answers from the results gathered so far.
+    With chat.vision, that final call always happens, with the snapshots
+    the tools found attached (vision.go).
+
+If the LLM server rejects the first request with a 400 (the model has no
+tool support), the question is answered by the normal pipeline instead.
+
+Tools:
+- search_detections   — detections by camera, label and time range
+- count_detections    — number of detections matching the same filters
+- get_visits          — detections grouped into visits (gaps > gap_seconds)
+- get_latest_snapshot — time, labels and URL of the newest snapshot
+- get_dwell           — how long an object has been present (dwell.go)
+- semantic_search     — events and visits described like the query
+                        (only with embeddings enabled, see embeddings.go)
+*/
+
+// defaultMaxToolRounds bounds the tool loop when chat.max_tool_rounds is not set.
+const defaultMaxToolRounds = 4
+
+// errToolsRejected means the LLM server refused a request offering tools.
+var errToolsRejected = errors.New("LLM rejected tools")
+
+// maxToolResultChars keeps one tool result from flooding a small context window.
+const maxToolResultChars = 4000
+
+// detectionFilterParams are the JSON Schema properties shared by the tools.
+var detectionFilterParams = map[string]interface{}{
+	"camera_id": map[string]interface{}{"type": "string", "description": "Camera ID or camera group to search. Defaults to the cameras the question is about."},
+	"label":     map[string]interface{}{"type": "string", "description": "Object label, e.g. \"car\" or \"person\". Omit for any object."},
+	"zone":      map[string]interface{}{"type": "string", "description": "Zone of the camera picture, e.g. \"driveway\". Defaults to the zones the question names; omit for the whole picture."},
+	"start":     map[string]interface{}{"type": "string", "description": "Start of the time range, RFC3339 (e.g. 2025-07-11T08:00:00+01:00)."},
+	"end":       map[string]interface{}{"type": "string", "description": "End of the time range, RFC3339."},
+}
+
+// withParams returns detectionFilterParams plus extra properties.
+func withParams(extra map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	for k, v := range detectionFilterParams {
+		props[k] = v
+	}
+	for k, v := range extra {
+		props[k] = v
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// detectionTools are the tools offered to the model.
+var detectionTools = []Tool{
+	{Type: "function", Function: ToolFunction{
+		Name:        "search_detections",
+		Description: "List detections (newest first) matching a camera, label and time range.",
+		Parameters: withParams(map[string]interface{}{
+			"limit": map[string]interface{}{"type": "integer", "description": "Maximum rows to return (default 10, max 50)."},
+		}),
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "count_detections",
+		Description: "Count detections matching a camera, label and time range. One object standing still is detected many times; use get_visits to count arrivals.",
+		Parameters:  withParams(nil),
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "get_visits",
+		Description: "Group detections into visits: a new visit starts after gap_seconds without a detection. Returns start, end and duration of each.",
+		Parameters: withParams(map[string]interface{}{
+			"gap_seconds": map[string]interface{}{"type": "integer", "description": "Gap that separates two visits (default 120)."},
+		}),
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "get_latest_snapshot",
+		Description: "Get the newest snapshot matching a camera and label: its time, labels and URL.",
+		Parameters:  withParams(nil),
+	}},
+	{Type: "function", Function: ToolFunction{
+		Name:        "get_dwell",
+		Description: "How long an object has been (or was last) present without a break, per camera, as of end (default now). Use for \"how long has the car been parked?\". label is required.",
+		Parameters:  withParams(nil),
+	}},
+}
+
+// semanticSearchTool is offered on top of detectionTools when embeddings are on.
+var semanticSearchTool = Tool{Type: "function", Function: ToolFunction{
+	Name: "semantic_search",
+	Description: "Find events and visits described like a free-text query, e.g. \"someone delivering a parcel\". " +
+		"Use it when the question is not about a single object label. Returns summaries with a similarity score.",
+	Parameters: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query":     map[string]interface{}{"type": "string", "description": "What to look for, in plain words."},
+			"camera_id": detectionFilterParams["camera_id"],
+			"start":     detectionFilterParams["start"],
+			"end":       detectionFilterParams["end"],
+			"limit":     map[string]interface{}{"type": "integer", "description": "Maximum results (default 5)."},
+		},
+		"required": []string{"query"},
+	},
+}}
+
+// chatTools returns the tools offered to the model.
+func (app *App) chatTools() []Tool {
+	if app.Embedder == nil {
+		return detectionTools
+	}
+	return append(append([]Tool{}, detectionTools...), semanticSearchTool)
+}
+
+// toolArgs are the arguments of every detection tool; each uses a subset.
+type toolArgs struct {
+	CameraID   string `json:"camera_id"`
+	Label      string `json:"label"`
+	Zone       string `json:"zone"`
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	Limit      int    `json:"limit"`
+	GapSeconds int    `json:"gap_seconds"`
+	Query      string `json:"query"`
+
+	cameras []string // searched when CameraID is empty
+	zones   []string // searched when Zone is empty (zones.go)
+}
+
+// where builds the WHERE clause for the filters, like /timeline does.
+func (a toolArgs) where(loc *time.Location) (string, []interface{}, error) {
+	cameraCond, args := cameraFilter(a.cameras)
+	conditions := []string{cameraCond}
+
+	if a.Label != "" {
+		// Labels are JSON text (["person"]); match the quoted label exactly.
+		conditions = append(conditions, "labels LIKE ?")
+		args = append(args, `%"`+strings.ToLower(a.Label)+`"%`)
+	}
+	zones := a.zones
+	if a.Zone != "" {
+		zones = []string{a.Zone}
+	}
+	if len(zones) > 0 {
+		cond, zargs := zoneFilter(zones)
+		conditions = append(conditions, cond)
+		args = append(args, zargs...)
+	}
+	if a.Start != "" {
+		t, err := parseToolTime(a.Start, loc)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, t)
+	}
+	if a.End != "" {
+		t, err := parseToolTime(a.End, loc)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, t)
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// parseToolTime accepts RFC3339, a date/time without zone (read in loc), or Unix seconds.
+func parseToolTime(s string, loc *time.Location) (float64, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return float64(t.Unix()), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return float64(t.Unix()), nil
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return 0, fmt.Errorf("cannot parse time %q, use RFC3339", s)
+}
+
+// formatToolTime formats a detection timestamp the way the tools report it.
+func formatToolTime(ts float64, loc *time.Location) string {
+	return time.Unix(int64(ts), 0).In(loc).Format(time.RFC3339)
+}
+
+// runTool executes one tool call. Bad arguments come back as an error
+// result the model can read and correct, not as a Go error.
+func (app *App) runTool(ctx context.Context, call ToolCall, cameras, zones []string) interface{} {
+	var args toolArgs
+	if strings.TrimSpace(call.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return map[string]string{"error": "arguments must be a JSON object: " + err.Error()}
+		}
+	}
+	args.cameras = cameras
+	args.zones = zones
+	if args.CameraID != "" {
+		args.cameras = app.expandCameras([]string{args.CameraID})
+	}
+
+	var result interface{}
+	var err error
+	switch call.Function.Name {
+	case "search_detections":
+		result, err = app.toolSearch(ctx, args)
+	case "count_detections":
+		result, err = app.toolCount(ctx, args)
+	case "get_visits":
+		result, err = app.toolVisits(ctx, args)
+	case "get_latest_snapshot":
+		result, err = app.toolLatestSnapshot(ctx, args)
+	case "get_dwell":
+		result, err = app.toolDwell(ctx, args)
+	case "semantic_search":
+		result, err = app.toolSemanticSearch(ctx, args)
+	default:
+		return map[string]string{"error": fmt.Sprintf("unknown tool %q", call.Function.Name)}
+	}
+	if err != nil {
+		log.Printf("Tool %s(%s) failed: %v", call.Function.Name, call.Function.Arguments, err)
+		return map[string]string{"error": err.Error()}
+	}
+	return result
+}
+
+// toolDetection is one detection as reported to the model.
+type toolDetection struct {
+	ID          int64    `json:"id"`
+	Time        string   `json:"time"`
+	CameraID    string   `json:"camera_id"`
+	Labels      []string `json:"labels"`
+	SnapshotURL string   `json:"snapshot_url,omitempty"`
+
+	hit detectionHit // for citations and attaching the snapshot (vision.go)
+}
+
+func (app *App) toolSearch(ctx context.Context, args toolArgs) (interface{}, error) {
+	where, params, err := args.where(app.Loc)
+	if err != nil {
+		return nil, err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	if limit > 50 {
+		limit = 50
+	}
+
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT id, timestamp, camera_id, labels, COALESCE(snapshot_file, '') FROM detections"+where+
+			" ORDER BY timestamp DESC LIMIT ?", append(params, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dets := []toolDetection{}
+	for rows.Next() {
+		var d toolDetection
+		var ts float64
+		var labels, snapshot string
+		if err := rows.Scan(&d.ID, &ts, &d.CameraID, &labels, &snapshot); err != nil {
+			return nil, err
+		}
+		d.Time = formatToolTime(ts, app.Loc)
+		json.Unmarshal([]byte(labels), &d.Labels)
+		d.SnapshotURL = snapshotURL(snapshot)
+		d.hit = detectionHit{ID: d.ID, Timestamp: ts, CameraID: d.CameraID, Labels: labels, SnapshotFile: snapshot}
+		dets = append(dets, d)
+	}
+	return map[string]interface{}{"cameras": args.cameras, "detections": dets}, rows.Err()
+}
+
+func (app *App) toolCount(ctx context.Context, args toolArgs) (interface{}, error) {
+	where, params, err := args.where(app.Loc)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT camera_id, COUNT(*) FROM detections"+where+" GROUP BY camera_id", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byCamera := map[string]int{}
+	total := 0
+	for rows.Next() {
+		var camera string
+		var n int
+		if err := rows.Scan(&camera, &n); err != nil {
+			return nil, err
+		}
+		byCamera[camera] = n
+		total += n
+	}
+	return map[string]interface{}{"label": args.Label, "count": total, "by_camera": byCamera}, rows.Err()
+}
+
+// toolVisit is one visit as reported to the model.
+type toolVisit struct {
+	CameraID        string `json:"camera_id"`
+	Start           string `json:"start"`
+	End             string `json:"end"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Detections      int    `json:"detections"`
+}
+
+func (app *App) toolVisits(ctx context.Context, args toolArgs) (interface{}, error) {
+	where, params, err := args.where(app.Loc)
+	if err != nil {
+		return nil, err
+	}
+	gap := float64(args.GapSeconds)
+	if gap <= 0 {
+		gap = 120
+	}
+
+	// Count every visit in SQL: a detection starts one when it is the
+	// camera's first or comes more than gap after the camera's previous one.
+	var total int
+	err = app.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT timestamp - LAG(timestamp) OVER (PARTITION BY camera_id ORDER BY timestamp) AS since
+			FROM detections`+where+`
+		) WHERE since IS NULL OR since > ?`, append(params, gap)...,
+	).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the most recent detections are needed to list the latest visits.
+	rows, err := app.DB.QueryContext(ctx,
+		"SELECT timestamp, camera_id FROM detections"+where+" ORDER BY timestamp DESC LIMIT 10000", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type toolRow struct {
+		ts     float64
+		camera string
+	}
+	var recent []toolRow
+	for rows.Next() {
+		var r toolRow
+		if err := rows.Scan(&r.ts, &r.camera); err != nil {
+			return nil, err
+		}
+		recent = append(recent, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Each camera has its own open visit; visits end up ordered by start
+	// because a visit is only opened after the previous one was flushed.
+	type openVisit struct {
+		index       int // into visits
+		start, last float64
+	}
+	var visits []toolVisit
+	open := map[string]*openVisit{}
+	for i := len(recent) - 1; i >= 0; i-- {
+		ts, camera := recent[i].ts, recent[i].camera
+		v := open[camera]
+		if v == nil || ts-v.last > gap {
+			v = &openVisit{index: len(visits), start: ts}
+			open[camera] = v
+			visits = append(visits, toolVisit{CameraID: camera})
+		}
+		v.last = ts
+		visits[v.index].Start = formatToolTime(v.start, app.Loc)
+		visits[v.index].End = formatToolTime(v.last, app.Loc)
+		visits[v.index].DurationSeconds = int(v.last - v.start)
+		visits[v.index].Detections++
+	}
+
+	// Report the count of all visits but only list the most recent ones.
+	limit := args.Limit
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	if len(visits) > limit {
+		visits = visits[len(visits)-limit:]
+	}
+	if visits == nil {
+		visits = []toolVisit{}
+	}
+	return map[string]interface{}{"label": args.Label, "visit_count": total, "visits": visits}, nil
+}
+
+func (app *App) toolLatestSnapshot(ctx context.Context, args toolArgs) (interface{}, error) {
+	where, params, err := args.where(app.Loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var d toolDetection
+	var ts float64
+	var labels, snapshot string
+	err = app.DB.QueryRowContext(ctx,
+		"SELECT id, timestamp, camera_id, labels, snapshot_file FROM detections"+where+
+			" AND COALESCE(snapshot_file, '') != '' ORDER BY timestamp DESC LIMIT 1", params...,
+	).Scan(&d.ID, &ts, &d.CameraID, &labels, &snapshot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return map[string]string{"result": "no snapshot found"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.Time = formatToolTime(ts, app.Loc)
+	json.Unmarshal([]byte(labels), &d.Labels)
+	d.SnapshotURL = snapshotURL(snapshot)
+	d.hit = detectionHit{ID: d.ID, Timestamp: ts, CameraID: d.CameraID, Labels: labels, SnapshotFile: snapshot}
+	return d, nil
+}
+
+// toolHits returns the detections a tool result lists.
+func toolHits(result interface{}) []detectionHit {
+	var hits []detectionHit
+	switch v := result.(type) {
+	case toolDetection:
+		hits = append(hits, v.hit)
+	case map[string]interface{}:
+		dets, _ := v["detections"].([]toolDetection)
+		for _, d := range dets {
+			hits = append(hits, d.hit)
+		}
+		results, _ := v["results"].([]SearchResult)
+		for _, r := range results {
+			hits = append(hits, r.hit)
+		}
+	}
+	return hits
+}
+
+func (app *App) toolSemanticSearch(ctx context.Context, args toolArgs) (interface{}, error) {
+	if app.Embedder == nil {
+		return nil, fmt.Errorf("semantic search is disabled")
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	opts := searchOptions{Cameras: args.cameras, Limit: args.Limit}
+	if opts.Limit <= 0 || opts.Limit > 20 {
+		opts.Limit = 5
+	}
+	var err error
+	if args.Start != "" {
+		if opts.Start, err = parseToolTime(args.Start, app.Loc); err != nil {
+			return nil, err
+		}
+	}
+	if args.End != "" {
+		if opts.End, err = parseToolTime(args.End, app.Loc); err != nil {
+			return nil, err
+		}
+	}
+	results, err := app.semanticSearch(ctx, args.Query, opts)
+	if err != nil {
+		return nil, err
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	return map[string]interface{}{"query": args.Query, "results": results}, nil
+}
+
+// maxToolRounds returns the configured bound on tool-calling rounds.
+func (app *App) maxToolRounds() int {
+	if app.Config.Chat.MaxToolRounds > 0 {
+		return app.Config.Chat.MaxToolRounds
+	}
+	return defaultMaxToolRounds
+}
+
+// prepareToolChat is prepareChat in tool-calling mode: it runs the tool loop
+// and returns the transcript. plan.Answer is set when the model answered
+// within the allowed rounds.
+func (app *App) prepareToolChat(ctx context.Context, req chatQuery, progress chatProgress) (*chatPlan, error) {
+	now := app.now()
+	cameras := app.resolveCameras(req)
+	zones := app.resolveZones(req, cameras)
+
+	// Resolve the time part ourselves rather than trusting the model's date maths.
+	plan := &chatPlan{Cameras: cameras, Zones: zones}
+	progress("lookup", map[string]interface{}{"cameras": cameras, "zones": zones})
+	if rng, ok := parseTimeRange(req.Message, now); ok {
+		plan.Range = rng
+		progress("time_range", map[string]interface{}{"time_range": rng})
+	}
+	system := app.renderPrompt("tools_system", app.promptData(req.Message, cameras, nil, plan.Range))
+
+	messages := []ChatMessage{{Role: "system", Content: system}}
+	messages = append(messages, req.history...)
+	messages = append(messages, ChatMessage{Role: "user", Content: req.Message})
+
+	var gathered []string
+	var hits []detectionHit
+	seen := map[string]bool{}
+
+	for round := 0; round < app.maxToolRounds(); round++ {
+		progress("thinking", map[string]interface{}{"round": round + 1})
+		resp, err := app.LLM.Chat(ctx, LLMRequest{Messages: messages, Tools: app.chatTools()})
+		var se *httpStatusError
+		if round == 0 && errors.As(err, &se) && se.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%w: %v", errToolsRejected, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("LLM tool round %d failed: %w", round+1, err)
+		}
+		if len(resp.ToolCalls) == 0 {
+			plan.Answer = resp.Content
+			break
+		}
+
+		messages = append(messages, ChatMessage{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			progress("tool", map[string]interface{}{"name": call.Function.Name, "arguments": call.Function.Arguments})
+			output := app.runTool(ctx, call, cameras, zones)
+			hits = append(hits, toolHits(output)...)
+			result, _ := json.Marshal(output)
+			content := string(result)
+			if r := []rune(content); len(r) > maxToolResultChars {
+				content = string(r[:maxToolResultChars]) + "...(truncated)"
+			}
+			log.Printf("handleChat: tool %s(%s) → %s", call.Function.Name, call.Function.Arguments, content)
+
+			messages = append(messages, ChatMessage{Role: "tool", Content: content, ToolCallID: call.ID})
+			gathered = append(gathered, fmt.Sprintf("- %s(%s): %s", call.Function.Name, call.Function.Arguments, content))
+
+			var args toolArgs
+			if json.Unmarshal([]byte(call.Function.Arguments), &args) == nil && args.Label != "" && !seen[args.Label] {
+				seen[args.Label] = true
+				plan.Objects = append(plan.Objects, args.Label)
+			}
+		}
+	}
+	plan.Context = strings.Join(gathered, "\n")
+	plan.Messages = messages
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Timestamp < hits[j].Timestamp })
+	plan.Citations = citationsFor(hits, app.Loc)
+
+	if app.visionEnabled() && len(hits) > 0 {
+		// Let the vision model answer instead, looking at the snapshots found.
+		plan.Answer = ""
+	} else if plan.Answer == "" {
+		log.Printf("handleChat: no answer after %d tool rounds, answering from results", app.maxToolRounds())
+	}
+
+	if plan.Answer == "" {
+		// Ask for a plain answer from what the tools returned. The transcript
+		// is flattened so providers never see dangling tool calls.
+		results := plan.Context
+		if results == "" {
+			results = "No tool results."
+		}
+		question := ChatMessage{Role: "user", Content: fmt.Sprintf(
+			"Cameras: %s\n\nTool results:\n%s\n\nUser question: %s", strings.Join(cameras, ", "), results, req.Message)}
+		if app.visionEnabled() {
+			plan.Snapshots = app.attachSnapshots(ctx, &question, hits)
+			if len(plan.Snapshots) > 0 {
+				plan.Model = app.Config.Chat.Vision.Model
+				progress("snapshots", map[string]interface{}{"snapshots": plan.Snapshots})
+			}
+		}
+		data := app.promptData(req.Message, cameras, plan.Objects, plan.Range)
+		plan.Messages = append([]ChatMessage{{Role: "system", Content: app.renderPrompt("system", data)}}, req.history...)
+		plan.Messages = append(plan.Messages, question)
+	}
+	return plan, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestToolLatestSnapshot(t *testing.T) {
+	app := newTestApp(t, &Config{})
+	insertDetection(1752224400, "garage", `["car"]`, "[]", "[]", "garage/2025-07-11/a.jpg", "[]")
+	insertDetection(1752224460, "garage", `["car"]`, "[]", "[]", "", "[]")
+	ctx := context.Background()
+
+	got, err := app.toolLatestSnapshot(ctx, toolArgs{Label: "car", cameras: []string{"garage"}})
+	d, ok := got.(toolDetection)
+	if err != nil || !ok || d.ID != 1 || d.SnapshotURL != "/snapshots/garage/2025-07-11/a.jpg" {
+		t.Errorf("latest car snapshot = %+v, %v", got, err)
+	}
+
+	got, err = app.toolLatestSnapshot(ctx, toolArgs{Label: "dog"})
+	if want := map[string]string{"result": "no snapshot found"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("no dog snapshot = %v, %v; want %v", got, err, want)
+	}
+
+	// A failed query is an error, not "no snapshot found".
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if got, err := app.toolLatestSnapshot(cancelled, toolArgs{Label: "car"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled lookup = %v, %v; want context.Canceled", got, err)
+	}
+}
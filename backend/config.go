@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v2"
 )
@@ -12,6 +13,9 @@ import (
 type SubscriberConfig struct {
 	ThrottleN   int  `yaml:"throttle_n"`
 	Deduplicate bool `yaml:"deduplicate"`
+	// IgnoreOutsideZones makes dedup/throttle compare only objects inside a
+	// zone, on cameras that have zones (zones.go).
+	IgnoreOutsideZones bool `yaml:"ignore_outside_zones"`
 }
 
 // CameraConfig represents each camera entry from your YAML config.
@@ -21,20 +25,224 @@ type CameraConfig struct {
 	Index     int    `yaml:"index,omitempty"`
 	URL       string `yaml:"url,omitempty"`
 	Thumbnail string `yaml:"thumbnail"`
+
+	// Name and Aliases let chat recognise the camera in a question ("the garage").
+	Name    string   `yaml:"name,omitempty"`
+	Aliases []string `yaml:"aliases,omitempty"`
+
+	// Zones are named areas of the picture detections can be filtered on (zones.go).
+	Zones []Zone `yaml:"zones,omitempty"`
+	// Lines are tripwires that count objects crossing them (crossings.go).
+	Lines []Tripwire `yaml:"lines,omitempty"`
+}
+
+// S3Config holds settings for an S3-compatible object store (AWS, MinIO, ...).
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region,omitempty"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	UseSSL    bool   `yaml:"use_ssl"`
+	Prefix    string `yaml:"prefix,omitempty"`
+}
+
+// SnapshotConfig selects where snapshot JPEGs are stored.
+type SnapshotConfig struct {
+	Backend string   `yaml:"backend"` // 'local' (default) or 's3'
+	Dir     string   `yaml:"dir"`     // local backend only, default ./snapshots
+	S3      S3Config `yaml:"s3"`
+
+	ThumbnailDir string `yaml:"thumbnail_dir"` // local resize cache, default ./data/thumbnails
+}
+
+// LLMConfig selects and tunes the LLM provider used by /chat (see llm.go).
+type LLMConfig struct {
+	Provider       string `yaml:"provider"` // 'openai' (default), 'ollama', 'llamacpp' or 'fake'
+	BaseURL        string `yaml:"base_url"`
+	Model          string `yaml:"model"`
+	APIKey         string `yaml:"api_key,omitempty"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+	MaxRetries     int    `yaml:"max_retries"`
+	RetryBackoffMs int    `yaml:"retry_backoff_ms"`
+}
+
+// VisionConfig lets chat show snapshots to a multimodal model (see vision.go).
+type VisionConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Model     string `yaml:"model"`      // e.g. llava; default: llm.model
+	MaxImages int    `yaml:"max_images"` // snapshots per question, default 3
+	MaxSize   int    `yaml:"max_size"`   // longest side in pixels after resizing, default 512
+}
+
+// EmbeddingsConfig turns on semantic search (see embeddings.go). Provider,
+// base_url, api_key, timeout and retries default to the llm: section.
+type EmbeddingsConfig struct {
+	Enabled   bool             `yaml:"enabled"`
+	LLMConfig `yaml:",inline"` // model defaults to nomic-embed-text
+
+	VisitGapSeconds int     `yaml:"visit_gap_seconds"` // gap that ends a visit, default 120
+	ChatResults     int     `yaml:"chat_results"`      // matches added to the chat context, default 3
+	MinScore        float64 `yaml:"min_score"`         // cosine similarity cut-off, default 0.3
+}
+
+// PromptsConfig points at text/template files for the chat prompts (see
+// prompts.go). Relative paths are read from the config directory; empty
+// ones use the built-in prompt.
+type PromptsConfig struct {
+	ExtractionSystem string `yaml:"extraction_system"`
+	Extraction       string `yaml:"extraction"`
+	System           string `yaml:"system"`
+	Answer           string `yaml:"answer"`
+	ToolsSystem      string `yaml:"tools_system"`
+	Digest           string `yaml:"digest"`
+}
+
+// DigestConfig schedules activity digests (see digests.go).
+type DigestConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Periods      []string `yaml:"periods"`       // daily and/or weekly, default [daily]
+	Hour         int      `yaml:"hour"`          // local hour a finished period is written up, default 0
+	Model        string   `yaml:"model"`         // narrative model, default llm.model
+	TopSnapshots int      `yaml:"top_snapshots"` // snapshots per digest, default 3
+	Notify       []string `yaml:"notify"`        // notification channels to send digests to
+}
+
+// NotificationChannelConfig is one named notification channel, i.e. one
+// recipient (see notify.go).
+type NotificationChannelConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // log, email, ntfy or gotify
+
+	// email
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"` // default 587, or 465 with tls: tls
+	TLS      string   `yaml:"tls"`       // starttls (default, required), tls or none (plaintext)
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+
+	// ntfy and gotify
+	Server   string `yaml:"server"`   // e.g. https://ntfy.sh
+	Topic    string `yaml:"topic"`    // ntfy
+	Token    string `yaml:"token"`    // ntfy access token or Gotify application token
+	Priority int    `yaml:"priority"` // ntfy 1-5, Gotify 0-10; 0 = server default
+
+	QuietHours    *RuleSchedule   `yaml:"quiet_hours"` // local time window in which messages are dropped
+	RateLimit     NotifyRateLimit `yaml:"rate_limit"`
+	TitleTemplate string          `yaml:"title_template"` // Go text/template over the Notification
+	BodyTemplate  string          `yaml:"body_template"`
+}
+
+// NotifyRateLimit caps the messages a channel sends.
+type NotifyRateLimit struct {
+	Max        int `yaml:"max"`         // 0 = no limit
+	PerSeconds int `yaml:"per_seconds"` // window, default 3600
+}
+
+// NotificationsConfig lists the notification channels.
+type NotificationsConfig struct {
+	PublicURL string                      `yaml:"public_url"` // backend URL for links in messages, e.g. http://192.168.1.20:8080
+	Channels  []NotificationChannelConfig `yaml:"channels"`
+}
+
+// MQTTConfig is the MQTT broker connection (see mqtt.go).
+type MQTTConfig struct {
+	Broker      string `yaml:"broker"`    // e.g. tcp://localhost:1883; empty = MQTT off
+	ClientID    string `yaml:"client_id"` // default chat-with-my-camera-<hostname>
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	TopicPrefix string `yaml:"topic_prefix"` // default chatcam
+}
+
+// CrossingConfig tunes the object tracking behind tripwires (see crossings.go).
+type CrossingConfig struct {
+	MaxGapSeconds float64 `yaml:"max_gap_seconds"` // longer between events and tracks restart, default 3
+	MaxJump       float64 `yaml:"max_jump"`        // furthest move between events, fraction of the frame, default 0.25
+}
+
+// DwellAlertConfig raises a loitering event when an object stays too long (see dwell.go).
+type DwellAlertConfig struct {
+	Name       string   `yaml:"name"`
+	Cameras    []string `yaml:"cameras"` // camera IDs or groups; empty = all
+	Labels     []string `yaml:"labels"`
+	Zones      []string `yaml:"zones"` // only objects in these zones; empty = anywhere
+	MinSeconds int      `yaml:"min_seconds"`
+}
+
+// DwellConfig holds dwell-time settings and loitering alerts.
+type DwellConfig struct {
+	GapSeconds int                `yaml:"gap_seconds"` // absence that ends a stay, default 60
+	Alerts     []DwellAlertConfig `yaml:"alerts"`
+}
+
+// HomeAssistantConfig publishes cameras to Home Assistant over MQTT discovery (see publisher.go).
+type HomeAssistantConfig struct {
+	Enabled         bool           `yaml:"enabled"`
+	DiscoveryPrefix string         `yaml:"discovery_prefix"`  // default homeassistant
+	Labels          []string       `yaml:"labels"`            // a binary sensor and a count per label, default [person, car]
+	OffDelaySeconds int            `yaml:"off_delay_seconds"` // "detected" turns off this long after the last detection, default 30
+	OffDelays       map[string]int `yaml:"off_delays"`        // per label, e.g. {car: 300}
+}
+
+// WebhooksConfig holds outgoing webhook endpoints and delivery settings (see webhooks.go).
+type WebhooksConfig struct {
+	Endpoints       []Webhook `yaml:"endpoints"`
+	MaxAttempts     int       `yaml:"max_attempts"`      // then a delivery is marked failed, default 8
+	RetrySeconds    int       `yaml:"retry_seconds"`     // first retry delay, doubling each time, default 10
+	MaxRetrySeconds int       `yaml:"max_retry_seconds"` // default 3600
+	VisitGapSeconds int       `yaml:"visit_gap_seconds"` // absence that starts a new visit, default 120
+}
+
+// ChatCacheConfig shares answers between identical questions (see chatcache.go).
+type ChatCacheConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	TTLSeconds int  `yaml:"ttl_seconds"` // how long an answer is reused, default 600
+	MaxEntries int  `yaml:"max_entries"` // default 256
+}
+
+// ChatConfig tunes the /chat pipeline.
+type ChatConfig struct {
+	HistoryTokenBudget int    `yaml:"history_token_budget"` // prior turns replayed to the LLM, default 1024
+	Tools              bool   `yaml:"tools"`                // let the model call detection tools (chattools.go)
+	MaxToolRounds      int    `yaml:"max_tool_rounds"`      // bound on tool-calling rounds, default 4
+	Verify             string `yaml:"verify"`               // ungrounded answers: flag (default), rewrite or off (grounding.go)
+
+	Vision VisionConfig    `yaml:"vision"`
+	Cache  ChatCacheConfig `yaml:"cache"`
 }
 
 // Config holds all global settings for the backend.
 type Config struct {
-	Subscriber    SubscriberConfig `yaml:"subscriber"`
-	RetentionDays int              `yaml:"retention_days"`
-	Cameras       []CameraConfig   `yaml:"cameras"`
+	Subscriber    SubscriberConfig    `yaml:"subscriber"`
+	RetentionDays int                 `yaml:"retention_days"`
+	Timezone      string              `yaml:"timezone"` // IANA name, e.g. Europe/London; default: system timezone
+	Cameras       []CameraConfig      `yaml:"cameras"`
+	CameraGroups  map[string][]string `yaml:"camera_groups"` // group name → camera IDs, e.g. outside: [garage_webcam]
+	Snapshots     SnapshotConfig      `yaml:"snapshots"`
+	LLM           LLMConfig           `yaml:"llm"`
+	Chat          ChatConfig          `yaml:"chat"`
+	Embeddings    EmbeddingsConfig    `yaml:"embeddings"`
+	Prompts       PromptsConfig       `yaml:"prompts"`
+	Digests       DigestConfig        `yaml:"digests"`
+	Notifications NotificationsConfig `yaml:"notifications"`
+	MQTT          MQTTConfig          `yaml:"mqtt"`
+	Rules         []Rule              `yaml:"rules"` // see rules.go
+	Crossings     CrossingConfig      `yaml:"crossings"`
+	Dwell         DwellConfig         `yaml:"dwell"`
+	HomeAssistant HomeAssistantConfig `yaml:"home_assistant"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks"`
 }
 
+// configDir holds config.yaml and anything it references by relative path.
+const configDir = "../config"
+
 // loadConfig reads and parses the YAML config file and RETURNS it.
 func loadConfig() Config {
 	var cfg Config
 
-	data, err := os.ReadFile("../config/config.yaml")
+	data, err := os.ReadFile(filepath.Join(configDir, "config.yaml"))
 	if err != nil {
 		log.Fatalf("Failed to read config.yaml: %v", err)
 	}
@@ -44,6 +252,9 @@ func loadConfig() Config {
 		log.Fatalf("Failed to parse config.yaml: %v", err)
 	}
 
-	fmt.Printf("[Config] Loaded: %+v\n", cfg)
+	// Not the whole struct: it holds passwords, API keys and webhook secrets.
+	fmt.Printf("[Config] Loaded: %d cameras, snapshots on %s, llm %s/%s, %d rules, %d notification channels, %d webhooks\n",
+		len(cfg.Cameras), firstNonEmpty(cfg.Snapshots.Backend, "local"), firstNonEmpty(cfg.LLM.Provider, "openai"), cfg.LLM.Model,
+		len(cfg.Rules), len(cfg.Notifications.Channels), len(cfg.Webhooks.Endpoints))
 	return cfg
 }
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+conversations.go
+----------------
+
+Persistent multi-turn chat. Every /chat and /chat/stream turn is stored in
+SQLite with the objects the LLM extracted and the detection context it was
+given, so a follow-up like "and what about yesterday?" can be answered.
+
+Endpoints:
+- GET    /conversations            → list, most recently active first
+- GET    /conversations/{id}       → one conversation with all its messages
+- PATCH  /conversations/{id}       → rename: { "title": "..." }
+- DELETE /conversations/{id}       → delete it and its messages
+
+Conversations idle for longer than retention_days are deleted by the
+retention job, so one-off /chat calls don't pile up.
+*/
+
+// defaultHistoryTokenBudget caps how much prior conversation is replayed
+// to the LLM when chat.history_token_budget is not set.
+const defaultHistoryTokenBudget = 1024
+
+// Conversation is a chat session as returned by /conversations.
+type Conversation struct {
+	ID        int64                 `json:"id"`
+	Title     string                `json:"title"`
+	CameraID  string                `json:"camera_id"`
+	CreatedAt float64               `json:"created_at"`
+	UpdatedAt float64               `json:"updated_at"`
+	Messages  []ConversationMessage `json:"messages,omitempty"`
+}
+
+// ConversationMessage is one stored turn. Objects and Context are only set
+// on assistant messages.
+type ConversationMessage struct {
+	ID        int64    `json:"id"`
+	Role      string   `json:"role"`
+	Content   string   `json:"content"`
+	Objects   []string `json:"objects,omitempty"`
+	Context   string   `json:"context,omitempty"`
+	CreatedAt float64  `json:"created_at"`
+}
+
+// createConversation starts a new conversation titled after its first question.
+func createConversation(ctx context.Context, d *sql.DB, cameraID, firstMessage string) (int64, error) {
+	title := strings.TrimSpace(firstMessage)
+	if r := []rune(title); len(r) > 60 {
+		title = string(r[:57]) + "..."
+	}
+	now := float64(time.Now().Unix())
+	res, err := d.ExecContext(ctx,
+		"INSERT INTO conversations (title, camera_id, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		title, cameraID, now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// pruneConversations deletes conversations, and their messages, that have
+// not been active since cutoff. Returns how many were deleted.
+func pruneConversations(d *sql.DB, cutoff int64) (int64, error) {
+	res, err := d.Exec("DELETE FROM conversations WHERE updated_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	_, err = d.Exec("DELETE FROM conversation_messages WHERE conversation_id NOT IN (SELECT id FROM conversations)")
+	return n, err
+}
+
+// conversationExists reports whether id refers to a stored conversation.
+func conversationExists(ctx context.Context, d *sql.DB, id int64) (bool, error) {
+	var n int
+	err := d.QueryRowContext(ctx, "SELECT COUNT(*) FROM conversations WHERE id = ?", id).Scan(&n)
+	return n > 0, err
+}
+
+// saveTurn stores a question and its answer, plus what the answer was based on.
+func saveTurn(ctx context.Context, d *sql.DB, convID int64, question, answer string, plan *chatPlan) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := float64(time.Now().Unix())
+	objects, _ := json.Marshal(plan.Objects)
+	if _, err := tx.Exec(
+		"INSERT INTO conversation_messages (conversation_id, role, content, created_at) VALUES (?, 'user', ?, ?)",
+		convID, question, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO conversation_messages (conversation_id, role, content, objects, context, created_at) VALUES (?, 'assistant', ?, ?, ?, ?)",
+		convID, answer, string(objects), plan.Context, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE conversations SET updated_at = ? WHERE id = ?", now, convID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadMessages returns all messages of a conversation, oldest first.
+func loadMessages(ctx context.Context, d *sql.DB, convID int64) ([]ConversationMessage, error) {
+	rows, err := d.QueryContext(ctx, `
+		SELECT id, role, content, COALESCE(objects, ''), COALESCE(context, ''), created_at
+		FROM conversation_messages WHERE conversation_id = ? ORDER BY id`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []ConversationMessage
+	for rows.Next() {
+		var m ConversationMessage
+		var objects string
+		if err := rows.Scan(&m.ID, &m.Role, &m.Content, &objects, &m.Context, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if objects != "" {
+			json.Unmarshal([]byte(objects), &m.Objects)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// estimateTokens is a cheap, model-agnostic token estimate (~4 chars/token).
+func estimateTokens(s string) int {
+	return len(s)/4 + 1
+}
+
+// chatHistory returns the most recent turns of a conversation that fit in
+// budget tokens (oldest first, as the LLM expects), plus the objects the
+// last answer was about, for follow-ups that don't name an object.
+func chatHistory(ctx context.Context, d *sql.DB, convID int64, budget int) ([]ChatMessage, []string, error) {
+	msgs, err := loadMessages(ctx, d, convID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lastObjects []string
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == "assistant" && len(msgs[i].Objects) > 0 {
+			lastObjects = msgs[i].Objects
+			break
+		}
+	}
+
+	// Walk back from the newest turn until the budget runs out.
+	used := 0
+	start := len(msgs)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		cost := estimateTokens(msgs[i].Content)
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start = i
+	}
+	// Never start on a dangling assistant reply.
+	if start < len(msgs) && msgs[start].Role == "assistant" {
+		start++
+	}
+
+	history := make([]ChatMessage, 0, len(msgs)-start)
+	for _, m := range msgs[start:] {
+		history = append(history, ChatMessage{Role: m.Role, Content: m.Content})
+	}
+	return history, lastObjects, nil
+}
+
+// historyBudget returns the configured history token budget.
+func (app *App) historyBudget() int {
+	if app.Config.Chat.HistoryTokenBudget > 0 {
+		return app.Config.Chat.HistoryTokenBudget
+	}
+	return defaultHistoryTokenBudget
+}
+
+// startTurn resolves the conversation for a chat request, creating one if
+// none was given, and loads its history into req. A created conversation is
+// dropped again by abandonTurn if no answer comes of the request.
+func (app *App) startTurn(ctx context.Context, req *chatQuery) error {
+	if req.ConversationID == 0 {
+		id, err := createConversation(ctx, app.DB, req.CameraID, req.Message)
+		if err != nil {
+			return err
+		}
+		req.ConversationID = id
+		req.created = true
+		return nil
+	}
+
+	ok, err := conversationExists(ctx, app.DB, req.ConversationID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errConversationNotFound
+	}
+	req.history, req.lastObjects, err = chatHistory(ctx, app.DB, req.ConversationID, app.historyBudget())
+	return err
+}
+
+// finishTurn stores the turn; failures are logged, the answer still goes out.
+func (app *App) finishTurn(req chatQuery, answer string, plan *chatPlan) {
+	if err := saveTurn(context.Background(), app.DB, req.ConversationID, req.Message, answer, plan); err != nil {
+		log.Printf("Failed to save chat turn for conversation %d: %v", req.ConversationID, err)
+	}
+}
+
+// abandonTurn deletes the conversation startTurn created for a request that
+// failed or was cancelled (e.g. a /chat/stream client going away), so it
+// doesn't linger with no messages. Earlier conversations are left alone.
+func (app *App) abandonTurn(req chatQuery) {
+	if !req.created {
+		return
+	}
+	if _, err := app.DB.Exec("DELETE FROM conversations WHERE id = ?", req.ConversationID); err != nil {
+		log.Printf("Failed to delete abandoned conversation %d: %v", req.ConversationID, err)
+	}
+}
+
+// handleConversations handles GET /conversations.
+func (app *App) handleConversations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := "SELECT id, COALESCE(title, ''), COALESCE(camera_id, ''), created_at, updated_at FROM conversations"
+	var args []interface{}
+	if cameraID := r.URL.Query().Get("camera_id"); cameraID != "" {
+		query += " WHERE camera_id = ?"
+		args = append(args, cameraID)
+	}
+	query += " ORDER BY updated_at DESC LIMIT 100"
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("List conversations failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	convs := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.Title, &c.CameraID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			log.Printf("Conversation row scan failed: %v", err)
+			continue
+		}
+		convs = append(convs, c)
+	}
+	json.NewEncoder(w).Encode(convs)
+}
+
+// handleConversation handles GET/PATCH/DELETE /conversations/{id}.
+func (app *App) handleConversation(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/conversations/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid conversation id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var c Conversation
+		err := app.DB.QueryRow(
+			"SELECT id, COALESCE(title, ''), COALESCE(camera_id, ''), created_at, updated_at FROM conversations WHERE id = ?", id,
+		).Scan(&c.ID, &c.Title, &c.CameraID, &c.CreatedAt, &c.UpdatedAt)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			c.Messages, err = loadMessages(r.Context(), app.DB, id)
+		}
+		if err != nil {
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			log.Printf("Load conversation %d failed: %v", id, err)
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+
+	case http.MethodPatch:
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Title) == "" {
+			http.Error(w, "Need JSON with a non-empty 'title'", http.StatusBadRequest)
+			return
+		}
+		res, err := app.DB.Exec("UPDATE conversations SET title = ? WHERE id = ?", strings.TrimSpace(body.Title), id)
+		if err != nil {
+			http.Error(w, "Rename failed", http.StatusInternalServerError)
+			log.Printf("Rename conversation %d failed: %v", id, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		res, err := app.DB.Exec("DELETE FROM conversations WHERE id = ?", id)
+		if err == nil {
+			_, err = app.DB.Exec("DELETE FROM conversation_messages WHERE conversation_id = ?", id)
+		}
+		if err != nil {
+			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Delete conversation %d failed: %v", id, err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
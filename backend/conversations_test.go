@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFailedChatLeavesNoConversation(t *testing.T) {
+	app := newTestApp(t, &Config{})
+	fake := app.LLM
+	down := newLLMServer(t, llmReply{500, []string{`{"error":"down"}`}})
+	conversations := func() int {
+		var n int
+		app.DB.QueryRow("SELECT COUNT(*) FROM conversations").Scan(&n)
+		return n
+	}
+	chat := func(body string) int {
+		w := httptest.NewRecorder()
+		app.handleChat(w, httptest.NewRequest("POST", "/chat", strings.NewReader(body)))
+		return w.Code
+	}
+	stream := func(body string) string {
+		w := httptest.NewRecorder()
+		app.handleChatStream(w, httptest.NewRequest("POST", "/chat/stream", strings.NewReader(body)))
+		return w.Body.String()
+	}
+
+	app.LLM = newTestProvider(t, "ollama", down.URL, 0)
+	if code := chat(`{"camera_id":"garage","message":"any cars?"}`); code != http.StatusInternalServerError {
+		t.Fatalf("/chat with the LLM down: status %d", code)
+	}
+	if body := stream(`{"camera_id":"garage","message":"any cars?"}`); !strings.Contains(body, "event: error") {
+		t.Fatalf("/chat/stream with the LLM down:\n%s", body)
+	}
+	if n := conversations(); n != 0 {
+		t.Errorf("%d conversations left by failed chats, want 0", n)
+	}
+
+	// A conversation that has an answer survives a failed follow-up.
+	app.LLM = fake
+	if code := chat(`{"camera_id":"garage","message":"any cars?"}`); code != http.StatusOK {
+		t.Fatalf("/chat: status %d", code)
+	}
+	app.LLM = newTestProvider(t, "ollama", down.URL, 0)
+	chat(`{"camera_id":"garage","message":"and yesterday?","conversation_id":1}`)
+	stream(`{"camera_id":"garage","message":"and yesterday?","conversation_id":1}`)
+	if n := conversations(); n != 1 {
+		t.Errorf("%d conversations after failed follow-ups, want 1", n)
+	}
+}
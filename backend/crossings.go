@@ -0,0 +1,597 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+crossings.go
+------------
+
+Tripwires count objects crossing a line, e.g. people going in and out of
+the gate:
+
+	cameras:
+	  - id: gate_cam
+	    lines:
+	      - name: gate
+	        from: [0.2, 0.6]      # x, y as fractions of the frame
+	        to: [0.8, 0.6]
+	        inside: right         # side that is "in", looking from `from` to `to`: right (default) or left
+	        labels: [person]      # default [person]
+	        area: garden          # occupancy area, default the line name
+
+	crossings:
+	  max_gap_seconds: 3          # longer between two events and tracks restart
+	  max_jump: 0.25              # furthest an object moves between events, as a fraction of the frame
+
+Every event a camera publishes (before dedup/throttle) is matched to the
+previous one: each object is paired with the nearest unpaired object of
+the same label within max_jump. When the path between a pair's anchor
+points (middle of the box's bottom edge) crosses a line, a crossing is
+stored with its direction, "in" or "out".
+
+GET /crossings lists them; GET /counts totals them per interval, together
+with each area's occupancy: ins minus outs (never below zero) since it was
+last set with POST /counts/occupancy. An object standing exactly on a line
+counts as still being on the side it came from.
+
+The retention job snapshots each area's occupancy into occupancy_resets
+and then deletes crossings older than retention_days, so occupancy never
+has to replay more than an hour or so of crossings.
+*/
+
+const (
+	defaultCrossingMaxGap  = 3.0
+	defaultCrossingMaxJump = 0.25
+)
+
+// Tripwire is a counting line on one camera.
+type Tripwire struct {
+	Name   string    `yaml:"name" json:"name"`
+	From   []float64 `yaml:"from" json:"from"`
+	To     []float64 `yaml:"to" json:"to"`
+	Inside string    `yaml:"inside" json:"inside,omitempty"` // right (default) or left
+	Labels []string  `yaml:"labels" json:"labels,omitempty"`
+	Area   string    `yaml:"area" json:"area,omitempty"`
+}
+
+// Crossing is one object crossing a tripwire.
+type Crossing struct {
+	ID        int64   `json:"id"`
+	CameraID  string  `json:"camera_id"`
+	Line      string  `json:"line"`
+	Area      string  `json:"area"`
+	Label     string  `json:"label"`
+	Direction string  `json:"direction"` // in or out
+	Time      string  `json:"time"`
+	Timestamp float64 `json:"timestamp"`
+}
+
+// trackedObject is an object's anchor point in the previous event.
+type trackedObject struct {
+	label string
+	x, y  float64
+	// sides remembers, per line name, the side the object was last
+	// strictly on, for while it stands exactly on the line.
+	sides map[string]float64
+}
+
+// sideOf is the side of l the object is on; on the line, the side it came from.
+func (o trackedObject) sideOf(l Tripwire) float64 {
+	if s := l.side(o.x, o.y); s != 0 {
+		return s
+	}
+	return o.sides[l.Name]
+}
+
+// crossingTracker remembers each camera's previous event.
+type crossingTracker struct {
+	mu   sync.Mutex
+	last map[string]trackedFrame
+}
+
+type trackedFrame struct {
+	ts      float64
+	objects []trackedObject
+}
+
+func newCrossingTracker() *crossingTracker {
+	return &crossingTracker{last: map[string]trackedFrame{}}
+}
+
+// validateTripwire checks a line and fills in its defaults.
+func validateTripwire(l *Tripwire) error {
+	if l.Name == "" {
+		return fmt.Errorf("line without a name")
+	}
+	for _, p := range [][]float64{l.From, l.To} {
+		if len(p) != 2 || p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+			return fmt.Errorf("line %q: from and to must be [x, y] with 0 <= x, y <= 1", l.Name)
+		}
+	}
+	if l.From[0] == l.To[0] && l.From[1] == l.To[1] {
+		return fmt.Errorf("line %q: from and to are the same point", l.Name)
+	}
+	switch l.Inside {
+	case "":
+		l.Inside = "right"
+	case "left", "right":
+	default:
+		return fmt.Errorf("line %q: inside must be left or right", l.Name)
+	}
+	if len(l.Labels) == 0 {
+		l.Labels = []string{"person"}
+	}
+	if l.Area == "" {
+		l.Area = l.Name
+	}
+	return nil
+}
+
+// cameraLines returns a camera's tripwires.
+func (app *App) cameraLines(camera string) []Tripwire {
+	for _, cam := range app.Config.Cameras {
+		if cam.ID == camera {
+			return cam.Lines
+		}
+	}
+	return nil
+}
+
+// side is > 0 when p is right of the line looking from `from` to `to` (in
+// image coordinates, y down), < 0 when left.
+func (l Tripwire) side(x, y float64) float64 {
+	return (l.To[0]-l.From[0])*(y-l.From[1]) - (l.To[1]-l.From[1])*(x-l.From[0])
+}
+
+// crossed returns "in" or "out" when the move from a to b crosses the
+// line, or "" when it doesn't.
+func (l Tripwire) crossed(a, b trackedObject) string {
+	// A point on the line counts as still being on the side it came from,
+	// so stopping on the line and carrying on is one crossing, not none.
+	sa, sb := a.sideOf(l), l.side(b.x, b.y)
+	if sb == 0 {
+		sb = sa
+	}
+	if sa == 0 || (sa > 0) == (sb > 0) {
+		return ""
+	}
+	// The move must also pass between the line's end points.
+	move := Tripwire{From: []float64{a.x, a.y}, To: []float64{b.x, b.y}}
+	if (move.side(l.From[0], l.From[1]) > 0) == (move.side(l.To[0], l.To[1]) > 0) {
+		return ""
+	}
+	if (sb > 0) == (l.Inside == "right") {
+		return "in"
+	}
+	return "out"
+}
+
+// anchorPoints turns boxes into normalised anchor points (middle of the
+// bottom edge), keeping only labels some line counts.
+func anchorPoints(labels []string, boxes [][]float64, width, height float64, lines []Tripwire) []trackedObject {
+	var objects []trackedObject
+	for i, b := range boxes {
+		if i >= len(labels) || len(b) < 4 {
+			continue
+		}
+		counted := false
+		for _, l := range lines {
+			counted = counted || containsFold(l.Labels, labels[i])
+		}
+		if counted {
+			objects = append(objects, trackedObject{label: labels[i], x: (b[0] + b[2]) / 2 / width, y: b[3] / height})
+		}
+	}
+	return objects
+}
+
+// track pairs the objects of an event with the camera's previous event
+// and returns the line crossings between them.
+func (t *crossingTracker) track(camera string, ts float64, objects []trackedObject, lines []Tripwire, maxGap, maxJump float64) []Crossing {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, ok := t.last[camera]
+	t.last[camera] = trackedFrame{ts: ts, objects: objects}
+	if !ok || ts <= prev.ts || ts-prev.ts > maxGap {
+		return nil
+	}
+
+	var crossings []Crossing
+	used := make([]bool, len(prev.objects))
+	for k := range objects {
+		cur := &objects[k]
+		best, bestDist := -1, maxJump
+		for i, p := range prev.objects {
+			if used[i] || p.label != cur.label {
+				continue
+			}
+			if d := math.Hypot(cur.x-p.x, cur.y-p.y); d <= bestDist {
+				best, bestDist = i, d
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		used[best] = true
+		cur.sides = map[string]float64{}
+		for _, l := range lines {
+			cur.sides[l.Name] = cur.sideOf(l)
+			if cur.sides[l.Name] == 0 {
+				cur.sides[l.Name] = prev.objects[best].sideOf(l)
+			}
+			if !containsFold(l.Labels, cur.label) {
+				continue
+			}
+			if dir := l.crossed(prev.objects[best], *cur); dir != "" {
+				crossings = append(crossings, Crossing{CameraID: camera, Line: l.Name, Area: l.Area, Label: cur.label, Direction: dir, Timestamp: ts})
+			}
+		}
+	}
+	return crossings
+}
+
+// trackCrossings runs the tracker on an incoming event and stores any
+// crossings. Called for every event, before dedup.
+func (app *App) trackCrossings(camera string, ts float64, labels []string, boxes [][]float64, width, height float64) {
+	lines := app.cameraLines(camera)
+	if len(lines) == 0 || width <= 0 || height <= 0 {
+		return
+	}
+	maxGap := app.Config.Crossings.MaxGapSeconds
+	if maxGap <= 0 {
+		maxGap = defaultCrossingMaxGap
+	}
+	maxJump := app.Config.Crossings.MaxJump
+	if maxJump <= 0 {
+		maxJump = defaultCrossingMaxJump
+	}
+	objects := anchorPoints(labels, boxes, width, height, lines)
+	for _, c := range app.Tracks.track(camera, ts, objects, lines, maxGap, maxJump) {
+		if _, err := app.DB.Exec(`
+			INSERT INTO crossings (camera_id, line, area, label, direction, timestamp, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			c.CameraID, c.Line, c.Area, c.Label, c.Direction, c.Timestamp, float64(time.Now().Unix())); err != nil {
+			log.Printf("[Crossings] Failed to store crossing: %v", err)
+			continue
+		}
+		log.Printf("[Crossings] %s crossed %s on %s: %s", c.Label, c.Line, c.CameraID, c.Direction)
+	}
+}
+
+// crossingFilter builds the WHERE clause shared by /crossings and /counts.
+func crossingFilter(r *http.Request) (string, []interface{}) {
+	where := "1=1"
+	var args []interface{}
+	for _, col := range []string{"camera_id", "line", "area", "label"} {
+		if v := r.URL.Query().Get(col); v != "" {
+			where += " AND " + col + " = ?"
+			args = append(args, v)
+		}
+	}
+	return where, args
+}
+
+// handleCrossings handles GET /crossings?camera_id=&line=&area=&label=&start_time=&end_time=&limit=,
+// newest first.
+func (app *App) handleCrossings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	where, args := crossingFilter(r)
+	if v, err := strconv.ParseFloat(q.Get("start_time"), 64); err == nil {
+		where += " AND timestamp >= ?"
+		args = append(args, v)
+	}
+	if v, err := strconv.ParseFloat(q.Get("end_time"), 64); err == nil {
+		where += " AND timestamp <= ?"
+		args = append(args, v)
+	}
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	rows, err := app.DB.Query("SELECT id, camera_id, line, area, label, direction, timestamp FROM crossings WHERE "+where+
+		" ORDER BY timestamp DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("Crossings query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	crossings := []Crossing{}
+	for rows.Next() {
+		var c Crossing
+		if err := rows.Scan(&c.ID, &c.CameraID, &c.Line, &c.Area, &c.Label, &c.Direction, &c.Timestamp); err != nil {
+			log.Printf("Crossing row scan failed: %v", err)
+			continue
+		}
+		c.Time = time.Unix(int64(c.Timestamp), 0).In(app.Loc).Format(time.RFC3339)
+		crossings = append(crossings, c)
+	}
+	json.NewEncoder(w).Encode(crossings)
+}
+
+// countInterval is one bucket of a /counts response.
+type countInterval struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Entered int    `json:"entered"`
+	Exited  int    `json:"exited"`
+}
+
+// intervalStart returns the start of the hour, day or week (from Monday) containing t.
+func intervalStart(interval string, t time.Time) time.Time {
+	switch interval {
+	case "day":
+		start, _, _ := digestPeriod("daily", t)
+		return start
+	case "week":
+		start, _, _ := digestPeriod("weekly", t)
+		return start
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// nextInterval returns the start of the interval after the one starting at t.
+func nextInterval(interval string, t time.Time) time.Time {
+	switch interval {
+	case "day":
+		return t.AddDate(0, 0, 1)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	}
+	return t.Add(time.Hour)
+}
+
+// occupancy returns each area's occupancy: the value last set with POST
+// /counts/occupancy (or snapshotted by retention) plus the crossings since,
+// never below zero.
+func (app *App) occupancy(areas []string) (map[string]int, error) {
+	occ := map[string]int{}
+	for _, area := range areas {
+		n, _, err := app.areaOccupancy(area, math.MaxFloat64)
+		if err != nil {
+			return nil, err
+		}
+		occ[area] = n
+	}
+	return occ, nil
+}
+
+// areaOccupancy returns an area's occupancy as of until, and the time of
+// the value it started from.
+func (app *App) areaOccupancy(area string, until float64) (int, float64, error) {
+	var since float64
+	var n int
+	err := app.DB.QueryRow("SELECT timestamp, value FROM occupancy_resets WHERE area = ?", area).Scan(&since, &n)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+	rows, err := app.DB.Query("SELECT direction FROM crossings WHERE area = ? AND timestamp > ? AND timestamp <= ? ORDER BY timestamp",
+		area, since, until)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dir string
+		if err := rows.Scan(&dir); err != nil {
+			return 0, 0, err
+		}
+		if dir == "in" {
+			n++
+		} else if n > 0 {
+			n--
+		}
+	}
+	return n, since, rows.Err()
+}
+
+// pruneCrossings snapshots every area's occupancy as of a minute ago into
+// occupancy_resets, so /counts only replays crossings since, then deletes
+// crossings older than before. Called by the retention job.
+func (app *App) pruneCrossings(before float64) (int64, error) {
+	at := float64(app.Now().Add(-time.Minute).Unix())
+	rows, err := app.DB.Query("SELECT DISTINCT area FROM crossings")
+	if err != nil {
+		return 0, err
+	}
+	var areas []string
+	for rows.Next() {
+		var area string
+		if err := rows.Scan(&area); err == nil {
+			areas = append(areas, area)
+		}
+	}
+	rows.Close()
+
+	for _, area := range areas {
+		n, since, err := app.areaOccupancy(area, at)
+		if err != nil {
+			return 0, err
+		}
+		if since >= at {
+			continue // set more recently than the snapshot would be
+		}
+		if _, err := app.DB.Exec(`
+			INSERT INTO occupancy_resets (area, timestamp, value) VALUES (?, ?, ?)
+			ON CONFLICT (area) DO UPDATE SET timestamp = excluded.timestamp, value = excluded.value
+			WHERE excluded.timestamp > occupancy_resets.timestamp`, area, at, n); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := app.DB.Exec("DELETE FROM crossings WHERE timestamp < ? AND timestamp <= ?", before, at)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// handleCounts handles GET /counts: entered/exited totals per interval.
+// Query: camera_id, line, area, label; start_time/end_time (Unix seconds,
+// default today so far); interval hour (default), day or week.
+func (app *App) handleCounts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	interval := q.Get("interval")
+	switch interval {
+	case "":
+		interval = "hour"
+	case "hour", "day", "week":
+	default:
+		http.Error(w, "interval must be hour, day or week", http.StatusBadRequest)
+		return
+	}
+	now := app.now()
+	end := now
+	if v, err := strconv.ParseFloat(q.Get("end_time"), 64); err == nil {
+		end = time.Unix(int64(v), 0).In(app.Loc)
+	}
+	start, _, _ := digestPeriod("daily", end)
+	if v, err := strconv.ParseFloat(q.Get("start_time"), 64); err == nil {
+		start = time.Unix(int64(v), 0).In(app.Loc)
+	}
+	if !start.Before(end) {
+		http.Error(w, "start_time must be before end_time", http.StatusBadRequest)
+		return
+	}
+
+	// Buckets from the interval containing start up to end.
+	var buckets []countInterval
+	var bounds []time.Time
+	for t := intervalStart(interval, start); t.Before(end); t = nextInterval(interval, t) {
+		bounds = append(bounds, t)
+		buckets = append(buckets, countInterval{
+			Start: t.Format(time.RFC3339),
+			End:   nextInterval(interval, t).Format(time.RFC3339),
+		})
+		if len(buckets) > 2000 {
+			http.Error(w, "Too many intervals, use a larger interval", http.StatusBadRequest)
+			return
+		}
+	}
+
+	where, args := crossingFilter(r)
+	rows, err := app.DB.Query("SELECT direction, timestamp FROM crossings WHERE "+where+
+		" AND timestamp >= ? AND timestamp < ? ORDER BY timestamp", append(args, float64(start.Unix()), float64(end.Unix()))...)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("Counts query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	entered, exited := 0, 0
+	b := 0
+	for rows.Next() {
+		var dir string
+		var ts float64
+		if err := rows.Scan(&dir, &ts); err != nil {
+			log.Printf("Counts row scan failed: %v", err)
+			continue
+		}
+		t := time.Unix(int64(ts), 0)
+		for b+1 < len(bounds) && !t.Before(bounds[b+1]) {
+			b++
+		}
+		if dir == "in" {
+			buckets[b].Entered++
+			entered++
+		} else {
+			buckets[b].Exited++
+			exited++
+		}
+	}
+
+	// Occupancy of the areas the filters cover.
+	var areas []string
+	if a := q.Get("area"); a != "" {
+		areas = []string{a}
+	} else {
+		for _, cam := range app.Config.Cameras {
+			if id := q.Get("camera_id"); id != "" && id != cam.ID {
+				continue
+			}
+			for _, l := range cam.Lines {
+				if line := q.Get("line"); line == "" || line == l.Name {
+					areas = append(areas, l.Area)
+				}
+			}
+		}
+	}
+	occ, err := app.occupancy(uniqueStrings(areas))
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("Occupancy query failed: %v", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"start":     start.Format(time.RFC3339),
+		"end":       end.Format(time.RFC3339),
+		"interval":  interval,
+		"entered":   entered,
+		"exited":    exited,
+		"intervals": buckets,
+		"occupancy": occ,
+	})
+}
+
+// handleOccupancy handles POST /counts/occupancy {"area": "garden", "occupancy": 0},
+// setting an area's occupancy from now on (e.g. to 0 at closing time).
+func (app *App) handleOccupancy(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Area      string `json:"area"`
+		Occupancy int    `json:"occupancy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Area == "" || req.Occupancy < 0 {
+		http.Error(w, "Body must be {\"area\": \"...\", \"occupancy\": n >= 0}", http.StatusBadRequest)
+		return
+	}
+	if _, err := app.DB.Exec("INSERT OR REPLACE INTO occupancy_resets (area, timestamp, value) VALUES (?, ?, ?)",
+		req.Area, float64(app.Now().Unix()), req.Occupancy); err != nil {
+		http.Error(w, "Update failed", http.StatusInternalServerError)
+		log.Printf("Set occupancy failed: %v", err)
+		return
+	}
+	log.Printf("[Crossings] Occupancy of %s set to %d", req.Area, req.Occupancy)
+	json.NewEncoder(w).Encode(map[string]interface{}{"area": req.Area, "occupancy": req.Occupancy})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTripwireCrossed(t *testing.T) {
+	// Horizontal line across the middle; "right" of from→to is below it.
+	door := Tripwire{Name: "door", From: []float64{0, 0.5}, To: []float64{1, 0.5}, Inside: "right"}
+	short := Tripwire{Name: "gate", From: []float64{0.2, 0.5}, To: []float64{0.6, 0.5}, Inside: "right"}
+	left := Tripwire{Name: "door", From: []float64{0, 0.5}, To: []float64{1, 0.5}, Inside: "left"}
+	at := func(x, y float64) trackedObject { return trackedObject{label: "person", x: x, y: y} }
+
+	tests := []struct {
+		name string
+		line Tripwire
+		a, b trackedObject
+		want string
+	}{
+		{"down across", door, at(0.5, 0.3), at(0.5, 0.7), "in"},
+		{"up across", door, at(0.5, 0.7), at(0.5, 0.3), "out"},
+		{"diagonal across", door, at(0.1, 0.2), at(0.4, 0.9), "in"},
+		{"same side", door, at(0.2, 0.3), at(0.8, 0.4), ""},
+		{"past the end of the line", short, at(0.8, 0.3), at(0.8, 0.7), ""},
+		{"between the end points", short, at(0.4, 0.3), at(0.4, 0.7), "in"},
+		{"stops on the line", door, at(0.5, 0.3), at(0.5, 0.5), ""},
+		{"leaves the line it stopped on", door,
+			trackedObject{label: "person", x: 0.5, y: 0.5, sides: map[string]float64{"door": -0.2}}, at(0.5, 0.7), "in"},
+		{"goes back from the line", door,
+			trackedObject{label: "person", x: 0.5, y: 0.5, sides: map[string]float64{"door": -0.2}}, at(0.5, 0.3), ""},
+		{"starts on the line, no history", door, at(0.5, 0.5), at(0.5, 0.7), ""},
+		{"inside is left", left, at(0.5, 0.3), at(0.5, 0.7), "out"},
+	}
+	for _, tt := range tests {
+		if got := tt.line.crossed(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: crossed = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCrossingTrackerTrack(t *testing.T) {
+	lines := []Tripwire{{Name: "door", From: []float64{0, 0.5}, To: []float64{1, 0.5}, Inside: "right", Labels: []string{"person"}, Area: "house"}}
+	type frame struct {
+		ts      float64
+		objects []trackedObject
+		want    []string // directions
+	}
+	person := func(x, y float64) trackedObject { return trackedObject{label: "person", x: x, y: y} }
+
+	tests := []struct {
+		name   string
+		frames []frame
+	}{
+		{"walks in", []frame{
+			{0, []trackedObject{person(0.5, 0.4)}, nil},
+			{1, []trackedObject{person(0.5, 0.6)}, []string{"in"}},
+		}},
+		{"pauses on the line", []frame{
+			{0, []trackedObject{person(0.5, 0.4)}, nil},
+			{1, []trackedObject{person(0.5, 0.5)}, nil},
+			{2, []trackedObject{person(0.5, 0.5)}, nil},
+			{3, []trackedObject{person(0.5, 0.6)}, []string{"in"}},
+		}},
+		{"gap too long", []frame{
+			{0, []trackedObject{person(0.5, 0.4)}, nil},
+			{10, []trackedObject{person(0.5, 0.6)}, nil},
+		}},
+		{"jump too far", []frame{
+			{0, []trackedObject{person(0.1, 0.3)}, nil},
+			{1, []trackedObject{person(0.9, 0.7)}, nil},
+		}},
+		{"label not counted", []frame{
+			{0, []trackedObject{{label: "car", x: 0.5, y: 0.4}}, nil},
+			{1, []trackedObject{{label: "car", x: 0.5, y: 0.6}}, nil},
+		}},
+		{"two people pass each other", []frame{
+			{0, []trackedObject{person(0.2, 0.4), person(0.8, 0.6)}, nil},
+			{1, []trackedObject{person(0.2, 0.6), person(0.8, 0.4)}, []string{"in", "out"}},
+		}},
+		{"older event", []frame{
+			{5, []trackedObject{person(0.5, 0.4)}, nil},
+			{4, []trackedObject{person(0.5, 0.6)}, nil},
+		}},
+	}
+	for _, tt := range tests {
+		tr := newCrossingTracker()
+		for i, f := range tt.frames {
+			var got []string
+			for _, c := range tr.track("cam", f.ts, f.objects, lines, 3, 0.25) {
+				if c.Line != "door" || c.Area != "house" || c.CameraID != "cam" || c.Timestamp != f.ts {
+					t.Errorf("%s: frame %d: unexpected crossing %+v", tt.name, i, c)
+				}
+				got = append(got, c.Direction)
+			}
+			if !reflect.DeepEqual(got, f.want) {
+				t.Errorf("%s: frame %d: crossings %v, want %v", tt.name, i, got, f.want)
+			}
+		}
+	}
+}
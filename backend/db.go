@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	_ "github.com/mattn/go-sqlite3"
 	"fmt"
 	"log"
@@ -31,8 +32,14 @@ func initDB() {
 		}
 	}
 
+	openDB("./data/detections.db")
+}
+
+// openDB opens the SQLite DB at path as the global db and ensures the
+// schema exists. The eval command (eval.go) points it at a scratch file.
+func openDB(path string) {
 	var err error
-	db, err = sql.Open("sqlite3", "./data/detections.db")
+	db, err = sql.Open("sqlite3", path)
 	if err != nil {
 		log.Fatalf("Failed to open SQLite DB: %v", err)
 	}
@@ -44,7 +51,8 @@ func initDB() {
 		camera_id TEXT,
 		labels TEXT,
 		boxes TEXT,
-		snapshot_file TEXT
+		snapshot_file TEXT,
+		confidences TEXT
 	);
 	`
 	_, err = db.Exec(createTableSQL)
@@ -52,12 +60,273 @@ func initDB() {
 		log.Fatalf("Failed to create table: %v", err)
 	}
 
+	// Columns added after the first release; older DBs get them via ALTER TABLE.
+	ensureColumn("detections", "confidences", "TEXT")
+	ensureColumn("detections", "zones", "TEXT")
+
+	// Legal holds: either a single event (event_id) or a camera + time range.
+	createTable("holds", `
+	CREATE TABLE IF NOT EXISTS holds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id INTEGER,
+		camera_id TEXT,
+		start_time REAL,
+		end_time REAL,
+		reason TEXT,
+		created_by TEXT,
+		created_at REAL
+	);
+	`)
+
+	// Chat conversations and their turns (see conversations.go).
+	createTable("conversations", `
+	CREATE TABLE IF NOT EXISTS conversations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT,
+		camera_id TEXT,
+		created_at REAL,
+		updated_at REAL
+	);
+	`)
+	createTable("conversation_messages", `
+	CREATE TABLE IF NOT EXISTS conversation_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id INTEGER,
+		role TEXT,
+		content TEXT,
+		objects TEXT,
+		context TEXT,
+		created_at REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_conv ON conversation_messages (conversation_id, id);
+	`)
+
+	// Embedded event and visit summaries for semantic search (see embeddings.go).
+	createTable("embeddings", `
+	CREATE TABLE IF NOT EXISTS embeddings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT,
+		event_id INTEGER,
+		event_ids TEXT,
+		camera_id TEXT,
+		start_time REAL,
+		end_time REAL,
+		labels TEXT,
+		snapshot_file TEXT,
+		text TEXT,
+		model TEXT,
+		vector BLOB
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_embeddings_kind_event ON embeddings (kind, event_id);
+	`)
+
+	// Daily/weekly activity digests per camera (see digests.go).
+	createTable("digests", `
+	CREATE TABLE IF NOT EXISTS digests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		camera_id TEXT,
+		period TEXT,
+		start_time REAL,
+		end_time REAL,
+		narrative TEXT,
+		data TEXT,
+		model TEXT,
+		created_at REAL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_digests_period ON digests (camera_id, period, start_time);
+	`)
+
+	// First detection of each label per camera, kept up to date at insert
+	// (digests.go uses it for "seen for the first time").
+	createTable("label_first_seen", `
+	CREATE TABLE IF NOT EXISTS label_first_seen (
+		camera_id TEXT,
+		label TEXT,
+		first_seen REAL,
+		PRIMARY KEY (camera_id, label)
+	);
+	`)
+	backfillFirstSeen()
+
+	// Rules added through the API and the log of rule firings (see rules.go).
+	createTable("rules", `
+	CREATE TABLE IF NOT EXISTS rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		definition TEXT,
+		created_at REAL,
+		updated_at REAL
+	);
+	`)
+	createTable("rule_firings", `
+	CREATE TABLE IF NOT EXISTS rule_firings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER,
+		rule TEXT,
+		event_id INTEGER,
+		camera_id TEXT,
+		timestamp REAL,
+		labels TEXT,
+		count INTEGER,
+		flags TEXT,
+		actions TEXT,
+		created_at REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_rule_firings_rule ON rule_firings (rule, id);
+	`)
+	ensureColumn("rule_firings", "kind", "TEXT")
+	ensureColumn("rule_firings", "dwell_seconds", "REAL")
+
+	// Webhook endpoints added through the API and the delivery queue/log (see webhooks.go).
+	createTable("webhooks", `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		definition TEXT,
+		created_at REAL,
+		updated_at REAL
+	);
+	`)
+	createTable("webhook_deliveries", `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook TEXT,
+		webhook_name TEXT,
+		event TEXT,
+		camera_id TEXT,
+		payload TEXT,
+		status TEXT,
+		attempts INTEGER,
+		next_attempt REAL,
+		last_status INTEGER,
+		last_error TEXT,
+		created_at REAL,
+		delivered_at REAL,
+		replay_of INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt);
+	`)
+
+	// Tripwire crossings and occupancy corrections (see crossings.go).
+	createTable("crossings", `
+	CREATE TABLE IF NOT EXISTS crossings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		camera_id TEXT,
+		line TEXT,
+		area TEXT,
+		label TEXT,
+		direction TEXT,
+		timestamp REAL,
+		created_at REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_crossings_area ON crossings (area, timestamp);
+	CREATE INDEX IF NOT EXISTS idx_crossings_camera ON crossings (camera_id, timestamp);
+	`)
+	createTable("occupancy_resets", `
+	CREATE TABLE IF NOT EXISTS occupancy_resets (
+		area TEXT PRIMARY KEY,
+		timestamp REAL,
+		value INTEGER
+	);
+	`)
+
+	// Loitering events raised by dwell alerts (see dwell.go).
+	createTable("dwell_events", `
+	CREATE TABLE IF NOT EXISTS dwell_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		alert TEXT,
+		camera_id TEXT,
+		label TEXT,
+		zones TEXT,
+		start_time REAL,
+		end_time REAL,
+		dwell_seconds REAL,
+		event_id INTEGER,
+		timestamp REAL,
+		snapshot_file TEXT,
+		created_at REAL
+	);
+	CREATE INDEX IF NOT EXISTS idx_dwell_events_camera ON dwell_events (camera_id, timestamp);
+	`)
+
+	// Zones added through the API (see zones.go).
+	createTable("zones", `
+	CREATE TABLE IF NOT EXISTS zones (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		camera_id TEXT,
+		name TEXT,
+		polygon TEXT,
+		anchor TEXT,
+		created_at REAL
+	);
+	`)
+
 	fmt.Println("[DB] SQLite initialized and table ready.")
 }
 
-// insertDetection inserts a detection event into the DB.
-func insertDetection(timestamp float64, cameraID string, labels string, boxes string, snapshotPath string) error {
-	stmt := `INSERT INTO detections (timestamp, camera_id, labels, boxes, snapshot_file) VALUES (?, ?, ?, ?, ?)`
-	_, err := db.Exec(stmt, timestamp, cameraID, labels, boxes, snapshotPath)
-	return err
+// createTable runs a CREATE TABLE IF NOT EXISTS (plus any indexes) or exits.
+func createTable(name, ddl string) {
+	if _, err := db.Exec(ddl); err != nil {
+		log.Fatalf("Failed to create %s table: %v", name, err)
+	}
+}
+
+// ensureColumn adds a column to an existing table if it is missing.
+func ensureColumn(table, column, decl string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		log.Fatalf("Failed to read schema of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err == nil && name == column {
+			return
+		}
+	}
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		log.Fatalf("Failed to add column %s.%s: %v", table, column, err)
+	}
+	fmt.Printf("[DB] Added column %s.%s\n", table, column)
+}
+
+// backfillFirstSeen fills an empty label_first_seen table from the stored
+// detections, once, for databases created before the table existed.
+func backfillFirstSeen() {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM label_first_seen").Scan(&n); err != nil || n > 0 {
+		return
+	}
+	_, err := db.Exec(`
+	INSERT OR IGNORE INTO label_first_seen (camera_id, label, first_seen)
+	SELECT camera_id, j.value, MIN(timestamp)
+	FROM detections, json_each(CASE WHEN json_valid(labels) THEN labels ELSE '[]' END) j
+	GROUP BY camera_id, j.value`)
+	if err != nil {
+		log.Printf("[DB] Failed to backfill label_first_seen: %v", err)
+	}
+}
+
+// insertDetection inserts a detection event into the DB and returns its ID.
+func insertDetection(timestamp float64, cameraID string, labels string, boxes string, confidences string, snapshotPath string, zones string) (int64, error) {
+	stmt := `INSERT INTO detections (timestamp, camera_id, labels, boxes, confidences, snapshot_file, zones) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.Exec(stmt, timestamp, cameraID, labels, boxes, confidences, snapshotPath, zones)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only costs a lookup per label; the detection is stored either way.
+	if json.Valid([]byte(labels)) {
+		_, err = db.Exec(`
+		INSERT INTO label_first_seen (camera_id, label, first_seen)
+		SELECT ?, value, ? FROM json_each(?) WHERE true
+		ON CONFLICT (camera_id, label) DO UPDATE SET first_seen = MIN(first_seen, excluded.first_seen)`,
+			cameraID, timestamp, labels)
+		if err != nil {
+			log.Printf("[DB] Failed to update label_first_seen: %v", err)
+		}
+	}
+	return res.LastInsertId()
 }
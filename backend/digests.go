@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+digests.go
+----------
+
+Activity digests summarise what each camera saw over a day or a week:
+
+	digests:
+	  enabled: true
+	  periods: [daily, weekly]   # weekly runs Monday to Monday
+	  hour: 7                    # local hour the previous period is written up
+	  notify: [household]        # notification channels (notify.go), optional
+
+Once a period is over (and it is past `hour`), a background job writes one
+digest per camera: counts per label, detections per hour of day, labels
+the camera had never seen before, and the snapshots of the rarest events,
+plus a short narrative written by the LLM from those facts (the "digest"
+prompt template). If the backend was down, the last missed period is
+caught up; older ones can be written with POST /digests.
+
+Digests are stored in the digests table and served from /digests.
+*/
+
+const (
+	// digestCheckInterval is how often the digest job looks for finished periods.
+	digestCheckInterval = 10 * time.Minute
+
+	defaultDigestTopSnapshots = 3
+)
+
+// Digest is one camera's summary of a period, as served by /digests.
+type Digest struct {
+	ID        int64      `json:"id"`
+	CameraID  string     `json:"camera_id"`
+	Period    string     `json:"period"` // daily or weekly
+	Start     string     `json:"start"`  // RFC3339, local time
+	End       string     `json:"end"`
+	Narrative string     `json:"narrative"`
+	Data      DigestData `json:"data"`
+	Model     string     `json:"model"` // "" when the narrative was not written by the LLM
+	CreatedAt float64    `json:"created_at"`
+}
+
+// DigestData is the structured part of a digest.
+type DigestData struct {
+	Detections   int           `json:"detections"`
+	Visits       int           `json:"visits"`
+	Labels       []LabelCount  `json:"labels"`        // most frequent first
+	Hours        [24]int       `json:"hours"`         // detections per local hour of day
+	BusiestHours []int         `json:"busiest_hours"` // up to 3 hours, busiest first
+	FirstSeen    []DigestFirst `json:"first_seen"`    // labels this camera had not seen before
+	TopSnapshots []DigestEvent `json:"top_snapshots"` // rarest events first
+}
+
+// LabelCount is how many detections included a label.
+type LabelCount struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// DigestFirst is the first detection of a label the camera had never seen.
+type DigestFirst struct {
+	Label string `json:"label"`
+	DigestEvent
+}
+
+// DigestEvent points at one detection in a digest.
+type DigestEvent struct {
+	EventID     int64    `json:"event_id"`
+	Time        string   `json:"time"`
+	Labels      []string `json:"labels"`
+	SnapshotURL string   `json:"snapshot_url,omitempty"`
+}
+
+// digestPeriod returns the daily or weekly period containing t.
+func digestPeriod(period string, t time.Time) (start, end time.Time, err error) {
+	start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case "daily":
+		return start, start.AddDate(0, 0, 1), nil
+	case "weekly":
+		// Weeks start on Monday.
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7), nil
+	}
+	return start, start, fmt.Errorf("unknown digest period %q (want daily or weekly)", period)
+}
+
+// lastFinishedPeriod returns the latest period that is due by now: over,
+// and written up at hour the day it ended.
+func lastFinishedPeriod(period string, now time.Time, hour int) (time.Time, time.Time, error) {
+	start, _, err := digestPeriod(period, now)
+	if err != nil {
+		return start, start, err
+	}
+	if now.Before(start.Add(time.Duration(hour) * time.Hour)) {
+		start, _, _ = digestPeriod(period, start.Add(-time.Hour))
+	}
+	return digestPeriod(period, start.Add(-time.Hour))
+}
+
+// describePeriod names a period for prompts and notifications.
+func describePeriod(period string, start time.Time) string {
+	if period == "weekly" {
+		return "the week of " + start.Format("Monday 2 January 2006")
+	}
+	return start.Format("Monday 2 January 2006")
+}
+
+// buildDigest gathers the facts for one camera and period.
+func (app *App) buildDigest(ctx context.Context, camera, period string, start, end time.Time) (*Digest, error) {
+	d := &Digest{
+		CameraID: camera,
+		Period:   period,
+		Start:    start.Format(time.RFC3339),
+		End:      end.Format(time.RFC3339),
+		Data:     DigestData{Labels: []LabelCount{}, BusiestHours: []int{}, FirstSeen: []DigestFirst{}, TopSnapshots: []DigestEvent{}},
+	}
+
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT id, timestamp, labels, COALESCE(snapshot_file, '') FROM detections
+		WHERE camera_id = ? AND timestamp >= ? AND timestamp < ? ORDER BY timestamp ASC`,
+		camera, start.Unix(), end.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type event struct {
+		id     int64
+		ts     float64
+		labels []string
+		snap   string
+	}
+	var events []event
+	counts := map[string]int{}
+	firstAt := map[string]event{}
+	gap := float64(app.Config.Embeddings.VisitGapSeconds)
+	if gap <= 0 {
+		gap = defaultVisitGapSeconds
+	}
+	var last float64
+	for rows.Next() {
+		var e event
+		var labelsJSON string
+		if err := rows.Scan(&e.id, &e.ts, &labelsJSON, &e.snap); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(labelsJSON), &e.labels)
+		events = append(events, e)
+
+		d.Data.Detections++
+		if d.Data.Visits == 0 || e.ts-last > gap {
+			d.Data.Visits++
+		}
+		last = e.ts
+		d.Data.Hours[time.Unix(int64(e.ts), 0).In(app.Loc).Hour()]++
+
+		seen := map[string]bool{}
+		for _, l := range e.labels {
+			if seen[l] {
+				continue
+			}
+			seen[l] = true
+			counts[l]++
+			if _, ok := firstAt[l]; !ok {
+				firstAt[l] = e
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for l, n := range counts {
+		d.Data.Labels = append(d.Data.Labels, LabelCount{Label: l, Count: n})
+	}
+	sort.Slice(d.Data.Labels, func(i, j int) bool {
+		a, b := d.Data.Labels[i], d.Data.Labels[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Label < b.Label)
+	})
+
+	hours := []int{}
+	for h, n := range d.Data.Hours {
+		if n > 0 {
+			hours = append(hours, h)
+		}
+	}
+	sort.SliceStable(hours, func(i, j int) bool { return d.Data.Hours[hours[i]] > d.Data.Hours[hours[j]] })
+	d.Data.BusiestHours = hours[:min(3, len(hours))]
+
+	toEvent := func(e event) DigestEvent {
+		return DigestEvent{
+			EventID:     e.id,
+			Time:        time.Unix(int64(e.ts), 0).In(app.Loc).Format(time.RFC3339),
+			Labels:      e.labels,
+			SnapshotURL: snapshotURL(e.snap),
+		}
+	}
+
+	// First-time objects: labels with no earlier detection on this camera
+	// (label_first_seen outlives retention, see db.go).
+	for _, lc := range d.Data.Labels {
+		var one int
+		err := app.DB.QueryRowContext(ctx, `
+			SELECT 1 FROM label_first_seen WHERE camera_id = ? AND label = ? AND first_seen < ?`,
+			camera, lc.Label, start.Unix()).Scan(&one)
+		if err == sql.ErrNoRows {
+			d.Data.FirstSeen = append(d.Data.FirstSeen, DigestFirst{Label: lc.Label, DigestEvent: toEvent(firstAt[lc.Label])})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(d.Data.FirstSeen, func(i, j int) bool { return d.Data.FirstSeen[i].EventID < d.Data.FirstSeen[j].EventID })
+
+	// Top snapshots: events whose labels were rarest in the period, one per
+	// snapshot file.
+	rarity := func(e event) float64 {
+		score := 0.0
+		for _, l := range e.labels {
+			if counts[l] > 0 {
+				score += 1 / float64(counts[l])
+			}
+		}
+		return score
+	}
+	ranked := make([]event, 0, len(events))
+	for _, e := range events {
+		if e.snap != "" {
+			ranked = append(ranked, e)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return rarity(ranked[i]) > rarity(ranked[j]) })
+	top := app.Config.Digests.TopSnapshots
+	if top <= 0 {
+		top = defaultDigestTopSnapshots
+	}
+	used := map[string]bool{}
+	for _, e := range ranked {
+		if len(d.Data.TopSnapshots) >= top {
+			break
+		}
+		if !used[e.snap] {
+			used[e.snap] = true
+			d.Data.TopSnapshots = append(d.Data.TopSnapshots, toEvent(e))
+		}
+	}
+	return d, nil
+}
+
+// digestContext lists a digest's facts for the narrative prompt.
+func (app *App) digestContext(d *Digest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "- Detections: %d in %d visits\n", d.Data.Detections, d.Data.Visits)
+	if len(d.Data.Labels) > 0 {
+		parts := make([]string, len(d.Data.Labels))
+		for i, lc := range d.Data.Labels {
+			parts[i] = fmt.Sprintf("%s %d", lc.Label, lc.Count)
+		}
+		fmt.Fprintf(&b, "- Detections per object: %s\n", strings.Join(parts, ", "))
+	}
+	if len(d.Data.BusiestHours) > 0 {
+		parts := make([]string, len(d.Data.BusiestHours))
+		for i, h := range d.Data.BusiestHours {
+			parts[i] = fmt.Sprintf("%02d:00–%02d:00 (%d)", h, (h+1)%24, d.Data.Hours[h])
+		}
+		fmt.Fprintf(&b, "- Busiest hours: %s\n", strings.Join(parts, ", "))
+	}
+	if len(d.Data.FirstSeen) > 0 {
+		parts := make([]string, len(d.Data.FirstSeen))
+		for i, f := range d.Data.FirstSeen {
+			t, _ := time.Parse(time.RFC3339, f.Time)
+			parts[i] = fmt.Sprintf("%s (%s)", f.Label, t.Format("Mon 15:04"))
+		}
+		fmt.Fprintf(&b, "- Seen by this camera for the first time: %s\n", strings.Join(parts, ", "))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// digestFallback is the narrative used without the LLM: for quiet periods
+// and when the LLM call fails.
+func (app *App) digestFallback(d *Digest, when string) string {
+	camera := app.cameraLabel(d.CameraID)
+	if d.Data.Detections == 0 {
+		return fmt.Sprintf("No activity on %s for %s.", camera, when)
+	}
+	var top []string
+	for _, lc := range d.Data.Labels[:min(3, len(d.Data.Labels))] {
+		top = append(top, fmt.Sprintf("%s (%d)", lc.Label, lc.Count))
+	}
+	s := fmt.Sprintf("%s had %d detections in %d visits for %s, mostly %s.",
+		camera, d.Data.Detections, d.Data.Visits, when, strings.Join(top, ", "))
+	if len(d.Data.BusiestHours) > 0 {
+		h := d.Data.BusiestHours[0]
+		s += fmt.Sprintf(" Busiest between %02d:00 and %02d:00.", h, (h+1)%24)
+	}
+	if len(d.Data.FirstSeen) > 0 {
+		var labels []string
+		for _, f := range d.Data.FirstSeen {
+			labels = append(labels, f.Label)
+		}
+		s += " New on this camera: " + strings.Join(labels, ", ") + "."
+	}
+	return s
+}
+
+// writeDigest builds, narrates and stores one camera's digest for the
+// period starting at start, replacing any earlier one.
+func (app *App) writeDigest(ctx context.Context, camera, period string, start time.Time) (*Digest, error) {
+	start, end, err := digestPeriod(period, start)
+	if err != nil {
+		return nil, err
+	}
+	d, err := app.buildDigest(ctx, camera, period, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	when := describePeriod(period, start)
+	d.Narrative = app.digestFallback(d, when)
+	if d.Data.Detections > 0 {
+		data := app.promptData("", []string{camera}, nil, nil)
+		data.Context = app.digestContext(d)
+		data.TimeRange = when
+		data.RangeStart, data.RangeEnd = d.Start, d.End
+		model := firstNonEmpty(app.Config.Digests.Model, app.Config.LLM.Model, app.LLM.Name())
+		resp, err := app.LLM.Chat(ctx, LLMRequest{
+			Messages: []ChatMessage{
+				{Role: "system", Content: app.renderPrompt("system", data)},
+				{Role: "user", Content: app.renderPrompt("digest", data)},
+			},
+			Model: app.Config.Digests.Model,
+		})
+		if err != nil {
+			log.Printf("[Digests] Narrative for %s failed, using the plain summary: %v", camera, err)
+		} else if text := strings.TrimSpace(resp.Content); text != "" {
+			d.Narrative = text
+			d.Model = model
+		}
+	}
+
+	d.CreatedAt = float64(time.Now().Unix())
+	dataJSON, _ := json.Marshal(d.Data)
+	res, err := app.DB.ExecContext(ctx, `
+		INSERT OR REPLACE INTO digests (camera_id, period, start_time, end_time, narrative, data, model, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		camera, period, start.Unix(), end.Unix(), d.Narrative, string(dataJSON), d.Model, d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.ID, _ = res.LastInsertId()
+	return d, nil
+}
+
+// digestExists reports whether a camera's digest for a period is stored.
+func digestExists(d *sql.DB, camera, period string, start time.Time) (bool, error) {
+	var n int
+	err := d.QueryRow("SELECT COUNT(*) FROM digests WHERE camera_id = ? AND period = ? AND start_time = ?",
+		camera, period, start.Unix()).Scan(&n)
+	return n > 0, err
+}
+
+// digestPeriods returns the configured periods.
+func (app *App) digestPeriods() []string {
+	if len(app.Config.Digests.Periods) == 0 {
+		return []string{"daily"}
+	}
+	return app.Config.Digests.Periods
+}
+
+// runDigests writes each camera's digest once a period is over.
+func (app *App) runDigests() {
+	ctx := context.Background()
+	for {
+		now := app.now()
+		for _, period := range app.digestPeriods() {
+			start, _, err := lastFinishedPeriod(period, now, app.Config.Digests.Hour)
+			if err != nil {
+				log.Printf("[Digests] %v", err)
+				continue
+			}
+			for _, cam := range app.Config.Cameras {
+				done, err := digestExists(app.DB, cam.ID, period, start)
+				if err != nil {
+					log.Printf("[Digests] Lookup failed: %v", err)
+					continue
+				}
+				if done {
+					continue
+				}
+				d, err := app.writeDigest(ctx, cam.ID, period, start)
+				if err != nil {
+					log.Printf("[Digests] %s digest for %s failed: %v", period, cam.ID, err)
+					continue
+				}
+				log.Printf("[Digests] Wrote %s digest %d for %s (%s)", period, d.ID, cam.ID, describePeriod(period, start))
+				app.notifyDigest(ctx, d)
+			}
+		}
+		time.Sleep(digestCheckInterval)
+	}
+}
+
+// notifyDigest pushes a digest to the channels under digests.notify.
+func (app *App) notifyDigest(ctx context.Context, d *Digest) {
+	if len(app.Config.Digests.Notify) == 0 {
+		return
+	}
+	start, _ := time.Parse(time.RFC3339, d.Start)
+	n := Notification{
+		Title:  fmt.Sprintf("%s: %s", app.cameraLabel(d.CameraID), describePeriod(d.Period, start)),
+		Body:   d.Narrative,
+		URL:    fmt.Sprintf("/digests?id=%d", d.ID),
+		Camera: app.cameraLabel(d.CameraID),
+		Time:   start,
+	}
+	if len(d.Data.TopSnapshots) > 0 {
+		n.Snapshot = strings.TrimPrefix(d.Data.TopSnapshots[0].SnapshotURL, "/snapshots/")
+	}
+	app.notify(ctx, app.Config.Digests.Notify, n) // failures are logged per channel
+}
+
+// listDigests returns stored digests, newest period first. id, camera and
+// period filter when set.
+func (app *App) listDigests(id int64, camera, period string, limit int) ([]Digest, error) {
+	query := `SELECT id, camera_id, period, start_time, end_time, COALESCE(narrative, ''), COALESCE(data, '{}'),
+	                 COALESCE(model, ''), COALESCE(created_at, 0)
+	          FROM digests WHERE 1=1`
+	var args []interface{}
+	if id > 0 {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	if camera != "" {
+		query += " AND camera_id = ?"
+		args = append(args, camera)
+	}
+	if period != "" {
+		query += " AND period = ?"
+		args = append(args, period)
+	}
+	query += " ORDER BY start_time DESC, camera_id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	digests := []Digest{}
+	for rows.Next() {
+		var g Digest
+		var start, end float64
+		var dataJSON string
+		if err := rows.Scan(&g.ID, &g.CameraID, &g.Period, &start, &end, &g.Narrative, &dataJSON, &g.Model, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		g.Start = time.Unix(int64(start), 0).In(app.Loc).Format(time.RFC3339)
+		g.End = time.Unix(int64(end), 0).In(app.Loc).Format(time.RFC3339)
+		json.Unmarshal([]byte(dataJSON), &g.Data)
+		digests = append(digests, g)
+	}
+	return digests, rows.Err()
+}
+
+// handleDigests handles /digests:
+//
+//	GET  /digests                          → latest digests (?camera_id=, ?period=, ?limit=, default 20)
+//	GET  /digests?id=...                   → one digest
+//	POST /digests                          → write (or rewrite) a digest now:
+//	                                         { "camera_id": "...", "period": "daily", "date": "2025-07-11" }
+//
+// Without a date, POST writes up the last finished period.
+func (app *App) handleDigests(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+
+	case http.MethodGet:
+		q := r.URL.Query()
+		var id int64
+		if s := q.Get("id"); s != "" {
+			var err error
+			if id, err = strconv.ParseInt(s, 10, 64); err != nil || id <= 0 {
+				http.Error(w, "Invalid id", http.StatusBadRequest)
+				return
+			}
+		}
+		limit := 20
+		if s := q.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, 200)
+		}
+		digests, err := app.listDigests(id, q.Get("camera_id"), q.Get("period"), limit)
+		if err != nil {
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			log.Printf("List digests failed: %v", err)
+			return
+		}
+		if id > 0 {
+			if len(digests) == 0 {
+				http.Error(w, "Digest not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(digests[0])
+			return
+		}
+		json.NewEncoder(w).Encode(digests)
+
+	case http.MethodPost:
+		var req struct {
+			CameraID string `json:"camera_id"`
+			Period   string `json:"period"`
+			Date     string `json:"date"` // any day in the period, YYYY-MM-DD
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.CameraID == "" {
+			http.Error(w, "Missing 'camera_id'", http.StatusBadRequest)
+			return
+		}
+		req.Period = firstNonEmpty(req.Period, "daily")
+
+		var start time.Time
+		var err error
+		if req.Date != "" {
+			start, err = time.ParseInLocation("2006-01-02", req.Date, app.Loc)
+		} else {
+			start, _, err = lastFinishedPeriod(req.Period, app.now(), app.Config.Digests.Hour)
+		}
+		if err == nil {
+			_, _, err = digestPeriod(req.Period, start)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		d, err := app.writeDigest(r.Context(), req.CameraID, req.Period, start)
+		if err != nil {
+			http.Error(w, "Digest failed", http.StatusInternalServerError)
+			log.Printf("Write digest failed: %v", err)
+			return
+		}
+		app.notifyDigest(r.Context(), d)
+		json.NewEncoder(w).Encode(d)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
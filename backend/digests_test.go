@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLastFinishedPeriod(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	at := func(month, day, hour int) time.Time {
+		return time.Date(2025, time.Month(month), day, hour, 0, 0, 0, loc)
+	}
+	const layout = "2006-01-02 15:04 MST"
+
+	tests := []struct {
+		name       string
+		period     string
+		now        time.Time
+		start, end string
+	}{
+		{"daily after the hour", "daily", at(7, 16, 8), "2025-07-15 00:00 BST", "2025-07-16 00:00 BST"},
+		{"daily at the hour", "daily", at(7, 16, 7), "2025-07-15 00:00 BST", "2025-07-16 00:00 BST"},
+		{"daily before the hour", "daily", at(7, 16, 6), "2025-07-14 00:00 BST", "2025-07-15 00:00 BST"},
+		{"daily across the clock change", "daily", at(3, 31, 8), "2025-03-30 00:00 GMT", "2025-03-31 00:00 BST"},
+		{"weekly on monday", "weekly", at(7, 14, 8), "2025-07-07 00:00 BST", "2025-07-14 00:00 BST"},
+		{"weekly on monday before the hour", "weekly", at(7, 14, 6), "2025-06-30 00:00 BST", "2025-07-07 00:00 BST"},
+		{"weekly midweek", "weekly", at(7, 16, 12), "2025-07-07 00:00 BST", "2025-07-14 00:00 BST"},
+		{"weekly on sunday", "weekly", at(7, 20, 23), "2025-07-07 00:00 BST", "2025-07-14 00:00 BST"},
+	}
+	for _, tt := range tests {
+		start, end, err := lastFinishedPeriod(tt.period, tt.now, 7)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := start.Format(layout); got != tt.start {
+			t.Errorf("%s: start %s, want %s", tt.name, got, tt.start)
+		}
+		if got := end.Format(layout); got != tt.end {
+			t.Errorf("%s: end %s, want %s", tt.name, got, tt.end)
+		}
+	}
+
+	if _, _, err := lastFinishedPeriod("monthly", at(7, 16, 8), 7); err == nil {
+		t.Error("monthly accepted")
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+dwell.go
+--------
+
+Dwell time is how long an object has stayed in view without a break: the
+run of stored detections of its label on one camera (optionally with an
+object in given zones) with no gap longer than dwell.gap_seconds. Alerts
+turn long stays into "loitering" events:
+
+	dwell:
+	  gap_seconds: 60          # absent longer than this and the stay is over
+	  alerts:
+	    - name: loitering
+	      cameras: [outside]   # IDs or camera_groups; empty = all
+	      labels: [person]
+	      zones: [driveway]    # optional
+	      min_seconds: 120
+	    - name: parked
+	      labels: [car, truck]
+	      min_seconds: 1800
+
+Stays are followed as detections come in (and recovered from the database
+after a restart). When one reaches min_seconds, a loitering event is
+stored in dwell_events and its end and duration keep being updated until
+the object leaves. Loitering events show up in /timeline ("kind":
+"loitering"), fire rules with on: loitering, and chat answers "how long has
+the van been there?" from the same stays.
+
+Dedup keeps repeated identical detections out of the database, so stays
+need subscriber.throttle_n > 0 and a gap_seconds comfortably above it.
+*/
+
+const defaultDwellGapSeconds = 60
+
+// dwellQuestion spots chat questions about how long something stayed.
+var dwellQuestion = regexp.MustCompile(`\bhow long\b|\bbeen (there|here|parked|waiting|sitting|standing)\b|\bparked\b|\bloiter|\bhanging around\b|\bstill there\b`)
+
+// Stay is one uninterrupted presence of a label on a camera.
+type Stay struct {
+	CameraID     string
+	Label        string
+	Start        float64
+	End          float64
+	Detections   int
+	FirstEventID int64
+	LastEventID  int64
+	SnapshotFile string
+}
+
+// DwellEvent is a stored loitering event.
+type DwellEvent struct {
+	ID           int64
+	Alert        string
+	CameraID     string
+	Label        string
+	Zones        string
+	Start        float64
+	End          float64
+	DwellSeconds float64
+	EventID      int64 // detection that reached min_seconds
+	Timestamp    float64
+	SnapshotFile string
+}
+
+// dwellState follows one alert's stay on one camera.
+type dwellState struct {
+	start, last float64
+	eventID     int64 // dwell_events row once the alert fired, else 0
+}
+
+// dwellTracker holds the open stays, keyed by alert|camera|label.
+type dwellTracker struct {
+	mu    sync.Mutex
+	stays map[string]*dwellState
+}
+
+func newDwellTracker() *dwellTracker {
+	return &dwellTracker{stays: map[string]*dwellState{}}
+}
+
+// dwellGap is the longest absence that doesn't end a stay.
+func (app *App) dwellGap() float64 {
+	if app.Config.Dwell.GapSeconds > 0 {
+		return float64(app.Config.Dwell.GapSeconds)
+	}
+	return defaultDwellGapSeconds
+}
+
+// validateDwellAlert checks one alert of dwell.alerts.
+func validateDwellAlert(a *DwellAlertConfig) error {
+	if a.Name == "" {
+		return fmt.Errorf("dwell alert without a name")
+	}
+	if len(a.Labels) == 0 {
+		return fmt.Errorf("dwell alert %q: no labels", a.Name)
+	}
+	if a.MinSeconds <= 0 {
+		return fmt.Errorf("dwell alert %q: min_seconds must be positive", a.Name)
+	}
+	for i, l := range a.Labels {
+		a.Labels[i] = strings.ToLower(strings.TrimSpace(l))
+	}
+	return nil
+}
+
+// currentStay walks back from at through a camera's detections of label
+// and returns the stay that was going on then, if any.
+func (app *App) currentStay(ctx context.Context, camera, label string, zones []string, at float64) (Stay, bool, error) {
+	gap := app.dwellGap()
+	where := "camera_id = ? AND labels LIKE ? AND timestamp <= ?"
+	args := []interface{}{camera, `%"` + label + `"%`, at}
+	if len(zones) > 0 {
+		cond, zargs := zoneFilter(zones)
+		where += " AND " + cond
+		args = append(args, zargs...)
+	}
+	rows, err := app.DB.QueryContext(ctx, "SELECT id, timestamp, COALESCE(snapshot_file, '') FROM detections WHERE "+where+
+		" ORDER BY timestamp DESC LIMIT 20000", args...)
+	if err != nil {
+		return Stay{}, false, err
+	}
+	defer rows.Close()
+
+	stay := Stay{CameraID: camera, Label: label}
+	for rows.Next() {
+		var id int64
+		var ts float64
+		var snap string
+		if err := rows.Scan(&id, &ts, &snap); err != nil {
+			return Stay{}, false, err
+		}
+		if stay.Detections == 0 {
+			stay.End, stay.LastEventID, stay.SnapshotFile = ts, id, snap
+		} else if stay.Start-ts > gap {
+			break
+		}
+		stay.Start, stay.FirstEventID = ts, id
+		stay.Detections++
+	}
+	return stay, stay.Detections > 0, rows.Err()
+}
+
+// dwellAlertMatch returns the objects of ev that an alert follows: their
+// labels, with the zones each is in.
+func (app *App) dwellAlertMatch(a DwellAlertConfig, ev DetectionEvent) map[string][]string {
+	if len(a.Cameras) > 0 && !containsFold(app.expandCameras(a.Cameras), ev.CameraID) {
+		return nil
+	}
+	found := map[string][]string{}
+	for i, l := range ev.Labels {
+		if !containsFold(a.Labels, l) {
+			continue
+		}
+		var zones []string
+		if i < len(ev.Zones) {
+			zones = ev.Zones[i]
+		}
+		if len(a.Zones) > 0 && !anyIn(zones, a.Zones) {
+			continue
+		}
+		found[strings.ToLower(l)] = append(found[strings.ToLower(l)], zones...)
+	}
+	return found
+}
+
+// trackDwell updates the stays a newly stored detection continues and
+// raises loitering events for those that reached their alert's min_seconds.
+func (app *App) trackDwell(ev DetectionEvent) {
+	ctx := context.Background()
+	gap := app.dwellGap()
+	for _, a := range app.Config.Dwell.Alerts {
+		for label, zones := range app.dwellAlertMatch(a, ev) {
+			key := a.Name + "|" + ev.CameraID + "|" + label
+
+			app.Dwell.mu.Lock()
+			st := app.Dwell.stays[key]
+			app.Dwell.mu.Unlock()
+			if st == nil || ev.Timestamp-st.last > gap {
+				// A new stay, or the first since a restart: look back in the database.
+				stay, ok, err := app.currentStay(ctx, ev.CameraID, label, a.Zones, ev.Timestamp)
+				if err != nil {
+					log.Printf("[Dwell] Failed to load stay of %s on %s: %v", label, ev.CameraID, err)
+					continue
+				}
+				st = &dwellState{start: ev.Timestamp}
+				if ok {
+					st.start = stay.Start
+				}
+				err = app.DB.QueryRowContext(ctx, "SELECT id FROM dwell_events WHERE alert = ? AND camera_id = ? AND label = ? AND start_time = ?",
+					a.Name, ev.CameraID, label, st.start).Scan(&st.eventID)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("[Dwell] Failed to look up loitering event: %v", err)
+				}
+			}
+			st.last = ev.Timestamp
+			app.Dwell.mu.Lock()
+			app.Dwell.stays[key] = st
+			app.Dwell.mu.Unlock()
+
+			dwell := st.last - st.start
+			if st.eventID > 0 {
+				if _, err := app.DB.Exec("UPDATE dwell_events SET end_time = ?, dwell_seconds = ? WHERE id = ?", st.last, dwell, st.eventID); err != nil {
+					log.Printf("[Dwell] Failed to update loitering event %d: %v", st.eventID, err)
+				}
+				continue
+			}
+			if dwell < float64(a.MinSeconds) {
+				continue
+			}
+
+			zoneList := ""
+			if len(zones) > 0 {
+				zoneList = strings.Join(uniqueStrings(zones), ",")
+			}
+			res, err := app.DB.Exec(`
+				INSERT INTO dwell_events (alert, camera_id, label, zones, start_time, end_time, dwell_seconds, event_id, timestamp, snapshot_file, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				a.Name, ev.CameraID, label, zoneList, st.start, st.last, dwell, ev.ID, ev.Timestamp, ev.SnapshotFile, float64(time.Now().Unix()))
+			if err != nil {
+				log.Printf("[Dwell] Failed to store loitering event: %v", err)
+				continue
+			}
+			st.eventID, _ = res.LastInsertId()
+			log.Printf("[Dwell] %s: %s on %s for %s", a.Name, label, ev.CameraID, formatDwell(dwell))
+
+			// Rules with on: loitering (rules.go)
+			loiter := DetectionEvent{
+				ID:           ev.ID,
+				Kind:         "loitering",
+				Timestamp:    ev.Timestamp,
+				CameraID:     ev.CameraID,
+				Labels:       []string{label},
+				SnapshotFile: ev.SnapshotFile,
+				DwellSeconds: dwell,
+			}
+			if len(zones) > 0 {
+				loiter.Zones = [][]string{uniqueStrings(zones)}
+			}
+			app.evaluateRules(loiter)
+		}
+	}
+}
+
+// pruneDwellEvents deletes loitering events from before cutoff whose
+// detection is gone, along with the snapshot retention just deleted.
+// Ones on held detections (see holds.go) stay with them.
+func pruneDwellEvents(d *sql.DB, cutoff int64) (int64, error) {
+	res, err := d.Exec(`DELETE FROM dwell_events WHERE timestamp < ?
+		AND NOT EXISTS (SELECT 1 FROM detections WHERE detections.id = dwell_events.event_id)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// formatDwell writes a duration the way people say it: "45s", "12 min", "2h 5min".
+func formatDwell(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh %dmin", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// describeStay is one line of chat context about a stay.
+func (app *App) describeStay(s Stay, now float64) string {
+	start := time.Unix(int64(s.Start), 0).In(app.Loc).Format(time.RFC3339)
+	if now-s.End <= app.dwellGap() {
+		return fmt.Sprintf("- Stay of '%s' on %s: since %s, %s so far, still there (last seen %s) Event: %d",
+			s.Label, s.CameraID, start, formatDwell(now-s.Start), time.Unix(int64(s.End), 0).In(app.Loc).Format(time.RFC3339), s.LastEventID)
+	}
+	return fmt.Sprintf("- Last stay of '%s' on %s: from %s to %s (%s), gone since. Event: %d",
+		s.Label, s.CameraID, start, time.Unix(int64(s.End), 0).In(app.Loc).Format(time.RFC3339), formatDwell(s.End-s.Start), s.LastEventID)
+}
+
+// dwellContext answers "how long has the van been there?": the current or
+// last stay of each object on each camera, at the end of rng or now.
+func (app *App) dwellContext(ctx context.Context, question string, cameras, zones, objects []string, rng *TimeRange) string {
+	if len(objects) == 0 || !dwellQuestion.MatchString(strings.ToLower(question)) {
+		return ""
+	}
+	at := float64(app.now().Unix())
+	if rng != nil && rng.End.Unix() < int64(at) {
+		at = float64(rng.End.Unix())
+	}
+	var out string
+	for _, label := range objects {
+		for _, camera := range cameras {
+			stay, ok, err := app.currentStay(ctx, camera, strings.ToLower(label), zones, at)
+			if err != nil {
+				log.Printf("Dwell lookup failed: %v", err)
+				continue
+			}
+			if ok {
+				out += app.describeStay(stay, at) + "\n"
+			}
+		}
+	}
+	return out
+}
+
+// toolDwell is the get_dwell chat tool: the current or last stay of a label per camera.
+func (app *App) toolDwell(ctx context.Context, args toolArgs) (interface{}, error) {
+	if args.Label == "" {
+		return nil, fmt.Errorf("label is required")
+	}
+	at := float64(app.now().Unix())
+	if args.End != "" {
+		t, err := parseToolTime(args.End, app.Loc)
+		if err != nil {
+			return nil, err
+		}
+		at = t
+	}
+	zones := args.zones
+	if args.Zone != "" {
+		zones = []string{args.Zone}
+	}
+	type toolStay struct {
+		CameraID        string `json:"camera_id"`
+		Start           string `json:"start"`
+		LastSeen        string `json:"last_seen"`
+		DurationSeconds int    `json:"duration_seconds"`
+		StillThere      bool   `json:"still_there"`
+		Detections      int    `json:"detections"`
+	}
+	stays := []toolStay{}
+	for _, camera := range args.cameras {
+		s, ok, err := app.currentStay(ctx, camera, strings.ToLower(args.Label), zones, at)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		still := at-s.End <= app.dwellGap()
+		end := s.End
+		if still {
+			end = at
+		}
+		stays = append(stays, toolStay{
+			CameraID:        camera,
+			Start:           formatToolTime(s.Start, app.Loc),
+			LastSeen:        formatToolTime(s.End, app.Loc),
+			DurationSeconds: int(end - s.Start),
+			StillThere:      still,
+			Detections:      s.Detections,
+		})
+	}
+	return map[string]interface{}{"label": args.Label, "stays": stays}, nil
+}
+
+// dwellTimelineEvents returns loitering events for /timeline, as rows
+// shaped like its detections.
+func (app *App) dwellTimelineEvents(camera, label, zone string, start, end *float64) ([]map[string]interface{}, error) {
+	where := "1=1"
+	var args []interface{}
+	if camera != "" {
+		where += " AND camera_id = ?"
+		args = append(args, camera)
+	}
+	if label != "" {
+		where += " AND label LIKE ?"
+		args = append(args, "%"+label+"%")
+	}
+	if zone != "" {
+		where += " AND (',' || zones || ',') LIKE ?"
+		args = append(args, "%,"+zone+",%")
+	}
+	if start != nil {
+		where += " AND timestamp >= ?"
+		args = append(args, *start)
+	}
+	if end != nil {
+		where += " AND timestamp <= ?"
+		args = append(args, *end)
+	}
+	rows, err := app.DB.Query(`SELECT id, alert, camera_id, label, COALESCE(zones, ''), start_time, end_time, dwell_seconds, event_id, timestamp, COALESCE(snapshot_file, '')
+		FROM dwell_events WHERE `+where+` ORDER BY timestamp DESC LIMIT 100`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		var e DwellEvent
+		if err := rows.Scan(&e.ID, &e.Alert, &e.CameraID, &e.Label, &e.Zones, &e.Start, &e.End, &e.DwellSeconds, &e.EventID, &e.Timestamp, &e.SnapshotFile); err != nil {
+			return nil, err
+		}
+		results = append(results, map[string]interface{}{
+			"kind":          "loitering",
+			"id":            e.EventID, // the detection that reached min_seconds, for /holds
+			"dwell_id":      e.ID,
+			"alert":         e.Alert,
+			"timestamp":     e.Timestamp,
+			"camera_id":     e.CameraID,
+			"labels":        `["` + e.Label + `"]`,
+			"zones":         e.Zones,
+			"start_time":    e.Start,
+			"end_time":      e.End,
+			"dwell_seconds": e.DwellSeconds,
+			"snapshot_file": e.SnapshotFile,
+			"snapshot_url":  snapshotURL(e.SnapshotFile),
+		})
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestPruneDwellEvents(t *testing.T) {
+	app := newTestApp(t, nil)
+	kept, err := insertDetection(1000, "drive", `["car"]`, "[]", "[]", "drive/a.jpg", "[]") // e.g. held
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []struct {
+		eventID   int64
+		timestamp float64
+		kept      bool
+	}{
+		{kept, 1000, true}, // its detection is still there
+		{kept + 1, 1000, false},
+		{kept + 2, 5000, true}, // newer than the cutoff
+	}
+	for _, e := range events {
+		app.DB.Exec("INSERT INTO dwell_events (alert, camera_id, label, event_id, timestamp, snapshot_file) VALUES ('loitering', 'drive', 'car', ?, ?, 'drive/b.jpg')",
+			e.eventID, e.timestamp)
+	}
+	if n, err := pruneDwellEvents(app.DB, 2000); err != nil || n != 1 {
+		t.Fatalf("pruneDwellEvents = %d, %v; want 1 deleted", n, err)
+	}
+	for _, e := range events {
+		var n int
+		app.DB.QueryRow("SELECT COUNT(*) FROM dwell_events WHERE event_id = ?", e.eventID).Scan(&n)
+		if (n == 1) != e.kept {
+			t.Errorf("loitering event on detection %d at %v: %d left, kept = %v", e.eventID, e.timestamp, n, e.kept)
+		}
+	}
+}
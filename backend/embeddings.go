@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+embeddings.go
+-------------
+
+Local semantic search (RAG) over the detection store:
+
+- Every detection ("event") and every visit (detections on one camera with
+  gaps < visit_gap_seconds) gets a short text summary, e.g.
+  "Visit at front_door (Front door) on Friday 11 July 2025 from 08:02 to
+  08:05 (3 minutes, 6 detections): person, truck."
+- The summaries are embedded through the provider layer (Embedder, see
+  llm.go) and stored in the SQLite embeddings table as float32 blobs.
+- GET /search?q=... embeds the query and ranks the stored vectors by cosine
+  similarity, so "someone delivering a parcel" finds person+truck visits.
+- /chat adds the best matches to its context; in tool mode the model gets
+  a semantic_search tool instead.
+
+runEmbeddingIndexer embeds new events and closed visits in the background.
+Vectors from another model are dropped and re-embedded at startup.
+*/
+
+const (
+	defaultEmbeddingModel   = "nomic-embed-text"
+	defaultVisitGapSeconds  = 120
+	defaultChatSearchLimit  = 3
+	defaultMinSearchScore   = 0.3
+	embeddingBatchSize      = 32
+	embeddingIndexInterval  = time.Minute
+	maxVisitRowsPerIndexing = 5000
+)
+
+// Embedder turns texts into vectors. The OpenAI-compatible, Ollama,
+// llama.cpp and fake providers all implement it.
+type Embedder interface {
+	Name() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// newEmbedder builds the embedder from the embeddings: section, taking
+// anything left empty (provider, base_url, api_key, ...) from llm:.
+// It returns nil when embeddings are disabled.
+func newEmbedder(cfg Config) (Embedder, error) {
+	ec := cfg.Embeddings
+	if !ec.Enabled {
+		return nil, nil
+	}
+	lc := ec.LLMConfig
+	if lc.Provider == "" {
+		lc.Provider = cfg.LLM.Provider
+		lc.BaseURL = firstNonEmpty(lc.BaseURL, cfg.LLM.BaseURL)
+	}
+	lc.APIKey = firstNonEmpty(lc.APIKey, cfg.LLM.APIKey)
+	if lc.Model == "" {
+		lc.Model = defaultEmbeddingModel
+	}
+	if lc.TimeoutSeconds <= 0 {
+		lc.TimeoutSeconds = cfg.LLM.TimeoutSeconds
+	}
+	if lc.MaxRetries <= 0 {
+		lc.MaxRetries = cfg.LLM.MaxRetries
+	}
+
+	provider, err := newLLMProvider(lc)
+	if err != nil {
+		return nil, err
+	}
+	embedder, ok := provider.(Embedder)
+	if !ok {
+		return nil, fmt.Errorf("llm provider %q cannot embed", lc.Provider)
+	}
+	return embedder, nil
+}
+
+// SearchResult is one /search match.
+type SearchResult struct {
+	Kind        string   `json:"kind"` // "event" or "visit"
+	EventID     int64    `json:"event_id"`
+	EventIDs    []int64  `json:"event_ids,omitempty"` // every detection of a visit
+	CameraID    string   `json:"camera_id"`
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Labels      []string `json:"labels"`
+	Text        string   `json:"text"`
+	Score       float64  `json:"score"`
+	SnapshotURL string   `json:"snapshot_url,omitempty"`
+
+	hit detectionHit // for chat context, citations and vision
+}
+
+// searchOptions narrow a semantic search; zero values mean "any".
+type searchOptions struct {
+	Cameras    []string
+	Start, End float64
+	Kind       string
+	Limit      int
+}
+
+// encodeVector stores a vector as little-endian float32s.
+func encodeVector(v []float32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(f))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	v := make([]float32, len(buf)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return v
+}
+
+// cosine returns the cosine similarity of two vectors, 0 if they differ in size.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// cameraLabel is "id (Name)" when the camera has a friendly name.
+func (app *App) cameraLabel(id string) string {
+	for _, cam := range app.Config.Cameras {
+		if cam.ID == id && cam.Name != "" {
+			return fmt.Sprintf("%s (%s)", id, cam.Name)
+		}
+	}
+	return id
+}
+
+// partOfDay names the time of day the way people ask about it.
+func partOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h < 5:
+		return "at night"
+	case h < 12:
+		return "in the morning"
+	case h < 17:
+		return "in the afternoon"
+	case h < 21:
+		return "in the evening"
+	default:
+		return "at night"
+	}
+}
+
+// eventSummary describes one detection for embedding.
+func (app *App) eventSummary(h detectionHit, labels []string) string {
+	t := time.Unix(int64(h.Timestamp), 0).In(app.Loc)
+	return fmt.Sprintf("%s seen by %s on %s at %s %s.",
+		strings.Join(labels, " and "), app.cameraLabel(h.CameraID),
+		t.Format("Monday 2 January 2006"), t.Format("15:04"), partOfDay(t))
+}
+
+// embeddingVisit is a run of detections on one camera.
+type embeddingVisit struct {
+	CameraID     string
+	Start, End   float64
+	EventIDs     []int64
+	Labels       []string
+	SnapshotFile string // first snapshot of the visit
+}
+
+// visitSummary describes one visit for embedding.
+func (app *App) visitSummary(v embeddingVisit) string {
+	start := time.Unix(int64(v.Start), 0).In(app.Loc)
+	end := time.Unix(int64(v.End), 0).In(app.Loc)
+	minutes := int(math.Round((v.End - v.Start) / 60))
+	return fmt.Sprintf("Visit at %s on %s from %s to %s %s (%d minutes, %d detections): %s.",
+		app.cameraLabel(v.CameraID), start.Format("Monday 2 January 2006"),
+		start.Format("15:04"), end.Format("15:04"), partOfDay(start),
+		minutes, len(v.EventIDs), strings.Join(v.Labels, ", "))
+}
+
+// embeddingRow is one summary waiting to be embedded.
+type embeddingRow struct {
+	Kind         string
+	EventID      int64
+	EventIDs     []int64
+	CameraID     string
+	Start, End   float64
+	Labels       []string
+	SnapshotFile string
+	Text         string
+}
+
+// storeEmbeddings embeds rows in batches and saves them.
+func (app *App) storeEmbeddings(ctx context.Context, rows []embeddingRow) error {
+	for i := 0; i < len(rows); i += embeddingBatchSize {
+		batch := rows[i:min(i+embeddingBatchSize, len(rows))]
+		texts := make([]string, len(batch))
+		for j, r := range batch {
+			texts[j] = r.Text
+		}
+		vectors, err := app.Embedder.Embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		if len(vectors) != len(batch) {
+			return fmt.Errorf("%s returned %d embeddings for %d texts", app.Embedder.Name(), len(vectors), len(batch))
+		}
+		for j, r := range batch {
+			ids, _ := json.Marshal(r.EventIDs)
+			labels, _ := json.Marshal(r.Labels)
+			_, err := app.DB.ExecContext(ctx, `
+				INSERT OR REPLACE INTO embeddings
+					(kind, event_id, event_ids, camera_id, start_time, end_time, labels, snapshot_file, text, model, vector)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				r.Kind, r.EventID, string(ids), r.CameraID, r.Start, r.End, string(labels), r.SnapshotFile,
+				r.Text, app.Embedder.Name(), encodeVector(vectors[j]))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexEvents embeds detections that have no embedding yet. It returns how
+// many it embedded; the indexer calls it until that is 0.
+func (app *App) indexEvents(ctx context.Context) (int, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT d.id, d.timestamp, d.camera_id, d.labels, COALESCE(d.snapshot_file, '')
+		FROM detections d
+		WHERE NOT EXISTS (SELECT 1 FROM embeddings e WHERE e.kind = 'event' AND e.event_id = d.id)
+		ORDER BY d.id LIMIT ?`, embeddingBatchSize*4)
+	if err != nil {
+		return 0, err
+	}
+	var batch []embeddingRow
+	for rows.Next() {
+		var h detectionHit
+		if err := rows.Scan(&h.ID, &h.Timestamp, &h.CameraID, &h.Labels, &h.SnapshotFile); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		var labels []string
+		json.Unmarshal([]byte(h.Labels), &labels)
+		batch = append(batch, embeddingRow{
+			Kind: "event", EventID: h.ID, EventIDs: []int64{h.ID}, CameraID: h.CameraID,
+			Start: h.Timestamp, End: h.Timestamp, Labels: labels, SnapshotFile: h.SnapshotFile,
+			Text: app.eventSummary(h, labels),
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return len(batch), app.storeEmbeddings(ctx, batch)
+}
+
+// indexVisits embeds the visits that closed since each camera's last
+// embedded visit. A visit is closed once no detection followed it for
+// visit_gap_seconds.
+func (app *App) indexVisits(ctx context.Context, now float64) (int, error) {
+	gap := float64(app.Config.Embeddings.VisitGapSeconds)
+	if gap <= 0 {
+		gap = defaultVisitGapSeconds
+	}
+
+	var cameras []string
+	rows, err := app.DB.QueryContext(ctx, "SELECT DISTINCT camera_id FROM detections")
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var c string
+		if rows.Scan(&c) == nil {
+			cameras = append(cameras, c)
+		}
+	}
+	rows.Close()
+
+	total := 0
+	for _, camera := range cameras {
+		var after float64
+		app.DB.QueryRowContext(ctx,
+			"SELECT COALESCE(MAX(end_time), 0) FROM embeddings WHERE kind = 'visit' AND camera_id = ?", camera).Scan(&after)
+
+		visits, complete, err := app.loadVisits(ctx, camera, after, gap)
+		if err != nil {
+			return total, err
+		}
+		// Only the last visit can still be open (or cut off by the row limit).
+		if n := len(visits); n > 0 && (!complete || now-visits[n-1].End <= gap) {
+			visits = visits[:n-1]
+		}
+
+		var batch []embeddingRow
+		for _, v := range visits {
+			batch = append(batch, embeddingRow{
+				Kind: "visit", EventID: v.EventIDs[0], EventIDs: v.EventIDs, CameraID: v.CameraID,
+				Start: v.Start, End: v.End, Labels: v.Labels, SnapshotFile: v.SnapshotFile,
+				Text: app.visitSummary(v),
+			})
+		}
+		if err := app.storeEmbeddings(ctx, batch); err != nil {
+			return total, err
+		}
+		total += len(batch)
+	}
+	return total, nil
+}
+
+// loadVisits groups a camera's detections after a time into visits.
+// complete is false when the row limit cut the last visit short.
+func (app *App) loadVisits(ctx context.Context, camera string, after, gap float64) ([]embeddingVisit, bool, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT id, timestamp, labels, COALESCE(snapshot_file, '') FROM detections
+		WHERE camera_id = ? AND timestamp > ? ORDER BY timestamp ASC LIMIT ?`,
+		camera, after, maxVisitRowsPerIndexing)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var visits []embeddingVisit
+	n := 0
+	for rows.Next() {
+		var id int64
+		var ts float64
+		var labelsJSON, snap string
+		if err := rows.Scan(&id, &ts, &labelsJSON, &snap); err != nil {
+			return nil, false, err
+		}
+		n++
+		if len(visits) == 0 || ts-visits[len(visits)-1].End > gap {
+			visits = append(visits, embeddingVisit{CameraID: camera, Start: ts})
+		}
+		v := &visits[len(visits)-1]
+		v.End = ts
+		v.EventIDs = append(v.EventIDs, id)
+		if v.SnapshotFile == "" {
+			v.SnapshotFile = snap
+		}
+		var labels []string
+		json.Unmarshal([]byte(labelsJSON), &labels)
+		for _, l := range labels {
+			if !containsFold(v.Labels, l) {
+				v.Labels = append(v.Labels, l)
+			}
+		}
+	}
+	return visits, n < maxVisitRowsPerIndexing, rows.Err()
+}
+
+// runEmbeddingIndexer keeps the embeddings table up to date.
+func (app *App) runEmbeddingIndexer() {
+	ctx := context.Background()
+	res, err := app.DB.Exec("DELETE FROM embeddings WHERE model != ?", app.Embedder.Name())
+	if err != nil {
+		log.Printf("[Embeddings] Failed to drop old vectors: %v", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[Embeddings] Model changed to %s, re-embedding %d summaries", app.Embedder.Name(), n)
+	}
+
+	for {
+		events := 0
+		for {
+			n, err := app.indexEvents(ctx)
+			if err != nil {
+				log.Printf("[Embeddings] Event indexing failed (%s): %v", app.Embedder.Name(), err)
+				break
+			}
+			events += n
+			if n == 0 {
+				break
+			}
+		}
+		visits, err := app.indexVisits(ctx, float64(time.Now().Unix()))
+		if err != nil {
+			log.Printf("[Embeddings] Visit indexing failed (%s): %v", app.Embedder.Name(), err)
+		}
+		if events+visits > 0 {
+			log.Printf("[Embeddings] Embedded %d events and %d visits", events, visits)
+		}
+		time.Sleep(embeddingIndexInterval)
+	}
+}
+
+// pruneEmbeddings drops embeddings whose detection is gone (see retention.go).
+func pruneEmbeddings(d *sql.DB) (int64, error) {
+	res, err := d.Exec("DELETE FROM embeddings WHERE event_id NOT IN (SELECT id FROM detections)")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// semanticSearch returns the stored summaries closest to query.
+func (app *App) semanticSearch(ctx context.Context, query string, opts searchOptions) ([]SearchResult, error) {
+	vectors, err := app.Embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("%s returned %d embeddings for 1 text", app.Embedder.Name(), len(vectors))
+	}
+	q := vectors[0]
+
+	conditions := []string{"model = ?"}
+	args := []interface{}{app.Embedder.Name()}
+	if len(opts.Cameras) > 0 {
+		cond, cargs := cameraFilter(opts.Cameras)
+		conditions = append(conditions, cond)
+		args = append(args, cargs...)
+	}
+	if opts.Kind != "" {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, opts.Kind)
+	}
+	if opts.Start > 0 {
+		conditions = append(conditions, "end_time >= ?")
+		args = append(args, opts.Start)
+	}
+	if opts.End > 0 {
+		conditions = append(conditions, "start_time < ?")
+		args = append(args, opts.End)
+	}
+
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT kind, event_id, event_ids, camera_id, start_time, end_time, labels, snapshot_file, text, vector
+		FROM embeddings WHERE `+strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	minScore := app.Config.Embeddings.MinScore
+	if minScore <= 0 {
+		minScore = defaultMinSearchScore
+	}
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var idsJSON, labelsJSON string
+		var start, end float64
+		var blob []byte
+		if err := rows.Scan(&r.Kind, &r.EventID, &idsJSON, &r.CameraID, &start, &end,
+			&labelsJSON, &r.hit.SnapshotFile, &r.Text, &blob); err != nil {
+			return nil, err
+		}
+		r.Score = cosine(q, decodeVector(blob))
+		if r.Score < minScore {
+			continue
+		}
+		json.Unmarshal([]byte(idsJSON), &r.EventIDs)
+		json.Unmarshal([]byte(labelsJSON), &r.Labels)
+		if r.Kind == "event" {
+			r.EventIDs = nil
+		}
+		r.Start = formatToolTime(start, app.Loc)
+		r.End = formatToolTime(end, app.Loc)
+		r.SnapshotURL = snapshotURL(r.hit.SnapshotFile)
+		r.hit = detectionHit{ID: r.EventID, Timestamp: start, CameraID: r.CameraID, Labels: labelsJSON, SnapshotFile: r.hit.SnapshotFile}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// semanticPlaceholder stands in for the semanticContext section in prompt
+// previews, which don't call the embedding model.
+const semanticPlaceholder = "\nSimilar events and visits:\n- (semantic search results, not run in a preview)\n"
+
+// semanticContext adds the summaries closest to the question to the chat
+// context, so wording the label extractor misses ("a parcel delivery")
+// still finds the right visits.
+func (app *App) semanticContext(ctx context.Context, question string, cameras []string, rng *TimeRange) (string, []detectionHit) {
+	limit := app.Config.Embeddings.ChatResults
+	if limit <= 0 {
+		limit = defaultChatSearchLimit
+	}
+	opts := searchOptions{Cameras: cameras, Limit: limit}
+	if rng != nil {
+		opts.Start, opts.End = float64(rng.Start.Unix()), float64(rng.End.Unix())
+	}
+	results, err := app.semanticSearch(ctx, question, opts)
+	if err != nil {
+		log.Printf("Semantic search failed (%s): %v", app.Embedder.Name(), err)
+		return "", nil
+	}
+	if len(results) == 0 {
+		return "", nil
+	}
+
+	out := "\nSimilar events and visits:\n"
+	var hits []detectionHit
+	for _, r := range results {
+		out += fmt.Sprintf("- %s Event: %d\n", r.Text, r.EventID)
+		hits = append(hits, r.hit)
+	}
+	return out, hits
+}
+
+// handleSearch handles GET /search?q=...&camera_id=...&cameras=a,b&kind=visit&start_time=...&end_time=...&limit=10
+func (app *App) handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	if app.Embedder == nil {
+		http.Error(w, "Semantic search is disabled (embeddings.enabled)", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		http.Error(w, "Missing q", http.StatusBadRequest)
+		return
+	}
+
+	opts := searchOptions{Kind: q.Get("kind")}
+	if opts.Kind != "" && opts.Kind != "event" && opts.Kind != "visit" {
+		http.Error(w, "kind must be event or visit", http.StatusBadRequest)
+		return
+	}
+	var names []string
+	if id := q.Get("camera_id"); id != "" {
+		names = append(names, id)
+	}
+	if list := q.Get("cameras"); list != "" {
+		names = append(names, strings.Split(list, ",")...)
+	}
+	opts.Cameras = app.expandCameras(names)
+	opts.Start, _ = strconv.ParseFloat(q.Get("start_time"), 64)
+	opts.End, _ = strconv.ParseFloat(q.Get("end_time"), 64)
+	opts.Limit, _ = strconv.Atoi(q.Get("limit"))
+
+	results, err := app.semanticSearch(r.Context(), query, opts)
+	if err != nil {
+		log.Printf("handleSearch (%s): %v", app.Embedder.Name(), err)
+		http.Error(w, "Semantic search failed", http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []SearchResult{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"query": query, "results": results})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newSearchApp returns a test app embedding with the fake provider, with a
+// parcel delivery (person + truck) at the front door, a car in the garage
+// and a cat in the garden, each a visit of its own.
+func newSearchApp(t *testing.T) *App {
+	t.Helper()
+	app := newTestApp(t, &Config{Cameras: []CameraConfig{{ID: "front_door", Name: "Front door"}, {ID: "garage"}, {ID: "garden"}}})
+	app.Embedder = NewFakeProvider()
+	const t0 = 1752220920 // Friday 11 July 2025 08:02 UTC
+	rows := []struct {
+		ts       float64
+		camera   string
+		labels   string
+		snapshot string
+	}{
+		{t0, "front_door", `["person"]`, "front_door/a.jpg"},
+		{t0 + 60, "front_door", `["truck"]`, "front_door/b.jpg"},
+		{t0 + 180, "front_door", `["person","truck"]`, "front_door/c.jpg"},
+		{t0 + 3600, "garage", `["car"]`, "garage/a.jpg"},
+		{t0 + 7200, "garden", `["cat"]`, "garden/a.jpg"},
+	}
+	for _, r := range rows {
+		if _, err := insertDetection(r.ts, r.camera, r.labels, "[]", "[]", r.snapshot, "[]"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app
+}
+
+func TestIndexEventsAndVisits(t *testing.T) {
+	app := newSearchApp(t)
+	ctx := context.Background()
+
+	if n, err := app.indexEvents(ctx); err != nil || n != 5 {
+		t.Fatalf("indexEvents = %d, %v; want 5", n, err)
+	}
+	if n, err := app.indexEvents(ctx); err != nil || n != 0 {
+		t.Fatalf("second indexEvents = %d, %v; want 0", n, err)
+	}
+
+	// The garden visit is still open a minute after the cat.
+	now := float64(1752220920 + 7200 + 60)
+	if n, err := app.indexVisits(ctx, now); err != nil || n != 2 {
+		t.Fatalf("indexVisits = %d, %v; want 2", n, err)
+	}
+	if n, err := app.indexVisits(ctx, now+600); err != nil || n != 1 {
+		t.Fatalf("indexVisits once the garden visit closed = %d, %v; want 1", n, err)
+	}
+	if n, err := app.indexVisits(ctx, now+600); err != nil || n != 0 {
+		t.Fatalf("indexVisits again = %d, %v; want 0", n, err)
+	}
+
+	var text, ids, model string
+	err := app.DB.QueryRow("SELECT text, event_ids, model FROM embeddings WHERE kind = 'visit' AND camera_id = 'front_door'").Scan(&text, &ids, &model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Visit at front_door (Front door) on Friday 11 July 2025 from 08:02 to 08:05 in the morning (3 minutes, 3 detections): person, truck."
+	if text != want {
+		t.Errorf("visit summary %q, want %q", text, want)
+	}
+	if ids != "[1,2,3]" || model != "fake" {
+		t.Errorf("visit event_ids %s, model %s", ids, model)
+	}
+	err = app.DB.QueryRow("SELECT text FROM embeddings WHERE kind = 'event' AND event_id = 4").Scan(&text)
+	if err != nil || text != "car seen by garage on Friday 11 July 2025 at 09:02 in the morning." {
+		t.Errorf("event summary %q (%v)", text, err)
+	}
+}
+
+func TestSemanticSearch(t *testing.T) {
+	app := newSearchApp(t)
+	ctx := context.Background()
+	app.indexEvents(ctx)
+	app.indexVisits(ctx, 1752220920+86400)
+
+	tests := []struct {
+		query string
+		opts  searchOptions
+		want  []string // kind:event_id, best first
+	}{
+		{"someone delivering a parcel", searchOptions{Kind: "visit", Limit: 1}, []string{"visit:1"}},
+		{"someone delivering a parcel", searchOptions{Kind: "event", Limit: 1}, []string{"event:3"}},
+		{"a vehicle", searchOptions{Cameras: []string{"garage"}}, []string{"event:4", "visit:4"}},
+		{"was there an animal?", searchOptions{Kind: "visit"}, []string{"visit:5"}},
+		{"a bicycle", searchOptions{}, nil},
+		{"someone delivering a parcel", searchOptions{Cameras: []string{"garden"}}, nil},
+	}
+	for _, tt := range tests {
+		results, err := app.semanticSearch(ctx, tt.query, tt.opts)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Kind+":"+strconv.FormatInt(r.EventID, 10))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("semanticSearch(%q, %+v) = %v, want %v", tt.query, tt.opts, got, tt.want)
+		}
+	}
+
+	results, _ := app.semanticSearch(ctx, "someone delivering a parcel", searchOptions{Kind: "visit", Limit: 1})
+	if len(results) == 1 {
+		r := results[0]
+		if !reflect.DeepEqual(r.Labels, []string{"person", "truck"}) || !reflect.DeepEqual(r.EventIDs, []int64{1, 2, 3}) ||
+			r.SnapshotURL != "/snapshots/front_door/a.jpg" || r.Start != "2025-07-11T08:02:00Z" || r.End != "2025-07-11T08:05:00Z" {
+			t.Errorf("delivery visit %+v", r)
+		}
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	app := newSearchApp(t)
+	app.indexEvents(context.Background())
+	app.indexVisits(context.Background(), 1752220920+86400)
+
+	w := httptest.NewRecorder()
+	app.handleSearch(w, httptest.NewRequest("GET", "/search?q=someone+delivering+a+parcel&kind=visit&camera_id=front_door", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Query   string         `json:"query"`
+		Results []SearchResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Query != "someone delivering a parcel" || len(resp.Results) != 1 || !strings.Contains(resp.Results[0].Text, "person, truck") {
+		t.Errorf("response %s", w.Body)
+	}
+
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"/search?q=a+bicycle", http.StatusOK, `"results":[]`},
+		{"/search?q=", http.StatusBadRequest, "Missing q"},
+		{"/search?q=car&kind=person", http.StatusBadRequest, "kind must be event or visit"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		app.handleSearch(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("%s: %d %s, want %d with %q", tt.url, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+
+	app.Embedder = nil
+	w = httptest.NewRecorder()
+	app.handleSearch(w, httptest.NewRequest("GET", "/search?q=car", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("with embeddings off: status %d, want 404", w.Code)
+	}
+}
+
+func TestPromptPreviewSkipsSemanticSearch(t *testing.T) {
+	app := newSearchApp(t)
+	srv := newLLMServer(t, llmReply{200, []string{`{"embeddings":[[1,0]]}`}})
+	app.Embedder = newTestProvider(t, "ollama", srv.URL, 0).(Embedder)
+
+	w := httptest.NewRecorder()
+	app.handlePromptPreview(w, httptest.NewRequest("GET", "/admin/prompts/preview?message=was+there+a+parcel+delivery%3F", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Context string `json:"context"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !strings.Contains(resp.Context, semanticPlaceholder) {
+		t.Errorf("context has no semantic search placeholder:\n%s", resp.Context)
+	}
+	if n := len(srv.calls()); n != 0 {
+		t.Errorf("preview called the embedding model %d times", n)
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+eval.go
+-------
+
+`./backend eval` scores the chat pipeline against golden question sets,
+so prompt and pipeline changes can be compared before they ship:
+
+	./backend eval -suite ../eval/golden.yaml                      # offline, fake provider
+	./backend eval -suite ../eval/golden.yaml -provider ollama -model llama3 -format markdown -out report.md
+
+A suite is a YAML file with a fixture database (inline detections, or a
+SQLite file with a detections table), a pinned "now" so "yesterday" always
+means the same day, and questions with the facts a right answer contains:
+
+	now: 2025-07-12T09:00:00+01:00
+	detections:                      # event IDs are 1, 2, ... in this order
+	  - {time: 2025-07-11T14:05:00+01:00, camera: garage_webcam, labels: [car]}
+	questions:
+	  - id: last-car
+	    question: When did you last see a car?
+	    camera_id: garage_webcam
+	    expect:
+	      times: ["14:05"]           # clock times the answer must mention
+	      labels: [car]              # objects it must mention
+	      not_labels: [dog]          # objects it must not mention
+	      counts: [1]                # numbers it must mention
+	      citations: [1]             # event IDs it must cite
+
+Each question runs through the same steps as /chat (prompt templates, tools,
+grounding) on a scratch copy of the fixtures. Correctness is the share of
+expected facts found (a forbidden label counts as a missed fact); citation
+precision/recall compare the cited event IDs with the expected ones. A
+question passes with every fact found and every expected citation made.
+The command exits 1 if any question fails.
+*/
+
+// evalSuite is one golden question file.
+type evalSuite struct {
+	Name         string              `yaml:"name"`
+	Now          string              `yaml:"now"`      // RFC3339; default: the real time
+	Timezone     string              `yaml:"timezone"` // default: config.yaml's
+	Cameras      []CameraConfig      `yaml:"cameras"`
+	CameraGroups map[string][]string `yaml:"camera_groups"`
+	FixtureDB    string              `yaml:"fixture_db"` // SQLite file, relative to the suite
+	Detections   []evalDetection     `yaml:"detections"`
+	Questions    []evalQuestion      `yaml:"questions"`
+}
+
+type evalDetection struct {
+	Time     string   `yaml:"time"` // RFC3339
+	Camera   string   `yaml:"camera"`
+	Labels   []string `yaml:"labels"`
+	Snapshot string   `yaml:"snapshot"`
+}
+
+type evalQuestion struct {
+	ID       string     `yaml:"id"`
+	Question string     `yaml:"question"`
+	CameraID string     `yaml:"camera_id"`
+	Cameras  []string   `yaml:"cameras"`
+	Expect   evalExpect `yaml:"expect"`
+}
+
+type evalExpect struct {
+	Times     []string `yaml:"times"` // "14:05", "2pm"
+	Labels    []string `yaml:"labels"`
+	NotLabels []string `yaml:"not_labels"`
+	Counts    []int    `yaml:"counts"`
+	Citations []int64  `yaml:"citations"`
+}
+
+// evalResult is the score of one question.
+type evalResult struct {
+	ID                string   `json:"id"`
+	Question          string   `json:"question"`
+	Answer            string   `json:"answer"`
+	Passed            bool     `json:"passed"`
+	Correctness       float64  `json:"correctness"`
+	Missing           []string `json:"missing,omitempty"`
+	Cited             []int64  `json:"cited"`
+	CitationPrecision float64  `json:"citation_precision"`
+	CitationRecall    float64  `json:"citation_recall"`
+	Grounded          bool     `json:"grounded"`
+	Seconds           float64  `json:"seconds"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// evalReport is what `eval` prints.
+type evalReport struct {
+	Suite             string       `json:"suite"`
+	Provider          string       `json:"provider"`
+	Tools             bool         `json:"tools"`
+	Questions         int          `json:"questions"`
+	Passed            int          `json:"passed"`
+	Correctness       float64      `json:"correctness"`
+	CitationPrecision float64      `json:"citation_precision"`
+	CitationRecall    float64      `json:"citation_recall"`
+	Results           []evalResult `json:"results"`
+}
+
+// runEval implements `./backend eval`. It returns the process exit code.
+func runEval(cfg Config, args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	suitePath := fs.String("suite", "", "golden question YAML file (required)")
+	provider := fs.String("provider", "fake", "LLM provider: fake, openai, ollama, llamacpp, or config to use config.yaml's llm: section")
+	model := fs.String("model", "", "model override")
+	baseURL := fs.String("base-url", "", "base URL override")
+	tools := fs.Bool("tools", cfg.Chat.Tools, "run in tool-calling mode (chat.tools)")
+	format := fs.String("format", "markdown", "report format: markdown or json")
+	out := fs.String("out", "", "write the report here instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *suitePath == "" || (*format != "markdown" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+
+	suite, err := loadEvalSuite(*suitePath)
+	if err != nil {
+		log.Printf("eval: %v", err)
+		return 2
+	}
+
+	if *provider != "config" {
+		cfg.LLM.Provider = *provider
+		cfg.LLM.BaseURL = ""
+	}
+	if *model != "" {
+		cfg.LLM.Model = *model
+	}
+	if *baseURL != "" {
+		cfg.LLM.BaseURL = *baseURL
+	}
+	cfg.Chat.Tools = *tools
+
+	llm, err := newLLMProvider(cfg.LLM)
+	if err != nil {
+		log.Printf("eval: %v", err)
+		return 2
+	}
+
+	report, err := evaluate(context.Background(), cfg, suite, llm)
+	if err != nil {
+		log.Printf("eval: %v", err)
+		return 2
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Printf("eval: %v", err)
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		writeEvalMarkdown(w, report)
+	}
+
+	if report.Passed < report.Questions {
+		return 1
+	}
+	return 0
+}
+
+// loadEvalSuite reads a suite file; fixture_db is made relative to it.
+func loadEvalSuite(path string) (*evalSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite evalSuite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if suite.Name == "" {
+		suite.Name = filepath.Base(path)
+	}
+	if suite.FixtureDB != "" && !filepath.IsAbs(suite.FixtureDB) {
+		suite.FixtureDB = filepath.Join(filepath.Dir(path), suite.FixtureDB)
+	}
+	if len(suite.Questions) == 0 {
+		return nil, fmt.Errorf("%s: no questions", path)
+	}
+	return &suite, nil
+}
+
+// evaluate loads the fixtures into a scratch DB and scores every question.
+// The suite's timezone and cameras replace config.yaml's.
+func evaluate(ctx context.Context, cfg Config, suite *evalSuite, llm LLMProvider) (*evalReport, error) {
+	dir, err := os.MkdirTemp("", "chat-eval-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath := filepath.Join(dir, "eval.db")
+	if suite.FixtureDB != "" {
+		data, err := os.ReadFile(suite.FixtureDB)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dbPath, data, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	openDB(dbPath)
+	defer db.Close()
+	for i, d := range suite.Detections {
+		t, err := time.Parse(time.RFC3339, d.Time)
+		if err != nil {
+			return nil, fmt.Errorf("detection %d: %w", i+1, err)
+		}
+		labels, _ := json.Marshal(d.Labels)
+		if _, err := insertDetection(float64(t.Unix()), d.Camera, string(labels), "[]", "[]", d.Snapshot, "[]"); err != nil {
+			return nil, err
+		}
+	}
+
+	if suite.Timezone != "" {
+		cfg.Timezone = suite.Timezone
+	}
+	if len(suite.Cameras) > 0 {
+		cfg.Cameras = suite.Cameras
+		cfg.CameraGroups = suite.CameraGroups
+	}
+	if len(cfg.Cameras) == 0 {
+		cfg.Cameras = fixtureCameras(db)
+	}
+
+	cfg.Snapshots.ThumbnailDir = filepath.Join(dir, "thumbs")
+	snapshots, err := NewLocalSnapshotStore(filepath.Join(dir, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+	app := NewApp(db, &cfg, snapshots, llm)
+	if suite.Now != "" {
+		now, err := time.Parse(time.RFC3339, suite.Now)
+		if err != nil {
+			return nil, fmt.Errorf("now: %w", err)
+		}
+		app.Now = func() time.Time { return now }
+	}
+
+	report := &evalReport{Suite: suite.Name, Provider: llm.Name(), Tools: cfg.Chat.Tools}
+	for _, q := range suite.Questions {
+		r := app.evalQuestion(ctx, q)
+		log.Printf("eval: %s passed=%v correctness=%.2f", r.ID, r.Passed, r.Correctness)
+		report.Results = append(report.Results, r)
+		report.Questions++
+		if r.Passed {
+			report.Passed++
+		}
+		report.Correctness += r.Correctness
+		report.CitationPrecision += r.CitationPrecision
+		report.CitationRecall += r.CitationRecall
+	}
+	n := float64(report.Questions)
+	report.Correctness /= n
+	report.CitationPrecision /= n
+	report.CitationRecall /= n
+	return report, nil
+}
+
+// fixtureCameras lists the cameras in the fixture DB, for suites that
+// don't configure any.
+func fixtureCameras(d *sql.DB) []CameraConfig {
+	var cams []CameraConfig
+	rows, err := d.Query("SELECT DISTINCT camera_id FROM detections ORDER BY camera_id")
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			cams = append(cams, CameraConfig{ID: id})
+		}
+	}
+	return cams
+}
+
+// evalQuestion runs one question through the /chat steps and scores it.
+func (app *App) evalQuestion(ctx context.Context, q evalQuestion) evalResult {
+	r := evalResult{ID: q.ID, Question: q.Question, Cited: []int64{}}
+	if r.ID == "" {
+		r.ID = q.Question
+	}
+	started := time.Now()
+	defer func() { r.Seconds = time.Since(started).Seconds() }()
+
+	req := chatQuery{CameraID: q.CameraID, Cameras: q.Cameras, Message: q.Question}
+	plan, err := app.prepareChat(ctx, req, noProgress)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	answer := plan.Answer
+	if answer == "" {
+		resp, err := app.LLM.Chat(ctx, LLMRequest{Messages: plan.Messages, Model: plan.Model})
+		if err != nil {
+			r.Error = err.Error()
+			return r
+		}
+		answer = resp.Content
+	}
+	answer, verification := app.groundAnswer(ctx, req, plan, answer)
+	r.Answer = answer
+	r.Grounded = verification == nil || verification.Grounded
+
+	for _, c := range plan.Citations {
+		r.Cited = append(r.Cited, c.EventID)
+	}
+	r.Missing = missingFacts(answer, q.Expect)
+	facts := len(q.Expect.Times) + len(q.Expect.Labels) + len(q.Expect.NotLabels) + len(q.Expect.Counts)
+	r.Correctness = 1
+	if facts > 0 {
+		r.Correctness = float64(facts-len(r.Missing)) / float64(facts)
+	}
+	r.CitationPrecision, r.CitationRecall = citationScore(r.Cited, q.Expect.Citations)
+	r.Passed = len(r.Missing) == 0 && r.CitationRecall == 1
+	return r
+}
+
+// reEvalNumber finds numbers once times and dates are removed.
+var reEvalNumber = regexp.MustCompile(`\b(\d+|one|two|three|four|five|six|seven|eight|nine|ten|twelve)\b`)
+
+// missingFacts lists the expected facts the answer lacks.
+func missingFacts(answer string, want evalExpect) []string {
+	var missing []string
+
+	mentioned := map[int]bool{}
+	for _, m := range clockMinutes(answer) {
+		mentioned[m] = true
+	}
+	for _, t := range want.Times {
+		mins := clockMinutes(t)
+		if len(mins) == 0 || !mentioned[mins[0]] {
+			missing = append(missing, "time "+t)
+		}
+	}
+
+	labels := labelsIn(answer)
+	for _, l := range want.Labels {
+		if !labels[strings.ToLower(l)] {
+			missing = append(missing, "label "+l)
+		}
+	}
+	for _, l := range want.NotLabels {
+		if labels[strings.ToLower(l)] {
+			missing = append(missing, "no label "+l)
+		}
+	}
+
+	text := strings.ToLower(answer)
+	for _, re := range []*regexp.Regexp{reAnswerRFC3339, reAnswerClock, reAnswerHour, reAnswerDate} {
+		text = re.ReplaceAllString(text, " ")
+	}
+	numbers := map[int]bool{}
+	for _, n := range reEvalNumber.FindAllString(text, -1) {
+		numbers[wordNumber(n)] = true
+	}
+	for _, c := range want.Counts {
+		if !numbers[c] {
+			missing = append(missing, fmt.Sprintf("count %d", c))
+		}
+	}
+	return missing
+}
+
+// citationScore returns precision and recall of cited against expected
+// event IDs. With nothing expected both are 1.
+func citationScore(cited, expected []int64) (precision, recall float64) {
+	if len(expected) == 0 {
+		return 1, 1
+	}
+	want := map[int64]bool{}
+	for _, id := range expected {
+		want[id] = true
+	}
+	hits := 0
+	for _, id := range cited {
+		if want[id] {
+			hits++
+		}
+	}
+	if len(cited) > 0 {
+		precision = float64(hits) / float64(len(cited))
+	}
+	return precision, float64(hits) / float64(len(expected))
+}
+
+// writeEvalMarkdown writes the report as a Markdown table.
+func writeEvalMarkdown(w io.Writer, r *evalReport) {
+	mode := "pipeline"
+	if r.Tools {
+		mode = "tools"
+	}
+	fmt.Fprintf(w, "# Chat eval: %s\n\n", r.Suite)
+	fmt.Fprintf(w, "Provider: `%s` (%s mode)\n\n", r.Provider, mode)
+	fmt.Fprintf(w, "| Passed | Correctness | Citation precision | Citation recall |\n")
+	fmt.Fprintf(w, "|--------|-------------|--------------------|-----------------|\n")
+	fmt.Fprintf(w, "| %d/%d | %.0f%% | %.0f%% | %.0f%% |\n\n", r.Passed, r.Questions,
+		100*r.Correctness, 100*r.CitationPrecision, 100*r.CitationRecall)
+
+	fmt.Fprintf(w, "| Question | Result | Correctness | Citations (P/R) | Missing |\n")
+	fmt.Fprintf(w, "|----------|--------|-------------|-----------------|---------|\n")
+	results := append([]evalResult(nil), r.Results...)
+	sort.SliceStable(results, func(i, j int) bool { return !results[i].Passed && results[j].Passed })
+	for _, res := range results {
+		status := "✅ pass"
+		if !res.Passed {
+			status = "❌ fail"
+		}
+		missing := strings.Join(res.Missing, ", ")
+		if res.Error != "" {
+			missing = "error: " + res.Error
+		}
+		fmt.Fprintf(w, "| `%s` | %s | %.0f%% | %.0f%% / %.0f%% | %s |\n", res.ID, status,
+			100*res.Correctness, 100*res.CitationPrecision, 100*res.CitationRecall, mdCell(missing))
+	}
+
+	fmt.Fprintf(w, "\n## Answers\n")
+	for _, res := range r.Results {
+		fmt.Fprintf(w, "\n**%s** — %s\n\n> %s\n", res.ID, res.Question, mdCell(res.Answer))
+	}
+}
+
+// mdCell keeps text on one line and out of the table syntax.
+func mdCell(s string) string {
+	return strings.NewReplacer("\n", " ", "|", "\\|").Replace(s)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+grounding.go
+------------
+
+Keeps chat answers tied to real detections:
+
+- Citations: every /chat answer comes with the events it was given as
+  context (event ID, camera, time, labels, snapshot URL).
+- Verification: a deterministic pass over the final answer that flags
+  times and objects that appear in neither the detection context nor the
+  question. chat.verify selects what happens then:
+    flag    (default) return the answer with verification.grounded=false
+    rewrite ask the LLM once to answer again from the context only; if that
+            still fails, answer with the cited detections themselves
+    off     skip the check
+*/
+
+// Citation is one detection an answer was based on.
+type Citation struct {
+	EventID     int64    `json:"event_id"`
+	CameraID    string   `json:"camera_id"`
+	Time        string   `json:"time"`
+	Timestamp   float64  `json:"timestamp"`
+	Labels      []string `json:"labels"`
+	SnapshotURL string   `json:"snapshot_url,omitempty"`
+}
+
+// Verification is the result of checking an answer against its context.
+type Verification struct {
+	Grounded  bool     `json:"grounded"`
+	Issues    []string `json:"issues,omitempty"`
+	Rewritten bool     `json:"rewritten,omitempty"`
+}
+
+// watchedLabels is the COCO subset YOLOv8 reports most around a house.
+// Answers mentioning one of them must have it in the context or question.
+var watchedLabels = []string{
+	"person", "car", "truck", "bus", "bicycle", "motorcycle", "dog", "cat",
+	"bird", "backpack", "umbrella", "handbag", "suitcase", "package",
+}
+
+// labelSynonyms maps irregular words in answers to labels.
+var labelSynonyms = map[string]string{
+	"people": "person", "persons": "person", "bike": "bicycle", "bikes": "bicycle",
+	"motorbike": "motorcycle", "motorbikes": "motorcycle",
+}
+
+var (
+	reAnswerRFC3339 = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})[t ](\d{2}):(\d{2})(?::\d{2}(?:\.\d+)?)?(?:z|[+-]\d{2}:\d{2})?`)
+	reAnswerClock   = regexp.MustCompile(`\b(\d{1,2}):(\d{2})(?::\d{2})?\s*(am|pm)?\b`)
+	reAnswerHour    = regexp.MustCompile(`\b(\d{1,2})\s*(am|pm)\b`)
+	reAnswerDate    = regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}\b`)
+)
+
+// citationsFor turns context detections into citations, oldest first,
+// without duplicates.
+func citationsFor(hits []detectionHit, loc *time.Location) []Citation {
+	var cites []Citation
+	seen := map[int64]bool{}
+	for _, h := range hits {
+		if seen[h.ID] {
+			continue
+		}
+		seen[h.ID] = true
+		c := Citation{
+			EventID:     h.ID,
+			CameraID:    h.CameraID,
+			Time:        time.Unix(int64(h.Timestamp), 0).In(loc).Format(time.RFC3339),
+			Timestamp:   h.Timestamp,
+			SnapshotURL: snapshotURL(h.SnapshotFile),
+		}
+		json.Unmarshal([]byte(h.Labels), &c.Labels)
+		cites = append(cites, c)
+	}
+	return cites
+}
+
+// clockMinutes returns every clock time in text as minutes past midnight.
+func clockMinutes(text string) []int {
+	text = strings.ToLower(text)
+	var mins []int
+	add := func(h, m int, ampm string) {
+		switch {
+		case ampm == "am" && h == 12:
+			h = 0
+		case ampm == "pm" && h < 12:
+			h += 12
+		}
+		if h < 24 && m < 60 {
+			mins = append(mins, h*60+m)
+		}
+	}
+	for _, m := range reAnswerRFC3339.FindAllStringSubmatch(text, -1) {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3])
+		add(h, min, "")
+	}
+	// Drop the RFC3339 times so their clock part and offset aren't read again.
+	rest := reAnswerRFC3339.ReplaceAllString(text, " ")
+	for _, m := range reAnswerClock.FindAllStringSubmatch(rest, -1) {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		add(h, min, m[3])
+	}
+	for _, m := range reAnswerHour.FindAllStringSubmatch(rest, -1) {
+		h, _ := strconv.Atoi(m[1])
+		add(h, 0, m[2])
+	}
+	return mins
+}
+
+// labelsIn returns the watched labels mentioned in text, singular or plural.
+func labelsIn(text string) map[string]bool {
+	found := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	for _, w := range words {
+		if l, ok := labelSynonyms[w]; ok {
+			found[l] = true
+			continue
+		}
+		forms := []string{w, strings.TrimSuffix(w, "s"), strings.TrimSuffix(w, "es")}
+		for _, l := range watchedLabels {
+			if containsFold(forms, l) {
+				found[l] = true
+			}
+		}
+	}
+	return found
+}
+
+// verifyAnswer checks that the times, dates and objects in answer all come
+// from the evidence (context, citations, resolved range) or the question.
+func verifyAnswer(answer, question string, plan *chatPlan, now time.Time) *Verification {
+	evidence := plan.Context + "\n" + question
+	for _, c := range plan.Citations {
+		evidence += "\n" + c.Time + " " + strings.Join(c.Labels, " ")
+	}
+	if plan.Range != nil {
+		evidence += "\n" + plan.Range.String() + " " +
+			plan.Range.Start.Format(time.RFC3339) + " " + plan.Range.End.Format(time.RFC3339)
+	}
+	evidence += "\n" + now.Format(time.RFC3339)
+
+	v := &Verification{Grounded: true}
+
+	// Times: allow a minute either way for rounding ("03:40:59" → "3:41").
+	allowed := map[int]bool{}
+	for _, m := range clockMinutes(evidence) {
+		allowed[m-1], allowed[m], allowed[m+1] = true, true, true
+	}
+	for _, m := range clockMinutes(answer) {
+		if !allowed[m] {
+			v.Issues = append(v.Issues, fmt.Sprintf("time %02d:%02d is not in the detections", m/60, m%60))
+		}
+	}
+
+	for _, d := range reAnswerDate.FindAllString(answer, -1) {
+		if !strings.Contains(evidence, d) {
+			v.Issues = append(v.Issues, fmt.Sprintf("date %s is not in the detections", d))
+		}
+	}
+
+	known := labelsIn(evidence)
+	for _, o := range plan.Objects {
+		known[strings.ToLower(o)] = true
+	}
+	for l := range labelsIn(answer) {
+		if !known[l] {
+			v.Issues = append(v.Issues, fmt.Sprintf("object %q is not in the detections", l))
+		}
+	}
+
+	v.Grounded = len(v.Issues) == 0
+	return v
+}
+
+// groundAnswer verifies an answer and, depending on chat.verify, rewrites it.
+// It returns the answer to send and the verification (nil when off).
+func (app *App) groundAnswer(ctx context.Context, req chatQuery, plan *chatPlan, answer string) (string, *Verification) {
+	mode := app.Config.Chat.Verify
+	if mode == "off" {
+		return answer, nil
+	}
+
+	v := verifyAnswer(answer, req.Message, plan, app.now())
+	if v.Grounded {
+		return answer, v
+	}
+	log.Printf("handleChat: answer not grounded: %v", v.Issues)
+	if mode != "rewrite" {
+		return answer, v
+	}
+
+	// One retry from the context alone, then fall back to the evidence.
+	retry, err := app.LLM.Chat(ctx, LLMRequest{Messages: []ChatMessage{
+		{Role: "system", Content: "You are a helpful camera assistant. Only state facts from the detection context."},
+		{Role: "user", Content: fmt.Sprintf(
+			"Detection context:\n%s\n\nUser question: %s\n\nA previous answer was: %q\n"+
+				"It is wrong: %s. Answer the question again using only the detection context.",
+			plan.Context, req.Message, answer, strings.Join(v.Issues, "; "))},
+	}})
+	if err == nil {
+		if v2 := verifyAnswer(retry.Content, req.Message, plan, app.now()); v2.Grounded {
+			return retry.Content, &Verification{Grounded: true, Issues: v.Issues, Rewritten: true}
+		}
+	} else {
+		log.Printf("handleChat: rewrite failed (%s): %v", app.LLM.Name(), err)
+	}
+	return evidenceAnswer(plan), &Verification{Grounded: true, Issues: v.Issues, Rewritten: true}
+}
+
+// evidenceAnswer states the cited detections without any LLM wording.
+func evidenceAnswer(plan *chatPlan) string {
+	if len(plan.Citations) == 0 {
+		return "I couldn't find any matching detections."
+	}
+	var lines []string
+	for _, c := range plan.Citations {
+		lines = append(lines, fmt.Sprintf("%s at %s (%s, event %d)",
+			strings.Join(c.Labels, ", "), c.Time, c.CameraID, c.EventID))
+	}
+	return "I can only confirm these detections: " + strings.Join(lines, "; ") + "."
+}
@@ -1,15 +1,15 @@
 package main
 
 import (
-	// "database/sql"
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +24,7 @@ func (app *App) handleTimeline(w http.ResponseWriter, r *http.Request) {
 	// Example: /timeline?camera_id=garage_webcam&label=person&start_time=...&end_time=...
 	cameraID := r.URL.Query().Get("camera_id")
 	label := r.URL.Query().Get("label")
+	zone := r.URL.Query().Get("zone")
 	startTimeStr := r.URL.Query().Get("start_time")
 	endTimeStr := r.URL.Query().Get("end_time")
 
@@ -32,6 +33,7 @@ func (app *App) handleTimeline(w http.ResponseWriter, r *http.Request) {
 	// === Build WHERE conditions and arguments ===
 	var conditions []string
 	var args []interface{}
+	var start, end *float64 // for loitering events
 
 	if cameraID != "" {
 		conditions = append(conditions, "camera_id = ?")
@@ -45,11 +47,19 @@ func (app *App) handleTimeline(w http.ResponseWriter, r *http.Request) {
 		args = append(args, "%"+label+"%")
 	}
 
+	if zone != "" {
+		// Objects in the zone (zones.go)
+		cond, zargs := zoneFilter([]string{zone})
+		conditions = append(conditions, cond)
+		args = append(args, zargs...)
+	}
+
 	if startTimeStr != "" {
 		conditions = append(conditions, "timestamp >= ?")
 		startTime, err := strconv.ParseFloat(startTimeStr, 64)
 		if err == nil {
 			args = append(args, startTime)
+			start = &startTime
 		}
 	}
 
@@ -58,11 +68,12 @@ func (app *App) handleTimeline(w http.ResponseWriter, r *http.Request) {
 		endTime, err := strconv.ParseFloat(endTimeStr, 64)
 		if err == nil {
 			args = append(args, endTime)
+			end = &endTime
 		}
 	}
 
 	// === Final SQL query ===
-	query := "SELECT timestamp, camera_id, labels, boxes, snapshot_file FROM detections"
+	query := "SELECT id, timestamp, camera_id, labels, boxes, COALESCE(confidences, ''), snapshot_file, COALESCE(zones, '[]') FROM detections"
 	if len(conditions) > 0 {
 		query += " WHERE " + strings.Join(conditions, " AND ")
 	}
@@ -85,38 +96,84 @@ func (app *App) handleTimeline(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("results: %v\n", results)
 	for rows.Next() {
+		var id int64
 		var ts float64
-		var cid, labels, boxes, snapshotFile string
+		var cid, labels, boxes, confidences, snapshotFile, zones string
 
-		if err := rows.Scan(&ts, &cid, &labels, &boxes, &snapshotFile); err != nil {
+		if err := rows.Scan(&id, &ts, &cid, &labels, &boxes, &confidences, &snapshotFile, &zones); err != nil {
 			log.Printf("Timeline row scan failed: %v", err)
 			continue
 		}
 
-		// Build safe snapshot URL (strip ./snapshots/)
-		snapshotURL := ""
-		if snapshotFile != "" {
-			snapshotURL = fmt.Sprintf("/snapshots/%s", filepath.Base(snapshotFile))
-		}
-
 		results = append(results, map[string]interface{}{
+			"id":            id, // used by /holds to pin a single event
+			"kind":          "detection",
 			"timestamp":     ts,
 			"camera_id":     cid,
 			"labels":        labels,
 			"boxes":         boxes,
-			"snapshot_file": snapshotFile, // raw path, for debug
-			"snapshot_url":  snapshotURL,  // public URL via static file server
+			"confidences":   confidences,
+			"zones":         zones,                     // per object, e.g. [["driveway"],[]]
+			"snapshot_file": snapshotFile,              // raw path, for debug
+			"snapshot_url":  snapshotURL(snapshotFile), // public URL via /snapshots/
 		})
 	}
 
+	// === Merge loitering events (dwell.go), newest first ===
+	loitering, err := app.dwellTimelineEvents(cameraID, label, zone, start, end)
+	if err != nil {
+		log.Printf("Timeline loitering query error: %v", err)
+	} else if len(loitering) > 0 {
+		results = append(results, loitering...)
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i]["timestamp"].(float64) > results[j]["timestamp"].(float64)
+		})
+		if len(results) > 100 {
+			results = results[:100]
+		}
+	}
+
 	// === Return JSON response ===
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
 
-// handleSnapshot serves snapshot image files from your ./snapshots directory.
-// Example: GET /snapshot?file=garage_webcam_1752205055.jpg
-func handleSnapshot(w http.ResponseWriter, r *http.Request) {
+// handleVisits serves GET /visits: detections grouped into visits, as the
+// get_visits chat tool sees them.
+// Example: /visits?camera_id=garage_webcam&label=car&zone=driveway&start=2025-07-11&gap_seconds=300&limit=50
+func (app *App) handleVisits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+
+	q := r.URL.Query()
+	args := toolArgs{
+		Label: q.Get("label"),
+		Zone:  q.Get("zone"),
+		Start: q.Get("start"),
+		End:   q.Get("end"),
+	}
+	args.GapSeconds, _ = strconv.Atoi(q.Get("gap_seconds"))
+	args.Limit, _ = strconv.Atoi(q.Get("limit"))
+	args.cameras = app.cameraIDs()
+	if id := q.Get("camera_id"); id != "" {
+		args.cameras = app.expandCameras([]string{id})
+	}
+
+	result, err := app.toolVisits(r.Context(), args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("Visits query failed: %v", err)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleSnapshot serves snapshot images from the snapshot store.
+// Optional w, h (max pixels) and quality (1-100) return a cached resized variant.
+// overlay=true draws the stored boxes; labels=car,person limits which ones
+// and event_id picks the detection when several share the snapshot.
+// Example: GET /snapshot?file=garage_webcam/2025-07-11/<hash>.jpg&w=320
+func (app *App) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	fileName := r.URL.Query().Get("file")
@@ -125,24 +182,140 @@ func handleSnapshot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Secure the path to avoid path traversal
-	safePath := filepath.Join("./snapshots", filepath.Base(fileName))
+	spec, err := parseThumbSpec(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app.serveSnapshot(w, r, fileName, spec)
+}
+
+// parseThumbSpec reads the optional w, h and quality query params, snapped
+// to the allowed steps (see thumbnail.go).
+func parseThumbSpec(r *http.Request) (ThumbSpec, error) {
+	var spec ThumbSpec
+	params := []struct {
+		name     string
+		dst      *int
+		min, max int
+	}{
+		{"w", &spec.W, 1, maxThumbnailSide},
+		{"h", &spec.H, 1, maxThumbnailSide},
+		{"quality", &spec.Quality, 1, 100},
+	}
+	for _, p := range params {
+		raw := r.URL.Query().Get(p.name)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < p.min || v > p.max {
+			return spec, fmt.Errorf("invalid '%s': must be %d-%d", p.name, p.min, p.max)
+		}
+		*p.dst = v
+	}
+
+	if overlay := r.URL.Query().Get("overlay"); overlay != "" {
+		v, err := strconv.ParseBool(overlay)
+		if err != nil {
+			return spec, fmt.Errorf("invalid 'overlay': must be true or false")
+		}
+		spec.Overlay = v
+	}
+	if labels := r.URL.Query().Get("labels"); labels != "" && spec.Overlay {
+		for _, l := range strings.Split(labels, ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				spec.Labels = append(spec.Labels, l)
+			}
+		}
+	}
+	if raw := r.URL.Query().Get("event_id"); raw != "" && spec.Overlay {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			return spec, fmt.Errorf("invalid 'event_id'")
+		}
+		spec.EventID = id
+	}
+	return spec.snapped(), nil
+}
+
+// handleSnapshotFile serves GET /snapshots/<key> (mounted behind StripPrefix).
+func (app *App) handleSnapshotFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	app.serveSnapshot(w, r, r.URL.Path, ThumbSpec{})
+}
 
-	file, err := os.Open(safePath)
+// serveSnapshot looks up a snapshot key in the store and writes it as a JPEG,
+// resized per spec. Sets ETag/Cache-Control so browsers can revalidate cheaply.
+func (app *App) serveSnapshot(w http.ResponseWriter, r *http.Request, fileName string, spec ThumbSpec) {
+	// Secure the key to avoid path traversal
+	key, err := cleanSnapshotKey(fileName)
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		log.Printf("Snapshot file not found: %s", safePath)
+		http.Error(w, "Invalid snapshot name", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// Serve as image/jpeg
-	w.Header().Set("Content-Type", "image/jpeg")
-	_, err = io.Copy(w, file)
+	// Overlays draw one detection's boxes; resolve which before the cache lookup.
+	var dets []Detection
+	if spec.Overlay {
+		spec.EventID, dets, err = detectionsForSnapshot(app.DB, key, spec.EventID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Detection not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to serve snapshot", http.StatusInternalServerError)
+			log.Printf("Load boxes for %s failed: %v", key, err)
+			return
+		}
+		spec.Labels = overlayLabels(spec.Labels, dets)
+	}
+
+	var data []byte
+	if spec.IsOriginal() {
+		data, err = app.Snapshots.Get(r.Context(), key)
+	} else {
+		data, err = app.Thumbs.Get(key, spec, func() ([]byte, error) {
+			src, err := app.Snapshots.Get(r.Context(), key)
+			if err != nil || !spec.Overlay {
+				return src, err
+			}
+			return renderOverlay(src, dets, spec.Labels)
+		})
+	}
+	if errors.Is(err, ErrSnapshotNotFound) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		log.Printf("Snapshot not found: %s", key)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to serve snapshot", http.StatusInternalServerError)
-		log.Printf("Snapshot serve failed: %v", err)
+		log.Printf("Snapshot read failed: %s (%v)", key, err)
+		return
+	}
+
+	// Sharded keys are content-addressed (see snapshotlayout.go), so they never
+	// change. Overlays depend on the detection rows, so they are not immutable.
+	if strings.Contains(key, "/") && !spec.Overlay {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	w.Header().Set("ETag", contentETag(data))
+
+	// Serve as image/jpeg (ServeContent answers If-None-Match with 304)
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, path.Base(key), time.Time{}, bytes.NewReader(data))
+}
+
+// snapshotURL turns a snapshot_file column value into its public /snapshots/ URL.
+func snapshotURL(snapshotFile string) string {
+	key, err := cleanSnapshotKey(snapshotFile)
+	if snapshotFile == "" || err != nil {
+		return ""
 	}
+	return "/snapshots/" + key
 }
 
 // handleLatest returns the latest detection for a camera
@@ -196,202 +369,3 @@ func (app *App) camerasHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to write /cameras response: %v", err)
 	}
 }
-
-// handleChat handles POST /chat requests.
-// It receives { camera_id, message } JSON and returns { answer: "..." } JSON.// handleChat handles POST /chat for LLM queries.
-// It returns a fake answer for now, with full CORS handling.
-
-// === Structures for Ollama ===
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type ChatRequest struct {
-	Model    string        `json:"model"`
-	Messages []ChatMessage `json:"messages"`
-}
-
-type ChatChoice struct {
-	Message ChatMessage `json:"message"`
-}
-
-type ChatResponse struct {
-	Choices []ChatChoice `json:"choices"`
-}
-
-func (app *App) handleChat(w http.ResponseWriter, r *http.Request) {
-	// === CORS ===
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	w.Header().Set("Content-Type", "application/json")
-
-	if r.Method == http.MethodOptions {
-		return
-	}
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// === Parse request ===
-	var req struct {
-		CameraID string `json:"camera_id"`
-		Message  string `json:"message"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
-	}
-	log.Printf("handleChat: camera_id=%s message=%s", req.CameraID, req.Message)
-
-	// === STEP 1: Extract object(s) ===
-	extractionPrompt := fmt.Sprintf(
-		"User question: %s\n\nExtract the main object(s) or labels the user wants to know about. Return ONLY a JSON array, e.g. [\"car\"] or [\"person\", \"dog\"]. If no object, return [].",
-		req.Message)
-
-	extractionReq := ChatRequest{
-		Model: "llama3",
-		Messages: []ChatMessage{
-			{Role: "system", Content: "You extract objects only. No explanation."},
-			{Role: "user", Content: extractionPrompt},
-		},
-	}
-
-	bodyBytes, _ := json.Marshal(extractionReq)
-	resp, err := http.Post(
-		"http://localhost:11434/v1/chat/completions",
-		"application/json",
-		bytes.NewBuffer(bodyBytes),
-	)
-	if err != nil {
-		log.Printf("Ollama extract POST failed: %v", err)
-		http.Error(w, "LLM extraction failed", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Ollama extract status %d", resp.StatusCode)
-		http.Error(w, "LLM extraction non-200", http.StatusInternalServerError)
-		return
-	}
-
-	var extractResp ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&extractResp); err != nil {
-		log.Printf("Decode extract response failed: %v", err)
-		http.Error(w, "Invalid extract response", http.StatusInternalServerError)
-		return
-	}
-
-	// Parse the extracted JSON array
-	extracted := extractResp.Choices[0].Message.Content
-	log.Printf("handleChat: extracted raw: %s", extracted)
-
-	var objects []string
-	if err := json.Unmarshal([]byte(extracted), &objects); err != nil {
-		log.Printf("JSON unmarshal failed: %v", err)
-		objects = []string{}
-	}
-	log.Printf("handleChat: extracted objects: %v", objects)
-
-	// === STEP 2: Query DB for most recent matching detection ===
-	var contextString string
-
-	if len(objects) > 0 {
-		// Use first extracted object for now
-		object := objects[0]
-		log.Printf("Searching for object: %s", object)
-
-		row := app.DB.QueryRow(`
-			SELECT timestamp, labels FROM detections
-			WHERE camera_id = ? AND labels LIKE ?
-			ORDER BY timestamp DESC LIMIT 1
-		`, req.CameraID, "%"+object+"%")
-
-		var ts float64
-		var labels string
-		err := row.Scan(&ts, &labels)
-		if err == nil {
-			t := time.Unix(int64(ts), 0).Format(time.RFC3339)
-			contextString = fmt.Sprintf("- Last detection: %s Labels: %s\n", t, labels)
-		} else {
-			contextString = fmt.Sprintf("No detections found for '%s'.", object)
-		}
-
-	} else {
-		// No object found → fallback to latest 5
-		rows, err := app.DB.Query(`
-			SELECT timestamp, labels FROM detections
-			WHERE camera_id = ?
-			ORDER BY timestamp DESC LIMIT 5
-		`, req.CameraID)
-		if err != nil {
-			log.Printf("Fallback DB query failed: %v", err)
-			contextString = "No detection history available."
-		} else {
-			defer rows.Close()
-			for rows.Next() {
-				var ts float64
-				var labels string
-				rows.Scan(&ts, &labels)
-				t := time.Unix(int64(ts), 0).Format(time.RFC3339)
-				contextString += fmt.Sprintf("- Time: %s Labels: %s\n", t, labels)
-			}
-			if contextString == "" {
-				contextString = "No recent detections found."
-			}
-		}
-	}
-
-	// === STEP 3: Final prompt ===
-	finalPrompt := fmt.Sprintf(
-		"Camera: %s\n\nDetection context:\n%s\n\nUser question: %s",
-		req.CameraID, contextString, req.Message)
-
-	log.Printf("handleChat: final prompt:\n%s", finalPrompt)
-
-	// === STEP 4: Send final prompt to Ollama ===
-	finalReq := ChatRequest{
-		Model: "llama3",
-		Messages: []ChatMessage{
-			{Role: "system", Content: "You are a helpful camera assistant."},
-			{Role: "user", Content: finalPrompt},
-		},
-	}
-
-	bodyBytes, _ = json.Marshal(finalReq)
-	resp2, err := http.Post(
-		"http://localhost:11434/v1/chat/completions",
-		"application/json",
-		bytes.NewBuffer(bodyBytes),
-	)
-	if err != nil {
-		log.Printf("Ollama final POST failed: %v", err)
-		http.Error(w, "LLM final call failed", http.StatusInternalServerError)
-		return
-	}
-	defer resp2.Body.Close()
-
-	if resp2.StatusCode != http.StatusOK {
-		log.Printf("Ollama final returned status %d", resp2.StatusCode)
-		http.Error(w, "LLM final non-200", http.StatusInternalServerError)
-		return
-	}
-
-	var finalResp ChatResponse
-	if err := json.NewDecoder(resp2.Body).Decode(&finalResp); err != nil {
-		log.Printf("Decode final response failed: %v", err)
-		http.Error(w, "Invalid final response", http.StatusInternalServerError)
-		return
-	}
-
-	answer := finalResp.Choices[0].Message.Content
-	log.Printf("handleChat: final answer: %s", answer)
-
-	// === Return to frontend ===
-	json.NewEncoder(w).Encode(map[string]string{
-		"answer": answer,
-	})
-}
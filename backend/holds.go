@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+holds.go
+--------
+
+Legal holds ("pins") keep detections and their snapshots around past the
+retention window, e.g. while a break-in is being investigated.
+
+A hold is either:
+- a single event:            { event_id }
+- a camera + time range:     { camera_id, start_time, end_time }
+
+Every cleanup job must add notHeldClause to its WHERE so pinned rows
+(and therefore their snapshots) are never touched. Retention (retention.go)
+is the only cleanup job today; there is no quota-based cleanup yet, and
+when one is added it must use notHeldClause too.
+*/
+
+// notHeldClause matches detections rows that are NOT covered by any hold.
+// It expects the detections table to be referenced as "detections".
+const notHeldClause = `NOT EXISTS (
+	SELECT 1 FROM holds h
+	WHERE h.event_id = detections.id
+	   OR (h.event_id IS NULL
+	       AND h.camera_id = detections.camera_id
+	       AND detections.timestamp BETWEEN h.start_time AND h.end_time)
+)`
+
+// Hold is a single legal hold as returned by /holds.
+type Hold struct {
+	ID        int64   `json:"id"`
+	EventID   *int64  `json:"event_id,omitempty"`
+	CameraID  string  `json:"camera_id,omitempty"`
+	StartTime float64 `json:"start_time,omitempty"`
+	EndTime   float64 `json:"end_time,omitempty"`
+	Reason    string  `json:"reason"`
+	CreatedBy string  `json:"created_by"`
+	CreatedAt float64 `json:"created_at"`
+}
+
+// insertHold stores a new hold and returns its ID.
+func insertHold(d *sql.DB, h Hold) (int64, error) {
+	var eventID interface{}
+	if h.EventID != nil {
+		eventID = *h.EventID
+	}
+	res, err := d.Exec(
+		`INSERT INTO holds (event_id, camera_id, start_time, end_time, reason, created_by, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		eventID, h.CameraID, h.StartTime, h.EndTime, h.Reason, h.CreatedBy, h.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// listHolds returns all holds, newest first.
+func listHolds(d *sql.DB) ([]Hold, error) {
+	rows, err := d.Query(`
+		SELECT id, event_id, COALESCE(camera_id, ''), COALESCE(start_time, 0), COALESCE(end_time, 0),
+		       COALESCE(reason, ''), COALESCE(created_by, ''), COALESCE(created_at, 0)
+		FROM holds ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []Hold{}
+	for rows.Next() {
+		var h Hold
+		var eventID sql.NullInt64
+		if err := rows.Scan(&h.ID, &eventID, &h.CameraID, &h.StartTime, &h.EndTime, &h.Reason, &h.CreatedBy, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		if eventID.Valid {
+			id := eventID.Int64
+			h.EventID = &id
+		}
+		holds = append(holds, h)
+	}
+	return holds, rows.Err()
+}
+
+// deleteHold removes a hold by ID. Returns false if it did not exist.
+func deleteHold(d *sql.DB, id int64) (bool, error) {
+	res, err := d.Exec("DELETE FROM holds WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := res.RowsAffected()
+	return affected > 0, nil
+}
+
+// handleHolds handles /holds:
+//
+//	GET    /holds          → list all holds
+//	POST   /holds          → create a hold (event or camera + time range)
+//	DELETE /holds?id=...   → release a hold
+func (app *App) handleHolds(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+
+	case http.MethodGet:
+		holds, err := listHolds(app.DB)
+		if err != nil {
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			log.Printf("List holds failed: %v", err)
+			return
+		}
+		json.NewEncoder(w).Encode(holds)
+
+	case http.MethodPost:
+		var h Hold
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if h.Reason == "" || h.CreatedBy == "" {
+			http.Error(w, "Missing 'reason' or 'created_by'", http.StatusBadRequest)
+			return
+		}
+		if h.EventID == nil {
+			if h.CameraID == "" || h.EndTime <= 0 || h.EndTime < h.StartTime {
+				http.Error(w, "Need either 'event_id' or 'camera_id' with a valid 'start_time'/'end_time'", http.StatusBadRequest)
+				return
+			}
+		} else {
+			// An event hold only pins that row; ignore any range fields.
+			h.CameraID, h.StartTime, h.EndTime = "", 0, 0
+			var exists bool
+			if err := app.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM detections WHERE id = ?)", *h.EventID).Scan(&exists); err != nil {
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				log.Printf("Check hold event failed: %v", err)
+				return
+			}
+			if !exists {
+				http.Error(w, "Event not found", http.StatusNotFound)
+				return
+			}
+		}
+		h.CreatedAt = float64(time.Now().Unix())
+
+		id, err := insertHold(app.DB, h)
+		if err != nil {
+			http.Error(w, "Failed to create hold", http.StatusInternalServerError)
+			log.Printf("Insert hold failed: %v", err)
+			return
+		}
+		h.ID = id
+		log.Printf("[Holds] Created hold %d by %s: %s", h.ID, h.CreatedBy, h.Reason)
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+			return
+		}
+		found, err := deleteHold(app.DB, id)
+		if err != nil {
+			http.Error(w, "Failed to delete hold", http.StatusInternalServerError)
+			log.Printf("Delete hold failed: %v", err)
+			return
+		}
+		if !found {
+			http.Error(w, "Hold not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("[Holds] Released hold %d", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
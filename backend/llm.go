@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+llm.go
+------
+
+LLMProvider hides which local (or remote) LLM server answers /chat.
+Pick one per deployment in config.yaml:
+
+	llm:
+	  provider: openai     # 'openai' (any OpenAI-compatible API), 'ollama', 'llamacpp' or 'fake'
+	  base_url: http://localhost:11434/v1
+	  model: llama3
+
+Implementations:
+- llm_openai.go   — OpenAI-compatible /v1/chat/completions (Ollama, vLLM, LM Studio, OpenAI ...)
+- llm_ollama.go   — Ollama native /api/chat
+- llm_llamacpp.go — llama.cpp server
+- llm_fake.go     — deterministic offline provider for tests and evals
+*/
+
+// ChatMessage is one message in an LLM conversation.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// ToolCalls is set on assistant messages that asked for tools.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID links a role "tool" result to the call it answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	// Images are JPEGs for vision models (see MarshalJSON and vision.go).
+	Images [][]byte `json:"-"`
+}
+
+// MarshalJSON writes the OpenAI wire format: a plain string content, or,
+// with images, a list of text and image_url (data URL) parts.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage // no methods, so no recursion
+	if len(m.Images) == 0 {
+		return json.Marshal(plain(m))
+	}
+
+	parts := []map[string]interface{}{{"type": "text", "text": m.Content}}
+	for _, img := range m.Images {
+		parts = append(parts, map[string]interface{}{
+			"type":      "image_url",
+			"image_url": map[string]string{"url": "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(img)},
+		})
+	}
+	return json.Marshal(struct {
+		plain
+		Content []map[string]interface{} `json:"content"`
+	}{plain(m), parts})
+}
+
+// Tool describes a function the model may call (OpenAI "tools" format,
+// which Ollama and llama.cpp accept as well).
+type Tool struct {
+	Type     string       `json:"type"` // always "function"
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the name, description and JSON Schema of a tool.
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is one tool invocation requested by the model.
+type ToolCall struct {
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the tool and carries its arguments as JSON text.
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// LLMRequest is a provider-independent chat request.
+type LLMRequest struct {
+	Messages []ChatMessage
+	// Model overrides the configured model for this call (optional).
+	Model string
+	// Tools the model may call instead of answering (optional).
+	Tools []Tool
+}
+
+// LLMResponse is the provider-independent answer.
+type LLMResponse struct {
+	Content string
+	// ToolCalls is non-empty when the model wants tool results before answering.
+	ToolCalls []ToolCall
+}
+
+// TokenFunc receives streamed answer chunks. Returning an error aborts the stream.
+type TokenFunc func(token string) error
+
+// LLMProvider sends chat requests to an LLM backend.
+type LLMProvider interface {
+	// Name identifies the provider in logs, e.g. "ollama(llama3)".
+	Name() string
+	Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error)
+	// ChatStream is like Chat but calls onToken for each chunk as the model
+	// produces it. The returned response holds the full text.
+	ChatStream(ctx context.Context, req LLMRequest, onToken TokenFunc) (*LLMResponse, error)
+}
+
+// newLLMProvider builds the provider selected in config.yaml, filling in
+// per-provider defaults for anything left empty.
+func newLLMProvider(cfg LLMConfig) (LLMProvider, error) {
+	if cfg.Model == "" {
+		cfg.Model = "llama3"
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = 120
+	}
+	if cfg.RetryBackoffMs <= 0 {
+		cfg.RetryBackoffMs = 500
+	}
+
+	switch cfg.Provider {
+	case "", "openai":
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = "http://localhost:11434/v1" // Ollama's OpenAI-compatible API
+		}
+		return NewOpenAIProvider(cfg), nil
+	case "ollama":
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = "http://localhost:11434"
+		}
+		return NewOllamaProvider(cfg), nil
+	case "llamacpp":
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = "http://localhost:8081" // :8080 is taken by this backend
+		}
+		return NewLlamaCppProvider(cfg), nil
+	case "fake":
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider %q", cfg.Provider)
+	}
+}
+
+// httpStatusError is returned for non-2xx responses from an LLM server.
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("llm server returned %d: %s", e.StatusCode, e.Body)
+}
+
+// noRetryError marks a failure that must not be retried, e.g. a stream that
+// already sent tokens to the client.
+type noRetryError struct{ error }
+
+func (e noRetryError) Unwrap() error { return e.error }
+
+// retryable reports whether a failed LLM call is worth trying again:
+// network errors, 429 and 5xx are; bad requests and cancellations are not.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var nr noRetryError
+	if errors.As(err, &nr) {
+		return false
+	}
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	}
+	return true
+}
+
+// withRetries runs call up to 1+maxRetries times with exponential backoff.
+func withRetries(ctx context.Context, name string, maxRetries int, backoff time.Duration, call func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = call()
+		if err == nil || attempt >= maxRetries || !retryable(err) {
+			return err
+		}
+
+		wait := backoff << attempt
+		log.Printf("[LLM] %s call failed (attempt %d/%d): %v — retrying in %s", name, attempt+1, maxRetries+1, err, wait)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// llmClients returns the HTTP clients for one provider: a plain one with an
+// overall timeout, and one for streaming where only the wait for the first
+// response byte is bounded (answers on a Pi can take minutes to stream).
+func llmClients(cfg LLMConfig) (client, stream *http.Client) {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{Timeout: timeout}, &http.Client{Transport: transport}
+}
+
+// postJSON POSTs body as JSON and decodes a 2xx JSON response into out.
+func postJSON(ctx context.Context, client *http.Client, url, apiKey string, body, out interface{}) error {
+	resp, err := postRaw(ctx, client, url, apiKey, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// postRaw POSTs body as JSON and returns the open 2xx response. The caller
+// must close resp.Body.
+func postRaw(ctx context.Context, client *http.Client, url, apiKey string, body interface{}) (*http.Response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// streamLines POSTs body and calls onLine for each non-empty line of the
+// response (SSE "data: ..." lines or NDJSON objects). Once a line has been
+// handled the call is no longer retryable.
+func streamLines(ctx context.Context, client *http.Client, url, apiKey string, body interface{}, onLine func(line string) (done bool, err error)) error {
+	resp, err := postRaw(ctx, client, url, apiKey, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	started := false
+	fail := func(err error) error {
+		if started {
+			return noRetryError{err}
+		}
+		return err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		done, err := onLine(line)
+		started = true
+		if err != nil {
+			return fail(err)
+		}
+		if done {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return nil
+}
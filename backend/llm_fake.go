@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image/jpeg"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// FakeProvider is a deterministic, offline LLMProvider for tests and evals.
+//
+//   - Extraction calls (system prompt mentions "extract") return a JSON array of
+//     the known object labels found in the question.
+//   - Calls offering tools first call one that fits the question (see
+//     pickTool), then answer by repeating the tool results.
+//   - Calls with images answer as a vision model would: by citing them
+//     (see fakeVisionAnswer).
+//   - Every other call answers by repeating the detection context it was given,
+//     so the answer only ever contains facts from the prompt.
+//   - Replies queued with Script or ScriptResponse are returned first, in order.
+//   - Embed maps words to a few concepts (see fakeConcepts), so "someone
+//     delivering a parcel" lands near a person+truck visit.
+type FakeProvider struct {
+	mu     sync.Mutex
+	script []LLMResponse
+	Calls  []LLMRequest // the last maxFakeCalls requests received, for assertions
+	Vocab  []string     // labels the extractor recognises
+}
+
+// maxFakeCalls bounds Calls, so `provider: fake` can run as a server.
+const maxFakeCalls = 100
+
+// NewFakeProvider returns a FakeProvider with the default vocabulary.
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{Vocab: watchedLabels}
+}
+
+// Script queues canned replies that are returned before any generated ones.
+func (p *FakeProvider) Script(replies ...string) {
+	for _, r := range replies {
+		p.ScriptResponse(LLMResponse{Content: r})
+	}
+}
+
+// ScriptResponse queues canned responses, e.g. ones with tool calls.
+func (p *FakeProvider) ScriptResponse(resps ...LLMResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.script = append(p.script, resps...)
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.Calls = append(p.Calls, req)
+	if len(p.Calls) > maxFakeCalls {
+		p.Calls = append(p.Calls[:0], p.Calls[len(p.Calls)-maxFakeCalls:]...)
+	}
+	if len(p.script) > 0 {
+		reply := p.script[0]
+		p.script = p.script[1:]
+		p.mu.Unlock()
+		return &reply, nil
+	}
+	p.mu.Unlock()
+
+	if n := len(req.Messages); n > 0 && len(req.Messages[n-1].Images) > 0 {
+		return &LLMResponse{Content: fakeVisionAnswer(req.Messages[n-1])}, nil
+	}
+
+	if len(req.Tools) > 0 && len(req.Messages) > 0 {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == "tool" {
+			return &LLMResponse{Content: fakeToolAnswer(req.Messages)}, nil
+		}
+		system, _ := splitMessages(req.Messages)
+		if call, ok := p.pickTool(last.Content, system, req.Tools); ok {
+			return &LLMResponse{ToolCalls: []ToolCall{call}}, nil
+		}
+	}
+
+	system, user := splitMessages(req.Messages)
+	if strings.Contains(strings.ToLower(system), "extract") {
+		return &LLMResponse{Content: p.extract(user)}, nil
+	}
+	return &LLMResponse{Content: fakeAnswer(user)}, nil
+}
+
+// ChatStream returns the same reply as Chat, one word at a time.
+func (p *FakeProvider) ChatStream(ctx context.Context, req LLMRequest, onToken TokenFunc) (*LLMResponse, error) {
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	words := strings.SplitAfter(resp.Content, " ")
+	for _, w := range words {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := onToken(w); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// extract returns the vocabulary labels mentioned in the user's question as a
+// JSON array. Only the "User question:" line is scanned, so example labels in
+// the prompt itself are ignored.
+func (p *FakeProvider) extract(text string) string {
+	if i := strings.Index(text, "User question:"); i >= 0 {
+		text = text[i+len("User question:"):]
+		if nl := strings.Index(text, "\n"); nl >= 0 {
+			text = text[:nl]
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	found := []string{}
+	seen := map[string]bool{}
+	for _, w := range words {
+		// "cars" -> "car", "buses" -> "bus"
+		forms := []string{w, strings.TrimSuffix(w, "s"), strings.TrimSuffix(w, "es")}
+		for _, v := range p.Vocab {
+			if containsFold(forms, v) && !seen[v] {
+				seen[v] = true
+				found = append(found, v)
+			}
+		}
+	}
+	out, _ := json.Marshal(found)
+	return string(out)
+}
+
+// fakeRangeHint matches the resolved time range chattools.go puts in the system prompt.
+var fakeRangeHint = regexp.MustCompile(`start=(\S+) and end=(\S+?),`)
+
+// pickTool chooses a tool from the wording of the question, with the first
+// known label and the time range from the system prompt as its arguments.
+func (p *FakeProvider) pickTool(question, system string, tools []Tool) (ToolCall, bool) {
+	q := strings.ToLower(question)
+	name := "search_detections"
+	switch {
+	case offersTool(tools, "semantic_search") && (strings.Contains(q, "deliver") || strings.Contains(q, "parcel") || strings.Contains(q, "someone")):
+		name = "semantic_search"
+	case strings.Contains(q, "visit") || strings.Contains(q, "how long"):
+		name = "get_visits"
+	case strings.Contains(q, "how many") || strings.Contains(q, "count"):
+		name = "count_detections"
+	case strings.Contains(q, "snapshot") || strings.Contains(q, "picture") || strings.Contains(q, "photo"):
+		name = "get_latest_snapshot"
+	}
+
+	if !offersTool(tools, name) {
+		return ToolCall{}, false
+	}
+
+	args := map[string]string{}
+	var labels []string
+	json.Unmarshal([]byte(p.extract(question)), &labels)
+	if name == "semantic_search" {
+		args["query"] = question
+	} else if len(labels) > 0 {
+		args["label"] = labels[0]
+	}
+	if m := fakeRangeHint.FindStringSubmatch(system); m != nil {
+		args["start"], args["end"] = m[1], m[2]
+	}
+	argsJSON, _ := json.Marshal(args)
+	return ToolCall{
+		ID:       "call_0",
+		Type:     "function",
+		Function: ToolCallFunction{Name: name, Arguments: string(argsJSON)},
+	}, true
+}
+
+// offersTool reports whether a tool called name is among tools.
+func offersTool(tools []Tool, name string) bool {
+	for _, t := range tools {
+		if t.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeToolAnswer echoes the tool results since the last user message.
+func fakeToolAnswer(msgs []ChatMessage) string {
+	var results []string
+	for i := len(msgs) - 1; i >= 0 && msgs[i].Role != "user"; i-- {
+		if msgs[i].Role == "tool" {
+			results = append([]string{msgs[i].Content}, results...)
+		}
+	}
+	return fmt.Sprintf("Based on the tools: %s", strings.Join(results, "; "))
+}
+
+// fakeVisionAnswer "looks at" the attached images: it reports each one's
+// size and cites the first, so tests can check resizing and citations.
+func fakeVisionAnswer(msg ChatMessage) string {
+	var seen []string
+	for i, img := range msg.Images {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(img))
+		if err != nil {
+			seen = append(seen, fmt.Sprintf("[%d] unreadable", i+1))
+			continue
+		}
+		seen = append(seen, fmt.Sprintf("[%d] %dx%d", i+1, cfg.Width, cfg.Height))
+	}
+	return fmt.Sprintf("I looked at %d snapshot(s): %s. Based on snapshot [1].", len(msg.Images), strings.Join(seen, ", "))
+}
+
+// fakeAnswer echoes the "- ..." context lines of the final prompt.
+func fakeAnswer(prompt string) string {
+	var facts []string
+	for _, line := range strings.Split(prompt, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "- ") {
+			facts = append(facts, strings.TrimPrefix(strings.TrimSpace(line), "- "))
+		}
+	}
+	if len(facts) == 0 {
+		return "I have no matching detections."
+	}
+	return fmt.Sprintf("Based on the detections: %s", strings.Join(facts, "; "))
+}
+
+// splitMessages returns the (last) system and user message contents.
+func splitMessages(msgs []ChatMessage) (system, user string) {
+	for _, m := range msgs {
+		switch m.Role {
+		case "system":
+			system = m.Content
+		case "user":
+			user = m.Content
+		}
+	}
+	return system, user
+}
+
+// fakeEmbeddingDims is the size of the fake embedding vectors.
+const fakeEmbeddingDims = 64
+
+// fakeConcepts maps words to the concepts the fake embedding is built from.
+// Words not listed here (and not labels) are ignored, as are stop words.
+var fakeConcepts = map[string][]string{
+	"someone": {"person"}, "somebody": {"person"}, "people": {"person"}, "man": {"person"},
+	"woman": {"person"}, "visitor": {"person"}, "courier": {"person", "delivery"},
+	"postman": {"person", "delivery"}, "truck": {"truck", "vehicle", "delivery"},
+	"van": {"truck", "vehicle", "delivery"}, "lorry": {"truck", "vehicle"},
+	"car": {"car", "vehicle"}, "bus": {"bus", "vehicle"}, "vehicle": {"vehicle"},
+	"parcel": {"package", "delivery"}, "package": {"package", "delivery"},
+	"delivery": {"delivery"}, "delivering": {"delivery"}, "delivered": {"delivery"},
+	"dog": {"dog", "animal"}, "cat": {"cat", "animal"}, "bird": {"bird", "animal"},
+	"pet": {"animal"}, "animal": {"animal"}, "bike": {"bicycle"},
+}
+
+// Embed returns one deterministic, unit-length vector per text.
+func (p *FakeProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, t := range texts {
+		vectors[i] = p.fakeEmbedding(t)
+	}
+	return vectors, nil
+}
+
+// fakeEmbedding hashes the concepts of each word into a bag-of-concepts vector.
+func (p *FakeProvider) fakeEmbedding(text string) []float32 {
+	v := make([]float32, fakeEmbeddingDims)
+	add := func(concept string) {
+		h := fnv.New32a()
+		h.Write([]byte(concept))
+		v[h.Sum32()%fakeEmbeddingDims]++
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z')
+	})
+	for _, w := range words {
+		if concepts, ok := fakeConcepts[w]; ok {
+			for _, c := range concepts {
+				add(c)
+			}
+			continue
+		}
+		for _, l := range p.Vocab {
+			if containsFold([]string{w, strings.TrimSuffix(w, "s"), strings.TrimSuffix(w, "es")}, l) {
+				add(l)
+			}
+		}
+	}
+
+	var norm float64
+	for _, f := range v {
+		norm += float64(f * f)
+	}
+	if norm > 0 {
+		for i := range v {
+			v[i] /= float32(math.Sqrt(norm))
+		}
+	}
+	return v
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// llamaCppChatRequest is llama.cpp's /v1/chat/completions body. It is
+// OpenAI-shaped, plus server-specific knobs.
+type llamaCppChatRequest struct {
+	Model    string        `json:"model,omitempty"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream,omitempty"`
+	// Tools needs llama-server started with --jinja.
+	Tools []Tool `json:"tools,omitempty"`
+	// CachePrompt reuses the KV cache for the shared prompt prefix,
+	// which matters a lot on a Pi where prompt processing dominates.
+	CachePrompt bool `json:"cache_prompt"`
+}
+
+// LlamaCppProvider talks to a llama.cpp server (`llama-server -m model.gguf`).
+// The server hosts a single model, so Model is only sent when configured.
+type LlamaCppProvider struct {
+	cfg          LLMConfig
+	client       *http.Client
+	streamClient *http.Client
+}
+
+// NewLlamaCppProvider creates a provider for cfg.BaseURL (e.g. http://localhost:8081).
+func NewLlamaCppProvider(cfg LLMConfig) *LlamaCppProvider {
+	client, streamClient := llmClients(cfg)
+	return &LlamaCppProvider{cfg: cfg, client: client, streamClient: streamClient}
+}
+
+func (p *LlamaCppProvider) Name() string {
+	return fmt.Sprintf("llamacpp(%s)", p.cfg.BaseURL)
+}
+
+func (p *LlamaCppProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	body := llamaCppChatRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		Tools:       req.Tools,
+		CachePrompt: true,
+	}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/v1/chat/completions"
+
+	var resp ChatResponse
+	err := withRetries(ctx, p.Name(), p.cfg.MaxRetries, time.Duration(p.cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		resp = ChatResponse{}
+		return postJSON(ctx, p.client, url, p.cfg.APIKey, body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.answer(p.Name())
+}
+
+func (p *LlamaCppProvider) ChatStream(ctx context.Context, req LLMRequest, onToken TokenFunc) (*LLMResponse, error) {
+	body := llamaCppChatRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		Stream:      true,
+		CachePrompt: true,
+	}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/v1/chat/completions"
+	return streamOpenAI(ctx, p.Name(), p.cfg, p.streamClient, url, body, onToken)
+}
+
+// Embed implements Embedder (see embeddings.go). Needs llama-server started
+// with --embeddings, usually as a second server with an embedding model.
+func (p *LlamaCppProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/v1/embeddings"
+	return embedOpenAI(ctx, p.Name(), p.cfg, p.client, url, embeddingRequest{Input: texts})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// === Wire types for Ollama native /api/chat ===
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Tools    []Tool          `json:"tools,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+}
+
+// ollamaMessage differs from ChatMessage only in tool calls: Ollama sends
+// and expects the arguments as a JSON object, not a JSON string.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    [][]byte         `json:"images,omitempty"` // base64 in JSON, as Ollama expects
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// toOllamaMessages converts provider-independent messages to Ollama's shape.
+func toOllamaMessages(msgs []ChatMessage) []ollamaMessage {
+	out := make([]ollamaMessage, 0, len(msgs))
+	for _, m := range msgs {
+		om := ollamaMessage{Role: m.Role, Content: m.Content, Images: m.Images}
+		for _, tc := range m.ToolCalls {
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = json.RawMessage(firstNonEmpty(tc.Function.Arguments, "{}"))
+			om.ToolCalls = append(om.ToolCalls, call)
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+// toolCalls converts Ollama's tool calls back. Ollama has no call IDs.
+func (m ollamaMessage) toolCalls() []ToolCall {
+	var calls []ToolCall
+	for i, tc := range m.ToolCalls {
+		calls = append(calls, ToolCall{
+			ID:   fmt.Sprintf("call_%d", i),
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      tc.Function.Name,
+				Arguments: string(tc.Function.Arguments),
+			},
+		})
+	}
+	return calls
+}
+
+// OllamaProvider talks to Ollama's native /api/chat endpoint.
+type OllamaProvider struct {
+	cfg          LLMConfig
+	client       *http.Client
+	streamClient *http.Client
+}
+
+// NewOllamaProvider creates a provider for cfg.BaseURL (e.g. http://localhost:11434).
+func NewOllamaProvider(cfg LLMConfig) *OllamaProvider {
+	client, streamClient := llmClients(cfg)
+	return &OllamaProvider{cfg: cfg, client: client, streamClient: streamClient}
+}
+
+func (p *OllamaProvider) Name() string {
+	return fmt.Sprintf("ollama(%s)", p.cfg.Model)
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	body := ollamaChatRequest{
+		Model:    firstNonEmpty(req.Model, p.cfg.Model),
+		Messages: toOllamaMessages(req.Messages),
+		Stream:   false,
+		Tools:    req.Tools,
+	}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/api/chat"
+
+	var resp ollamaChatResponse
+	err := withRetries(ctx, p.Name(), p.cfg.MaxRetries, time.Duration(p.cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		resp = ollamaChatResponse{}
+		return postJSON(ctx, p.client, url, p.cfg.APIKey, body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LLMResponse{Content: resp.Message.Content, ToolCalls: resp.Message.toolCalls()}, nil
+}
+
+// ChatStream reads Ollama's NDJSON stream: one JSON object per line, the last with done=true.
+func (p *OllamaProvider) ChatStream(ctx context.Context, req LLMRequest, onToken TokenFunc) (*LLMResponse, error) {
+	body := ollamaChatRequest{
+		Model:    firstNonEmpty(req.Model, p.cfg.Model),
+		Messages: toOllamaMessages(req.Messages),
+		Stream:   true,
+	}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/api/chat"
+
+	var full strings.Builder
+	err := withRetries(ctx, p.Name(), p.cfg.MaxRetries, time.Duration(p.cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		full.Reset()
+		return streamLines(ctx, p.streamClient, url, p.cfg.APIKey, body, func(line string) (bool, error) {
+			var chunk ollamaChatResponse
+			if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+				return false, fmt.Errorf("bad stream chunk: %w", err)
+			}
+			if chunk.Message.Content != "" {
+				full.WriteString(chunk.Message.Content)
+				if err := onToken(chunk.Message.Content); err != nil {
+					return false, err
+				}
+			}
+			return chunk.Done, nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LLMResponse{Content: full.String()}, nil
+}
+
+// === Embeddings: Ollama native /api/embed ===
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// Embed implements Embedder (see embeddings.go).
+func (p *OllamaProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body := ollamaEmbedRequest{Model: p.cfg.Model, Input: texts}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/api/embed"
+
+	var resp ollamaEmbedResponse
+	err := withRetries(ctx, p.Name(), p.cfg.MaxRetries, time.Duration(p.cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		resp = ollamaEmbedResponse{}
+		return postJSON(ctx, p.client, url, p.cfg.APIKey, body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Embeddings, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// === Wire types for OpenAI-compatible /v1/chat/completions ===
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream,omitempty"`
+	Tools    []Tool        `json:"tools,omitempty"`
+}
+
+type ChatChoice struct {
+	Message ChatMessage `json:"message"`
+}
+
+type ChatResponse struct {
+	Choices []ChatChoice `json:"choices"`
+}
+
+// chatStreamChunk is one "data: {...}" event of a streamed completion.
+type chatStreamChunk struct {
+	Choices []struct {
+		Delta ChatMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+// OpenAIProvider talks to any OpenAI-compatible chat completions API.
+// Ollama serves one at http://localhost:11434/v1.
+type OpenAIProvider struct {
+	cfg          LLMConfig
+	client       *http.Client
+	streamClient *http.Client
+}
+
+// NewOpenAIProvider creates a provider for cfg.BaseURL (e.g. http://localhost:11434/v1).
+func NewOpenAIProvider(cfg LLMConfig) *OpenAIProvider {
+	client, streamClient := llmClients(cfg)
+	return &OpenAIProvider{cfg: cfg, client: client, streamClient: streamClient}
+}
+
+func (p *OpenAIProvider) Name() string {
+	return fmt.Sprintf("openai(%s)", p.cfg.Model)
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	body := ChatRequest{
+		Model:    firstNonEmpty(req.Model, p.cfg.Model),
+		Messages: req.Messages,
+		Tools:    req.Tools,
+	}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/chat/completions"
+
+	var resp ChatResponse
+	err := withRetries(ctx, p.Name(), p.cfg.MaxRetries, time.Duration(p.cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		resp = ChatResponse{}
+		return postJSON(ctx, p.client, url, p.cfg.APIKey, body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.answer(p.Name())
+}
+
+// answer turns a completion into an LLMResponse. Shared with llama.cpp.
+func (r *ChatResponse) answer(name string) (*LLMResponse, error) {
+	if len(r.Choices) == 0 {
+		return nil, fmt.Errorf("%s: response has no choices", name)
+	}
+	msg := r.Choices[0].Message
+	return &LLMResponse{Content: msg.Content, ToolCalls: msg.ToolCalls}, nil
+}
+
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req LLMRequest, onToken TokenFunc) (*LLMResponse, error) {
+	body := ChatRequest{
+		Model:    firstNonEmpty(req.Model, p.cfg.Model),
+		Messages: req.Messages,
+		Stream:   true,
+	}
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/chat/completions"
+	return streamOpenAI(ctx, p.Name(), p.cfg, p.streamClient, url, body, onToken)
+}
+
+// streamOpenAI reads an OpenAI-style SSE completion stream. Shared with llama.cpp.
+func streamOpenAI(ctx context.Context, name string, cfg LLMConfig, client *http.Client, url string, body interface{}, onToken TokenFunc) (*LLMResponse, error) {
+	var full strings.Builder
+	err := withRetries(ctx, name, cfg.MaxRetries, time.Duration(cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		full.Reset()
+		return streamLines(ctx, client, url, cfg.APIKey, body, func(line string) (bool, error) {
+			data, ok := strings.CutPrefix(line, "data:")
+			if !ok {
+				return false, nil // SSE comments / event names
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				return true, nil
+			}
+
+			var chunk chatStreamChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return false, fmt.Errorf("bad stream chunk: %w", err)
+			}
+			if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+				return false, nil
+			}
+			token := chunk.Choices[0].Delta.Content
+			full.WriteString(token)
+			return false, onToken(token)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LLMResponse{Content: full.String()}, nil
+}
+
+// firstNonEmpty returns the first non-empty string.
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// === Embeddings: OpenAI-compatible /v1/embeddings (also llama.cpp) ===
+type embeddingRequest struct {
+	Model string   `json:"model,omitempty"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed implements Embedder (see embeddings.go).
+func (p *OpenAIProvider) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	url := strings.TrimRight(p.cfg.BaseURL, "/") + "/embeddings"
+	return embedOpenAI(ctx, p.Name(), p.cfg, p.client, url, embeddingRequest{Model: p.cfg.Model, Input: texts})
+}
+
+// embedOpenAI posts an embeddings request and returns the vectors in input
+// order. Shared with llama.cpp.
+func embedOpenAI(ctx context.Context, name string, cfg LLMConfig, client *http.Client, url string, body embeddingRequest) ([][]float32, error) {
+	var resp embeddingResponse
+	err := withRetries(ctx, name, cfg.MaxRetries, time.Duration(cfg.RetryBackoffMs)*time.Millisecond, func() error {
+		resp = embeddingResponse{}
+		return postJSON(ctx, client, url, cfg.APIKey, body, &resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(body.Input))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("%s: embedding index %d out of range", name, d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("%s: no embedding for input %d", name, i)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// llmServer answers each request with the next of replies (the last one
+// repeats) and keeps what it received.
+type llmServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []llmServerRequest
+}
+
+type llmServerRequest struct {
+	path, auth string
+	body       map[string]interface{}
+}
+
+// llmReply is one canned response: a status and a body, written line by
+// line with a flush after each so streams arrive as they would.
+type llmReply struct {
+	status int
+	lines  []string
+}
+
+func newLLMServer(t *testing.T, replies ...llmReply) *llmServer {
+	t.Helper()
+	s := &llmServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		req := llmServerRequest{path: r.URL.Path, auth: r.Header.Get("Authorization")}
+		json.Unmarshal(raw, &req.body)
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		reply := replies[min(len(s.requests), len(replies))-1]
+		s.mu.Unlock()
+
+		w.WriteHeader(reply.status)
+		for _, l := range reply.lines {
+			io.WriteString(w, l+"\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *llmServer) calls() []llmServerRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]llmServerRequest{}, s.requests...)
+}
+
+func newTestProvider(t *testing.T, provider, baseURL string, maxRetries int) LLMProvider {
+	t.Helper()
+	p, err := newLLMProvider(LLMConfig{Provider: provider, BaseURL: baseURL, Model: "llama3", APIKey: "k", TimeoutSeconds: 5, MaxRetries: maxRetries, RetryBackoffMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const (
+	openAIReply = `{"choices":[{"message":{"role":"assistant","content":"hi"}}]}`
+	ollamaReply = `{"message":{"role":"assistant","content":"hi"},"done":true}`
+)
+
+func TestLLMRequestShape(t *testing.T) {
+	snapshot := []byte{0xff, 0xd8, 0xff, 0xd9}
+	tests := []struct {
+		provider, base string // base is appended to the server URL
+		reply          string
+		path           string
+		model          interface{} // nil = not sent
+		extra          map[string]interface{}
+		image          func(msg map[string]interface{}) bool
+	}{
+		{
+			provider: "openai", base: "/v1/", reply: openAIReply, path: "/v1/chat/completions", model: "llama3",
+			image: func(msg map[string]interface{}) bool {
+				parts, _ := msg["content"].([]interface{})
+				if len(parts) != 2 {
+					return false
+				}
+				url, _ := parts[1].(map[string]interface{})["image_url"].(map[string]interface{})
+				return url["url"] == "data:image/jpeg;base64,/9j/2Q=="
+			},
+		},
+		{
+			provider: "ollama", reply: ollamaReply, path: "/api/chat", model: "llama3", extra: map[string]interface{}{"stream": false},
+			image: func(msg map[string]interface{}) bool {
+				images, _ := msg["images"].([]interface{})
+				return msg["content"] == "what colour?" && len(images) == 1 && images[0] == "/9j/2Q=="
+			},
+		},
+		{
+			provider: "llamacpp", reply: openAIReply, path: "/v1/chat/completions", model: nil, extra: map[string]interface{}{"cache_prompt": true},
+			image: func(msg map[string]interface{}) bool {
+				parts, _ := msg["content"].([]interface{})
+				return len(parts) == 2
+			},
+		},
+	}
+	for _, tt := range tests {
+		srv := newLLMServer(t, llmReply{200, []string{tt.reply}})
+		p := newTestProvider(t, tt.provider, srv.URL+tt.base, 0)
+		resp, err := p.Chat(context.Background(), LLMRequest{Messages: []ChatMessage{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "what colour?", Images: [][]byte{snapshot}},
+		}})
+		if err != nil || resp.Content != "hi" {
+			t.Fatalf("%s: Chat = %+v, %v", tt.provider, resp, err)
+		}
+		req := srv.calls()[0]
+		if req.path != tt.path || req.auth != "Bearer k" {
+			t.Errorf("%s: POST %s with Authorization %q, want %s with Bearer k", tt.provider, req.path, req.auth, tt.path)
+		}
+		if req.body["model"] != tt.model {
+			t.Errorf("%s: model %v, want %v", tt.provider, req.body["model"], tt.model)
+		}
+		for k, want := range tt.extra {
+			if req.body[k] != want {
+				t.Errorf("%s: %s = %v, want %v", tt.provider, k, req.body[k], want)
+			}
+		}
+		msgs, _ := req.body["messages"].([]interface{})
+		if len(msgs) != 2 || msgs[0].(map[string]interface{})["content"] != "be brief" {
+			t.Fatalf("%s: messages %v", tt.provider, req.body["messages"])
+		}
+		if !tt.image(msgs[1].(map[string]interface{})) {
+			t.Errorf("%s: image message sent as %v", tt.provider, msgs[1])
+		}
+
+		// A per-call model overrides the configured one.
+		p.Chat(context.Background(), LLMRequest{Model: "llava", Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+		if got := srv.calls()[1].body["model"]; got != "llava" {
+			t.Errorf("%s: model override sent %v, want llava", tt.provider, got)
+		}
+	}
+}
+
+func TestLLMEmbedRequestShape(t *testing.T) {
+	tests := []struct {
+		provider, base, reply, path string
+	}{
+		{"openai", "/v1", `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`, "/v1/embeddings"},
+		{"ollama", "", `{"embeddings":[[1,0],[0,1]]}`, "/api/embed"},
+		{"llamacpp", "", `{"data":[{"index":0,"embedding":[1,0]},{"index":1,"embedding":[0,1]}]}`, "/v1/embeddings"},
+	}
+	for _, tt := range tests {
+		srv := newLLMServer(t, llmReply{200, []string{tt.reply}})
+		p := newTestProvider(t, tt.provider, srv.URL+tt.base, 0).(Embedder)
+		vectors, err := p.Embed(context.Background(), []string{"a", "b"})
+		if err != nil || len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+			t.Errorf("%s: Embed = %v, %v; want the vectors in input order", tt.provider, vectors, err)
+		}
+		req := srv.calls()[0]
+		if req.path != tt.path || req.auth != "Bearer k" {
+			t.Errorf("%s: POST %s with Authorization %q, want %s", tt.provider, req.path, req.auth, tt.path)
+		}
+		if input, _ := req.body["input"].([]interface{}); len(input) != 2 {
+			t.Errorf("%s: input %v", tt.provider, req.body["input"])
+		}
+	}
+}
+
+func TestLLMRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		calls    int
+		status   int // of the returned error, 0 = success
+	}{
+		{"5xx then ok", []int{503, 200}, 2, 0},
+		{"429 then ok", []int{429, 200}, 2, 0},
+		{"4xx is not retried", []int{400, 200}, 1, 400},
+		{"401 is not retried", []int{401, 200}, 1, 401},
+		{"gives up after max_retries", []int{500, 500, 500, 200}, 3, 500},
+	}
+	replies := map[string]string{"openai": openAIReply, "ollama": ollamaReply, "llamacpp": openAIReply}
+	for provider, ok := range replies {
+		for _, tt := range tests {
+			var rs []llmReply
+			for _, s := range tt.statuses {
+				body := ok
+				if s != 200 {
+					body = `{"error":"nope"}`
+				}
+				rs = append(rs, llmReply{s, []string{body}})
+			}
+			srv := newLLMServer(t, rs...)
+			_, err := newTestProvider(t, provider, srv.URL, 2).Chat(context.Background(), LLMRequest{Messages: []ChatMessage{{Role: "user", Content: "hi"}}})
+			if n := len(srv.calls()); n != tt.calls {
+				t.Errorf("%s, %s: %d calls, want %d", provider, tt.name, n, tt.calls)
+			}
+			var se *httpStatusError
+			switch {
+			case tt.status == 0 && err != nil:
+				t.Errorf("%s, %s: %v", provider, tt.name, err)
+			case tt.status != 0 && (!errors.As(err, &se) || se.StatusCode != tt.status):
+				t.Errorf("%s, %s: error %v, want status %d", provider, tt.name, err, tt.status)
+			}
+		}
+	}
+}
+
+func TestLLMStream(t *testing.T) {
+	sse := func(token string) string { return `data: {"choices":[{"delta":{"content":"` + token + `"}}]}` }
+	ndjson := func(token string) string {
+		return `{"message":{"role":"assistant","content":"` + token + `"},"done":false}`
+	}
+	tests := []struct {
+		name, provider string
+		replies        []llmReply
+		calls          int
+		tokens         string // as received, joined with |
+		err            bool
+	}{
+		{"sse", "openai", []llmReply{{200, []string{
+			": keep-alive", "event: message", sse("The"), "", sse(" car"), `data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+			sse(" was red."), "data: [DONE]", sse("ignored"),
+		}}}, 1, "The| car| was red.", false},
+		{"sse without [DONE]", "llamacpp", []llmReply{{200, []string{sse("a"), sse("b")}}}, 1, "a|b", false},
+		{"ndjson", "ollama", []llmReply{{200, []string{
+			ndjson("The"), ndjson(" car"), `{"message":{"role":"assistant","content":""},"done":true}`, ndjson("ignored"),
+		}}}, 1, "The| car", false},
+		{"sse retried before any token", "openai", []llmReply{{503, []string{"busy"}}, {200, []string{sse("ok"), "data: [DONE]"}}}, 2, "ok", false},
+		{"ndjson retried before any token", "ollama", []llmReply{{429, []string{"slow down"}}, {200, []string{ndjson("ok"), `{"done":true}`}}}, 2, "ok", false},
+		{"sse not retried after a token", "openai", []llmReply{{200, []string{sse("The"), "data: {broken"}}, {200, []string{sse("again")}}}, 1, "The", true},
+		{"ndjson not retried after a token", "ollama", []llmReply{{200, []string{ndjson("The"), "{broken"}}, {200, []string{ndjson("again")}}}, 1, "The", true},
+	}
+	for _, tt := range tests {
+		srv := newLLMServer(t, tt.replies...)
+		p := newTestProvider(t, tt.provider, srv.URL, 2)
+		var tokens []string
+		resp, err := p.ChatStream(context.Background(), LLMRequest{Messages: []ChatMessage{{Role: "user", Content: "hi"}}}, func(tok string) error {
+			tokens = append(tokens, tok)
+			return nil
+		})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v, want error = %v", tt.name, err, tt.err)
+		}
+		if got := strings.Join(tokens, "|"); got != tt.tokens {
+			t.Errorf("%s: tokens %q, want %q", tt.name, got, tt.tokens)
+		}
+		if err == nil && resp.Content != strings.ReplaceAll(tt.tokens, "|", "") {
+			t.Errorf("%s: content %q", tt.name, resp.Content)
+		}
+		calls := srv.calls()
+		if len(calls) != tt.calls {
+			t.Errorf("%s: %d calls, want %d", tt.name, len(calls), tt.calls)
+		}
+		if len(calls) > 0 && calls[0].body["stream"] != true {
+			t.Errorf("%s: stream = %v, want true", tt.name, calls[0].body["stream"])
+		}
+	}
+
+	// A client that goes away stops the stream without a retry.
+	srv := newLLMServer(t, llmReply{200, []string{sse("The"), sse(" car")}})
+	stop := errors.New("client gone")
+	_, err := newTestProvider(t, "openai", srv.URL, 2).ChatStream(context.Background(), LLMRequest{}, func(string) error { return stop })
+	if !errors.Is(err, stop) || len(srv.calls()) != 1 {
+		t.Errorf("aborted stream: error %v after %d calls, want %v after 1", err, len(srv.calls()), stop)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 )
 
 /*
@@ -22,11 +24,97 @@ Responsibilities:
 func main() {
 	config := loadConfig() // ✅ Load YAML once
 
+	// `./backend eval -suite ...` scores chat on a scratch DB (eval.go)
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(runEval(config, os.Args[2:]))
+	}
+
 	// Init DB once
 	initDB()
 
+	// Snapshot storage (local dir or S3/MinIO)
+	snapshots, err := newSnapshotStore(config.Snapshots)
+	if err != nil {
+		log.Fatalf("Failed to init snapshot store: %v", err)
+	}
+
+	// LLM provider for /chat (OpenAI-compatible, Ollama, llama.cpp or fake)
+	llm, err := newLLMProvider(config.LLM)
+	if err != nil {
+		log.Fatalf("Failed to init LLM provider: %v", err)
+	}
+	fmt.Printf("[Go Backend] LLM provider: %s\n", llm.Name())
+
+	// Embeddings for semantic search (optional, same provider layer)
+	embedder, err := newEmbedder(config)
+	if err != nil {
+		log.Fatalf("Failed to init embeddings: %v", err)
+	}
+
 	// Create your app instance
-	app := NewApp(db, &config)
+	app := NewApp(db, &config, snapshots, llm)
+	app.Embedder = embedder
+
+	// Notification channels (digests, ...)
+	app.Notifiers, err = newNotifiers(config.Notifications, app.Snapshots, app.Loc)
+	if err != nil {
+		log.Fatalf("Failed to init notifications: %v", err)
+	}
+	for _, name := range config.Digests.Notify {
+		if _, ok := app.Notifiers[name]; !ok {
+			log.Fatalf("digests.notify: no notification channel named %q", name)
+		}
+	}
+
+	// One-time jobs: `./backend migrate-snapshots`
+	if len(os.Args) > 1 && os.Args[1] == "migrate-snapshots" {
+		if err := app.migrateSnapshotLayout(context.Background()); err != nil {
+			log.Fatalf("Snapshot migration failed: %v", err)
+		}
+		return
+	}
+
+	// Rules from config.yaml (more can be added through /rules)
+	for i := range config.Rules {
+		if err := validateRule(&config.Rules[i]); err != nil {
+			log.Fatalf("Invalid rule: %v", err)
+		}
+	}
+
+	// Zones from config.yaml (more can be added through /zones) and tripwires
+	for _, cam := range config.Cameras {
+		for i := range cam.Zones {
+			if err := validateZone(&cam.Zones[i]); err != nil {
+				log.Fatalf("Invalid zone on camera %s: %v", cam.ID, err)
+			}
+		}
+		for i := range cam.Lines {
+			if err := validateTripwire(&cam.Lines[i]); err != nil {
+				log.Fatalf("Invalid line on camera %s: %v", cam.ID, err)
+			}
+		}
+	}
+	for i := range config.Dwell.Alerts {
+		if err := validateDwellAlert(&config.Dwell.Alerts[i]); err != nil {
+			log.Fatalf("Invalid dwell alert: %v", err)
+		}
+	}
+	if len(config.Dwell.Alerts) > 0 && config.Subscriber.Deduplicate && config.Subscriber.ThrottleN == 0 {
+		log.Printf("[Dwell] Warning: dwell alerts need subscriber.throttle_n > 0 with deduplicate on; " +
+			"a stationary object is stored once and never reaches min_seconds")
+	}
+	for i := range config.Webhooks.Endpoints {
+		if err := validateWebhook(&config.Webhooks.Endpoints[i]); err != nil {
+			log.Fatalf("Invalid webhook: %v", err)
+		}
+	}
+	for _, r := range config.Rules {
+		if err := app.checkRuleTargets(r); err != nil {
+			log.Fatalf("Invalid rule: %v", err)
+		}
+	}
+	app.MQTT = newMQTTClient(config.MQTT)
+	app.startHomeAssistant()
 
 	// Start background jobs
 	fmt.Println("[Go Backend] Starting ZeroMQ subscriber...")
@@ -35,6 +123,19 @@ func main() {
 	fmt.Println("[Go Backend] Starting retention job...")
 	go app.runRetention()
 
+	fmt.Println("[Go Backend] Starting webhook delivery...")
+	go app.runWebhookDelivery()
+
+	if app.Embedder != nil {
+		fmt.Printf("[Go Backend] Starting embedding indexer (%s)...\n", app.Embedder.Name())
+		go app.runEmbeddingIndexer()
+	}
+
+	if config.Digests.Enabled {
+		fmt.Println("[Go Backend] Starting digest job...")
+		go app.runDigests()
+	}
+
 	// Use your own ServeMux
 	mux := http.NewServeMux()
 
@@ -46,13 +147,32 @@ func main() {
 	// API endpoints use the App methods
 	mux.HandleFunc("/timeline", app.handleTimeline)
 	mux.HandleFunc("/cameras", app.camerasHandler)
-	mux.HandleFunc("/snapshot", handleSnapshot)
+	mux.HandleFunc("/snapshot", app.handleSnapshot)
 	mux.HandleFunc("/latest", app.handleLatest)
 	mux.HandleFunc("/chat", app.handleChat)
+	mux.HandleFunc("/chat/stream", app.handleChatStream)
+	mux.HandleFunc("/holds", app.handleHolds)
+	mux.HandleFunc("/conversations", app.handleConversations)
+	mux.HandleFunc("/conversations/", app.handleConversation)
+	mux.HandleFunc("/search", app.handleSearch)
+	mux.HandleFunc("/admin/prompts/preview", app.handlePromptPreview)
+	mux.HandleFunc("/admin/chat/cache", app.handleChatCache)
+	mux.HandleFunc("/digests", app.handleDigests)
+	mux.HandleFunc("/rules", app.handleRules)
+	mux.HandleFunc("/rules/test", app.handleRuleTest)
+	mux.HandleFunc("/rules/firings", app.handleRuleFirings)
+	mux.HandleFunc("/zones", app.handleZones)
+	mux.HandleFunc("/visits", app.handleVisits)
+	mux.HandleFunc("/crossings", app.handleCrossings)
+	mux.HandleFunc("/counts", app.handleCounts)
+	mux.HandleFunc("/counts/occupancy", app.handleOccupancy)
+	mux.HandleFunc("/webhooks", app.handleWebhooks)
+	mux.HandleFunc("/webhooks/deliveries", app.handleWebhookDeliveries)
+	mux.HandleFunc("/webhooks/deliveries/replay", app.handleWebhookReplay)
 
 	// Static file servers
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
-	mux.Handle("/snapshots/", http.StripPrefix("/snapshots/", http.FileServer(http.Dir("./snapshots"))))
+	mux.Handle("/snapshots/", http.StripPrefix("/snapshots/", http.HandlerFunc(app.handleSnapshotFile)))
 
 	// Start server with your mux
 	fmt.Println("[Go Backend] HTTP server running on :8080")
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+/*
+mqtt.go
+-------
+
+Connection to an MQTT broker (Mosquitto, the Home Assistant add-on, ...),
+used by rule actions (rules.go) and the Home Assistant integration
+(publisher.go):
+
+	mqtt:
+	  broker: tcp://localhost:1883
+	  username: ""
+	  password: ""
+	  topic_prefix: chatcam      # topics are <topic_prefix>/...
+
+The client reconnects on its own; a publish while the broker is down
+fails after mqttPublishTimeout and is logged by the caller. The retained
+<topic_prefix>/status topic is "online" while connected and, through the
+broker's last will, "offline" once the backend is gone.
+*/
+
+// mqttPublishTimeout bounds how long a publish waits for the broker.
+const mqttPublishTimeout = 5 * time.Second
+
+// MQTTClient publishes to the configured broker.
+type MQTTClient struct {
+	client mqtt.Client
+	prefix string
+
+	mu        sync.Mutex
+	onConnect []func()
+}
+
+// newMQTTClient connects to mqtt.broker, or returns nil when none is set.
+// The first connection is retried in the background, so a broker that is
+// down at start-up doesn't stop the backend.
+func newMQTTClient(cfg MQTTConfig) *MQTTClient {
+	if cfg.Broker == "" {
+		return nil
+	}
+	clientID := cfg.ClientID
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = "chat-with-my-camera-" + host
+	}
+	c := &MQTTClient{prefix: strings.Trim(cfg.TopicPrefix, "/")}
+	if c.prefix == "" {
+		c.prefix = "chatcam"
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetWill(c.Topic("status"), "offline", 1, true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			log.Printf("[MQTT] Connected to %s", cfg.Broker)
+			go c.connected()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("[MQTT] Connection to %s lost: %v", cfg.Broker, err)
+		})
+
+	c.client = mqtt.NewClient(opts)
+	c.client.Connect() // keeps retrying; publishes wait for it
+	return c
+}
+
+// Topic returns <topic_prefix>/<parts...>.
+func (c *MQTTClient) Topic(parts ...string) string {
+	return c.prefix + "/" + strings.Join(parts, "/")
+}
+
+// Publish sends payload to topic (QoS 1).
+func (c *MQTTClient) Publish(topic string, payload []byte, retain bool) error {
+	token := c.client.Publish(topic, 1, retain, payload)
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("publish to %s timed out", topic)
+	}
+	return token.Error()
+}
+
+// OnConnect runs f after every (re)connection, and right away if already
+// connected. Subscriptions don't survive a reconnect, so make them in f.
+func (c *MQTTClient) OnConnect(f func()) {
+	c.mu.Lock()
+	c.onConnect = append(c.onConnect, f)
+	c.mu.Unlock()
+	// Not IsConnected: with ConnectRetry that is already true while the
+	// first connection is still being retried.
+	if c.client.IsConnectionOpen() {
+		go f()
+	}
+}
+
+// Subscribe calls handler with the payload of every message on topic.
+func (c *MQTTClient) Subscribe(topic string, handler func(payload []byte)) error {
+	token := c.client.Subscribe(topic, 1, func(_ mqtt.Client, m mqtt.Message) {
+		handler(m.Payload())
+	})
+	if !token.WaitTimeout(mqttPublishTimeout) {
+		return fmt.Errorf("subscribe to %s timed out", topic)
+	}
+	return token.Error()
+}
+
+// connected marks the backend online and runs the OnConnect callbacks.
+func (c *MQTTClient) connected() {
+	if err := c.Publish(c.Topic("status"), []byte("online"), true); err != nil {
+		log.Printf("[MQTT] Failed to publish status: %v", err)
+	}
+	c.mu.Lock()
+	callbacks := append([]func(){}, c.onConnect...)
+	c.mu.Unlock()
+	for _, f := range callbacks {
+		f()
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/*
+notify.go
+---------
+
+Notification channels push short messages to people. Channels are named
+under notifications: in config.yaml, and features that send messages
+refer to them by name (rules: {type: notify, channels: [...]}, digests:
+notify: [...]):
+
+	notifications:
+	  public_url: http://192.168.1.20:8080   # how phones reach the backend, for links
+	  channels:
+	    - name: household
+	      type: log        # writes the message to the backend log
+	    - name: me
+	      type: email      # SMTP, snapshot attached (notify_email.go)
+	      smtp_host: smtp.example.com
+	      username: cam@example.com
+	      password: ...
+	      from: cam@example.com
+	      to: [me@example.com]
+	    - name: phone
+	      type: ntfy       # snapshot linked (notify_ntfy.go)
+	      server: https://ntfy.sh
+	      topic: my-cameras
+	    - name: tablet
+	      type: gotify     # snapshot linked (notify_gotify.go)
+	      server: http://gotify.local
+	      token: A1b2C3
+
+A channel is one recipient, so each has its own:
+
+	      quiet_hours: {from: "22:00", to: "07:00"}   # local time; optional days: [...]
+	      rate_limit: {max: 5, per_seconds: 600}     # more are dropped
+	      title_template: "{{.Camera}}: {{join .Labels \", \"}}"
+	      body_template: "{{.Body}} {{.URL}}"
+
+Messages in quiet hours or over the rate limit are dropped and logged.
+Templates are Go text/templates over the Notification (.Title, .Body,
+.URL, .Camera, .Labels, .Time) and replace the default title and body.
+A channel that fails is logged and skipped; the others still get the
+message.
+*/
+
+// Notification is one message for a channel.
+type Notification struct {
+	Title    string
+	Body     string
+	URL      string // link back to the backend, e.g. /digests?id=3 (optional)
+	Snapshot string // snapshot key to attach or link (optional)
+
+	// For message templates (optional)
+	Camera string   // camera display name
+	Labels []string // detected labels
+	Time   time.Time
+}
+
+// Notifier delivers notifications over one channel.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+var (
+	errQuietHours  = errors.New("quiet hours")
+	errRateLimited = errors.New("rate limit reached")
+)
+
+// notifyHTTPClient sends ntfy and Gotify pushes.
+var notifyHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// newNotifiers builds the configured channels, keyed by name. Snapshots
+// are read from snapshots for email attachments, and quiet hours are in loc.
+func newNotifiers(cfg NotificationsConfig, snapshots SnapshotStore, loc *time.Location) (map[string]Notifier, error) {
+	notifiers := map[string]Notifier{}
+	for _, ch := range cfg.Channels {
+		if ch.Name == "" {
+			return nil, fmt.Errorf("notification channel without a name")
+		}
+		if _, dup := notifiers[ch.Name]; dup {
+			return nil, fmt.Errorf("notification channel %q defined twice", ch.Name)
+		}
+		var n Notifier
+		var err error
+		switch ch.Type {
+		case "log", "":
+			n = logNotifier{name: ch.Name}
+		case "email":
+			n, err = newEmailNotifier(ch, snapshots, cfg.PublicURL)
+		case "ntfy":
+			n, err = newNtfyNotifier(ch, cfg.PublicURL)
+		case "gotify":
+			n, err = newGotifyNotifier(ch, cfg.PublicURL)
+		default:
+			return nil, fmt.Errorf("notification channel %q: unknown type %q", ch.Name, ch.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("notification channel %q: %w", ch.Name, err)
+		}
+		if n, err = withPolicy(n, ch, loc); err != nil {
+			return nil, fmt.Errorf("notification channel %q: %w", ch.Name, err)
+		}
+		notifiers[ch.Name] = n
+	}
+	return notifiers, nil
+}
+
+// notify sends n to the named channels and returns what went wrong, per
+// channel; messages dropped by quiet hours or a rate limit count too, so a
+// rule firing records them.
+func (app *App) notify(ctx context.Context, channels []string, n Notification) error {
+	var errs []error
+	for _, name := range channels {
+		ch, ok := app.Notifiers[name]
+		if !ok {
+			log.Printf("[Notify] Unknown channel %q", name)
+			errs = append(errs, fmt.Errorf("%s: unknown channel", name))
+			continue
+		}
+		err := ch.Notify(ctx, n)
+		switch {
+		case errors.Is(err, errQuietHours), errors.Is(err, errRateLimited):
+			log.Printf("[Notify] %s: dropped %q (%v)", name, n.Title, err)
+		case err != nil:
+			log.Printf("[Notify] %s failed: %v", name, err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// policyNotifier applies a channel's quiet hours, rate limit and message
+// templates before handing a notification to the channel.
+type policyNotifier struct {
+	Notifier
+	quiet       *RuleSchedule
+	max         int
+	window      time.Duration
+	title, body *template.Template
+	loc         *time.Location
+	now         func() time.Time
+
+	mu   sync.Mutex
+	sent []time.Time // within the rate-limit window
+}
+
+var notifyTemplateFuncs = template.FuncMap{"join": strings.Join}
+
+// withPolicy wraps n in a policyNotifier when the channel has any policy.
+func withPolicy(n Notifier, ch NotificationChannelConfig, loc *time.Location) (Notifier, error) {
+	if ch.QuietHours == nil && ch.RateLimit.Max <= 0 && ch.TitleTemplate == "" && ch.BodyTemplate == "" {
+		return n, nil
+	}
+	p := &policyNotifier{Notifier: n, quiet: ch.QuietHours, max: ch.RateLimit.Max, loc: loc, now: time.Now}
+	if p.quiet != nil {
+		if err := validateSchedule(p.quiet); err != nil {
+			return nil, fmt.Errorf("quiet_hours: %w", err)
+		}
+	}
+	if p.max > 0 {
+		p.window = time.Duration(firstPositive(ch.RateLimit.PerSeconds, 3600)) * time.Second
+	}
+	var err error
+	if ch.TitleTemplate != "" {
+		if p.title, err = template.New("title").Funcs(notifyTemplateFuncs).Parse(ch.TitleTemplate); err != nil {
+			return nil, fmt.Errorf("title_template: %w", err)
+		}
+	}
+	if ch.BodyTemplate != "" {
+		if p.body, err = template.New("body").Funcs(notifyTemplateFuncs).Parse(ch.BodyTemplate); err != nil {
+			return nil, fmt.Errorf("body_template: %w", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *policyNotifier) Notify(ctx context.Context, n Notification) error {
+	now := p.now().In(p.loc)
+	if p.quiet != nil && p.quiet.inSchedule(now) {
+		return errQuietHours
+	}
+	if p.max > 0 {
+		p.mu.Lock()
+		kept := p.sent[:0]
+		for _, t := range p.sent {
+			if now.Sub(t) < p.window {
+				kept = append(kept, t)
+			}
+		}
+		p.sent = kept
+		if len(p.sent) >= p.max {
+			p.mu.Unlock()
+			return errRateLimited
+		}
+		p.sent = append(p.sent, now)
+		p.mu.Unlock()
+	}
+
+	out := n
+	for _, t := range []struct {
+		tmpl *template.Template
+		dst  *string
+	}{{p.title, &out.Title}, {p.body, &out.Body}} {
+		if t.tmpl == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, n); err != nil {
+			return fmt.Errorf("%s: %w", t.tmpl.Name(), err)
+		}
+		*t.dst = strings.TrimSpace(buf.String())
+	}
+	return p.Notifier.Notify(ctx, out)
+}
+
+// absoluteURL prefixes a backend path ("/snapshots/...") with public_url.
+// Without public_url there is no link phones could follow, so it is "".
+func absoluteURL(publicURL, path string) string {
+	if path == "" || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if publicURL == "" {
+		return ""
+	}
+	return strings.TrimRight(publicURL, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// snapshotLink is the public URL of a notification's snapshot, or "".
+func snapshotLink(publicURL string, n Notification) string {
+	if n.Snapshot == "" {
+		return ""
+	}
+	return absoluteURL(publicURL, snapshotURL(n.Snapshot))
+}
+
+// logNotifier writes notifications to the backend log.
+type logNotifier struct{ name string }
+
+func (l logNotifier) Name() string { return l.name }
+
+func (l logNotifier) Notify(ctx context.Context, n Notification) error {
+	log.Printf("[Notify] %s: %s — %s %s", l.name, n.Title, n.Body, n.URL)
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+notify_email.go
+---------------
+
+The email notification channel. Messages go out over SMTP, with the
+detection snapshot attached as a JPEG and a link back to the backend
+when notifications: public_url is set:
+
+	- name: me
+	  type: email
+	  smtp_host: smtp.example.com
+	  smtp_port: 587       # default 587, or 465 with tls: tls
+	  tls: starttls        # starttls (default), tls or none
+	  username: cam@example.com
+	  password: ...
+	  from: cam@example.com
+	  to: [me@example.com, partner@example.com]
+
+With starttls a server that doesn't offer STARTTLS is an error rather
+than a reason to send the password and message in the clear; plaintext
+needs an explicit tls: none.
+*/
+
+// emailNotifier sends notifications by SMTP, with the snapshot attached.
+type emailNotifier struct {
+	name      string
+	host      string
+	port      int
+	tlsMode   string // starttls, tls or none
+	username  string
+	password  string
+	from      string
+	to        []string
+	snapshots SnapshotStore
+	publicURL string
+}
+
+func newEmailNotifier(ch NotificationChannelConfig, snapshots SnapshotStore, publicURL string) (*emailNotifier, error) {
+	if ch.SMTPHost == "" || ch.From == "" || len(ch.To) == 0 {
+		return nil, fmt.Errorf("email needs smtp_host, from and to")
+	}
+	e := &emailNotifier{
+		name:      ch.Name,
+		host:      ch.SMTPHost,
+		port:      ch.SMTPPort,
+		tlsMode:   firstNonEmpty(ch.TLS, "starttls"),
+		username:  ch.Username,
+		password:  ch.Password,
+		from:      ch.From,
+		to:        ch.To,
+		snapshots: snapshots,
+		publicURL: publicURL,
+	}
+	switch e.tlsMode {
+	case "starttls", "none":
+		if e.port == 0 {
+			e.port = 587
+		}
+	case "tls":
+		if e.port == 0 {
+			e.port = 465
+		}
+	default:
+		return nil, fmt.Errorf("tls must be starttls, tls or none")
+	}
+	return e, nil
+}
+
+func (e *emailNotifier) Name() string { return e.name }
+
+func (e *emailNotifier) Notify(ctx context.Context, n Notification) error {
+	var snapshot []byte
+	if key, err := cleanSnapshotKey(n.Snapshot); n.Snapshot != "" && err == nil {
+		if snapshot, err = e.snapshots.Get(ctx, key); err != nil {
+			log.Printf("[Notify] %s: sending without snapshot %s: %v", e.name, key, err)
+		}
+	}
+	msg, err := e.message(n, snapshot)
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, msg)
+}
+
+// message builds the MIME message: the text, plus the snapshot as a JPEG
+// attachment when there is one.
+func (e *emailNotifier) message(n Notification, snapshot []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	date := n.Time
+	if date.IsZero() {
+		date = time.Now()
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", e.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	text := n.Body
+	if link := absoluteURL(e.publicURL, n.URL); link != "" {
+		text += "\n\n" + link
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	qp.Write([]byte(text))
+	qp.Close()
+
+	if len(snapshot) > 0 {
+		name := path.Base(n.Snapshot)
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg; name=\"" + name + "\""},
+			"Content-Disposition":       {"attachment; filename=\"" + name + "\""},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, snapshot)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// send delivers msg over SMTP.
+func (e *emailNotifier) send(ctx context.Context, msg []byte) error {
+	addr := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if e.tlsMode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: e.host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.tlsMode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS (set tls: none to send unencrypted)", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: e.host}); err != nil {
+			return err
+		}
+	}
+	if e.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.username, e.password, e.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// writeBase64Lines writes data base64-encoded in 76-character lines, as
+// MIME requires.
+func writeBase64Lines(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
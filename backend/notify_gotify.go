@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+notify_gotify.go
+----------------
+
+The Gotify notification channel. Messages are POSTed to
+<server>/message with an application token and rendered as markdown, so
+the snapshot shows inline and tapping the notification opens the
+backend (both need notifications: public_url):
+
+	- name: tablet
+	  type: gotify
+	  server: http://gotify.local
+	  token: A1b2C3     # application token
+	  priority: 5       # 0-10, optional
+*/
+
+// gotifyNotifier sends notifications to a Gotify application.
+type gotifyNotifier struct {
+	name      string
+	url       string
+	token     string
+	priority  int
+	publicURL string
+}
+
+func newGotifyNotifier(ch NotificationChannelConfig, publicURL string) (*gotifyNotifier, error) {
+	if ch.Server == "" || ch.Token == "" {
+		return nil, fmt.Errorf("gotify needs server and token")
+	}
+	return &gotifyNotifier{
+		name:      ch.Name,
+		url:       strings.TrimRight(ch.Server, "/") + "/message",
+		token:     ch.Token,
+		priority:  ch.Priority,
+		publicURL: publicURL,
+	}, nil
+}
+
+func (g *gotifyNotifier) Name() string { return g.name }
+
+func (g *gotifyNotifier) Notify(ctx context.Context, n Notification) error {
+	message := n.Body
+	notification := map[string]interface{}{}
+	if link := absoluteURL(g.publicURL, n.URL); link != "" {
+		notification["click"] = map[string]string{"url": link}
+	}
+	if link := snapshotLink(g.publicURL, n); link != "" {
+		message += "\n\n![snapshot](" + link + ")"
+		notification["bigImageUrl"] = link
+	}
+	payload := map[string]interface{}{
+		"title":   n.Title,
+		"message": message,
+		"extras": map[string]interface{}{
+			"client::display":      map[string]string{"contentType": "text/markdown"},
+			"client::notification": notification,
+		},
+	}
+	if g.priority > 0 {
+		payload["priority"] = g.priority // else the application's default
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", g.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.token)
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("gotify: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
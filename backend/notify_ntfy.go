@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+notify_ntfy.go
+--------------
+
+The ntfy notification channel (https://ntfy.sh or a self-hosted server).
+Each message is a POST to <server>/<topic>; the snapshot is attached by
+URL and tapping the notification opens the backend, so both need
+notifications: public_url:
+
+	- name: phone
+	  type: ntfy
+	  server: https://ntfy.sh
+	  topic: my-cameras
+	  token: tk_...     # optional access token
+	  priority: 4       # 1-5, optional
+*/
+
+// ntfyNotifier publishes notifications to an ntfy topic.
+type ntfyNotifier struct {
+	name      string
+	url       string
+	token     string
+	priority  int
+	publicURL string
+}
+
+func newNtfyNotifier(ch NotificationChannelConfig, publicURL string) (*ntfyNotifier, error) {
+	if ch.Server == "" || ch.Topic == "" {
+		return nil, fmt.Errorf("ntfy needs server and topic")
+	}
+	return &ntfyNotifier{
+		name:      ch.Name,
+		url:       strings.TrimRight(ch.Server, "/") + "/" + strings.TrimLeft(ch.Topic, "/"),
+		token:     ch.Token,
+		priority:  ch.Priority,
+		publicURL: publicURL,
+	}, nil
+}
+
+func (f *ntfyNotifier) Name() string { return f.name }
+
+func (f *ntfyNotifier) Notify(ctx context.Context, n Notification) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", f.url, strings.NewReader(n.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", n.Title))
+	if link := absoluteURL(f.publicURL, n.URL); link != "" {
+		req.Header.Set("Click", link)
+	}
+	if link := snapshotLink(f.publicURL, n); link != "" {
+		req.Header.Set("Attach", link)
+	}
+	if f.priority > 0 {
+		req.Header.Set("Priority", strconv.Itoa(f.priority))
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	resp, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ntfy: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
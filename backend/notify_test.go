@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps what it is asked to send.
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordingNotifier) Name() string { return "recording" }
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestPolicyNotifier(t *testing.T) {
+	base := time.Date(2025, 7, 11, 12, 0, 0, 0, time.UTC) // a Friday
+	type send struct {
+		after time.Duration // since base
+		err   error
+		title string // as delivered
+	}
+	tests := []struct {
+		name  string
+		ch    NotificationChannelConfig
+		sends []send
+	}{
+		{
+			name: "no policy",
+			sends: []send{
+				{0, nil, "Garage: person"},
+			},
+		},
+		{
+			name: "quiet hours wrap midnight",
+			ch:   NotificationChannelConfig{QuietHours: &RuleSchedule{From: "23:00", To: "07:00"}},
+			sends: []send{
+				{10 * time.Hour, nil, "Garage: person"}, // 22:00
+				{11 * time.Hour, errQuietHours, ""},     // 23:00
+				{18 * time.Hour, errQuietHours, ""},     // 06:00
+				{19 * time.Hour, nil, "Garage: person"}, // 07:00
+			},
+		},
+		{
+			name: "rate limit",
+			ch:   NotificationChannelConfig{RateLimit: NotifyRateLimit{Max: 2, PerSeconds: 60}},
+			sends: []send{
+				{0, nil, "Garage: person"},
+				{10 * time.Second, nil, "Garage: person"},
+				{20 * time.Second, errRateLimited, ""},
+				{61 * time.Second, nil, "Garage: person"}, // the first has left the window
+				{65 * time.Second, errRateLimited, ""},
+			},
+		},
+		{
+			name: "templates",
+			ch:   NotificationChannelConfig{TitleTemplate: `{{.Camera}} saw {{join .Labels " and "}}`, BodyTemplate: ` {{.Body}}! `},
+			sends: []send{
+				{0, nil, "Garage saw person and car"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		rec := &recordingNotifier{}
+		n, err := withPolicy(rec, tt.ch, time.UTC)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var now time.Time
+		if p, ok := n.(*policyNotifier); ok {
+			p.now = func() time.Time { return now }
+		}
+		delivered := 0
+		for i, s := range tt.sends {
+			now = base.Add(s.after)
+			err := n.Notify(context.Background(), Notification{Title: "Garage: person", Body: "at the door", Camera: "Garage", Labels: []string{"person", "car"}})
+			if !errors.Is(err, s.err) {
+				t.Errorf("%s: send %d: error %v, want %v", tt.name, i, err, s.err)
+				continue
+			}
+			if s.err != nil {
+				continue
+			}
+			delivered++
+			if len(rec.sent) != delivered {
+				t.Errorf("%s: send %d: %d delivered, want %d", tt.name, i, len(rec.sent), delivered)
+				continue
+			}
+			if got := rec.sent[delivered-1].Title; got != s.title {
+				t.Errorf("%s: send %d: title %q, want %q", tt.name, i, got, s.title)
+			}
+		}
+	}
+
+	if _, err := withPolicy(&recordingNotifier{}, NotificationChannelConfig{TitleTemplate: "{{.Nope"}, time.UTC); err == nil {
+		t.Error("broken title_template accepted")
+	}
+	if _, err := withPolicy(&recordingNotifier{}, NotificationChannelConfig{QuietHours: &RuleSchedule{From: "7pm"}}, time.UTC); err == nil {
+		t.Error("broken quiet_hours accepted")
+	}
+}
+
+// smtpMessage is one message received by testSMTPServer.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// testSMTPServer is a minimal SMTP server on localhost that advertises the
+// given EHLO extensions and hands every message it receives to the channel.
+func testSMTPServer(t *testing.T, extensions ...string) (string, int, <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	msgs := make(chan smtpMessage, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(c, extensions, msgs)
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, msgs
+}
+
+func serveSMTP(c net.Conn, extensions []string, msgs chan<- smtpMessage) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(s string) { io.WriteString(c, s+"\r\n") }
+	reply("220 test ESMTP")
+	var msg smtpMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		upper := strings.ToUpper(cmd)
+		switch {
+		case strings.HasPrefix(upper, "EHLO"):
+			lines := append([]string{"test"}, extensions...)
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				reply("250" + sep + l)
+			}
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			msg = smtpMessage{from: smtpPath(cmd)}
+			reply("250 ok")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			msg.to = append(msg.to, smtpPath(cmd))
+			reply("250 ok")
+		case upper == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			msgs <- msg
+			reply("250 queued")
+		case upper == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// smtpPath is the address between < and > in MAIL FROM or RCPT TO.
+func smtpPath(cmd string) string {
+	start, end := strings.Index(cmd, "<"), strings.Index(cmd, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return cmd[start+1 : end]
+}
+
+func TestEmailNotifier(t *testing.T) {
+	app := newTestApp(t, nil)
+	snapshot := bytes.Repeat([]byte{0xff, 0xd8, 0x00, 0x7f, 0x10}, 40) // long enough to wrap
+	if err := app.Snapshots.Put(context.Background(), "garage/2025-07-11/ab12.jpg", snapshot); err != nil {
+		t.Fatal(err)
+	}
+	host, port, msgs := testSMTPServer(t, "8BITMIME")
+
+	e, err := newEmailNotifier(NotificationChannelConfig{
+		Name: "me", SMTPHost: host, SMTPPort: port, TLS: "none",
+		From: "cam@example.com", To: []string{"me@example.com", "you@example.com"},
+	}, app.Snapshots, "http://cam.local:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := Notification{
+		Title:    "Garage: person — ünïcode",
+		Body:     "Someone at the door at 23:04",
+		URL:      "/snapshots/garage/2025-07-11/ab12.jpg",
+		Snapshot: "garage/2025-07-11/ab12.jpg",
+		Time:     time.Date(2025, 7, 11, 23, 4, 0, 0, time.UTC),
+	}
+	if err := e.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	var got smtpMessage
+	select {
+	case got = <-msgs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	if got.from != "cam@example.com" || strings.Join(got.to, ",") != "me@example.com,you@example.com" {
+		t.Errorf("envelope from %q to %v", got.from, got.to)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	headers := []struct{ name, got, want string }{
+		{"Subject", subject, n.Title},
+		{"To", m.Header.Get("To"), "me@example.com, you@example.com"},
+		{"Date", m.Header.Get("Date"), "Fri, 11 Jul 2025 23:04:00 +0000"},
+	}
+	for _, h := range headers {
+		if h.got != h.want {
+			t.Errorf("%s: %q, want %q", h.name, h.got, h.want)
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type %q: %v", m.Header.Get("Content-Type"), err)
+	}
+
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	text, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(text))
+	// SMTP sends lines ending in CRLF.
+	if want := n.Body + "\r\n\r\nhttp://cam.local:8080/snapshots/garage/2025-07-11/ab12.jpg"; string(body) != want {
+		t.Errorf("text part %q, want %q", body, want)
+	}
+	att, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if att.FileName() != "ab12.jpg" || !strings.HasPrefix(att.Header.Get("Content-Type"), "image/jpeg") {
+		t.Errorf("attachment %q, %q", att.FileName(), att.Header.Get("Content-Type"))
+	}
+	raw, _ := io.ReadAll(att)
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(raw)), ""))
+	if err != nil || !bytes.Equal(decoded, snapshot) {
+		t.Errorf("attachment decodes to %x (%v), want %x", decoded, err, snapshot)
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("extra part after the attachment: %v", err)
+	}
+}
+
+func TestEmailNotifierRequiresStartTLS(t *testing.T) {
+	host, port, msgs := testSMTPServer(t, "AUTH PLAIN") // no STARTTLS
+	e, err := newEmailNotifier(NotificationChannelConfig{
+		Name: "me", SMTPHost: host, SMTPPort: port, Username: "cam", Password: "secret",
+		From: "cam@example.com", To: []string{"me@example.com"},
+	}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Notify(context.Background(), Notification{Title: "Garage: person", Body: "at the door"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("error %v, want one about STARTTLS", err)
+	}
+	select {
+	case m := <-msgs:
+		t.Fatalf("message sent in the clear: %+v", m)
+	default:
+	}
+}
+
+// failingNotifier fails every notification with err.
+type failingNotifier struct{ err error }
+
+func (f failingNotifier) Name() string { return "failing" }
+
+func (f failingNotifier) Notify(ctx context.Context, n Notification) error { return f.err }
+
+func TestAppNotifyReportsChannelErrors(t *testing.T) {
+	app := &App{Notifiers: map[string]Notifier{
+		"ok":    &recordingNotifier{},
+		"down":  failingNotifier{errors.New("status 502")},
+		"quiet": failingNotifier{errQuietHours},
+	}}
+	tests := []struct {
+		channels []string
+		want     string // "" = no error
+	}{
+		{[]string{"ok"}, ""},
+		{nil, ""},
+		{[]string{"ok", "down"}, "down: status 502"},
+		{[]string{"quiet"}, "quiet: quiet hours"},
+		{[]string{"gone", "down"}, "gone: unknown channel\ndown: status 502"},
+	}
+	for _, tt := range tests {
+		err := app.notify(context.Background(), tt.channels, Notification{Title: "Garage: person"})
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("notify(%v) = %q, want %q", tt.channels, got, tt.want)
+		}
+	}
+}
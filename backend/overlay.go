@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+/*
+overlay.go
+----------
+
+Draws bounding boxes, labels and confidences onto a stored snapshot for
+/snapshot?overlay=true (optionally &labels=car,person, and &event_id=N to
+pick the detection when several share a content-addressed snapshot).
+
+Boxes come from the detections row, so this works best with clean frames
+(publisher.raw_frames: true); on already-annotated frames the boxes are
+simply drawn again on top.
+*/
+
+// overlayPalette matches the pastel colours used by the frontend.
+var overlayPalette = []color.RGBA{
+	{0xFF, 0xB3, 0xBA, 0xFF}, // pastelRed
+	{0xFF, 0xDF, 0xBA, 0xFF}, // pastelOrange
+	{0xFF, 0xFF, 0xBA, 0xFF}, // pastelYellow
+	{0xBA, 0xFF, 0xC9, 0xFF}, // pastelGreen
+	{0xBA, 0xE1, 0xFF, 0xFF}, // pastelBlue
+	{0xD7, 0xBA, 0xFF, 0xFF}, // pastelPurple
+	{0xFF, 0xBA, 0xED, 0xFF}, // pastelPink
+}
+
+// labelColour picks a stable colour per label so "car" always looks the same.
+func labelColour(label string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(label))
+	return overlayPalette[h.Sum32()%uint32(len(overlayPalette))]
+}
+
+// Detection is one object from a detections row: label, box and confidence.
+type Detection struct {
+	Label      string    `json:"label"`
+	Box        []float64 `json:"box"` // x1, y1, x2, y2 in snapshot pixels
+	Confidence float64   `json:"confidence,omitempty"`
+}
+
+// parseDetections zips the labels/boxes/confidences JSON columns together.
+// Confidences may be missing ("" or "null") for rows ingested before they were published.
+func parseDetections(labelsJSON, boxesJSON, confidencesJSON string) []Detection {
+	var labels []string
+	var boxes [][]float64
+	var confs []float64
+	json.Unmarshal([]byte(labelsJSON), &labels)
+	json.Unmarshal([]byte(boxesJSON), &boxes)
+	if confidencesJSON != "" {
+		json.Unmarshal([]byte(confidencesJSON), &confs)
+	}
+
+	var dets []Detection
+	for i, label := range labels {
+		d := Detection{Label: label}
+		if i < len(boxes) {
+			d.Box = boxes[i]
+		}
+		if i < len(confs) {
+			d.Confidence = confs[i]
+		}
+		dets = append(dets, d)
+	}
+	return dets
+}
+
+// detectionsForSnapshot loads the objects recorded for a snapshot key and
+// returns the ID of the row they came from. Content-addressed snapshots can
+// be shared by several rows: eventID picks one, 0 means the newest.
+// sql.ErrNoRows means no such row uses this snapshot.
+func detectionsForSnapshot(d *sql.DB, key string, eventID int64) (int64, []Detection, error) {
+	var id int64
+	var labels, boxes, confs string
+	err := d.QueryRow(`
+		SELECT id, COALESCE(labels, ''), COALESCE(boxes, ''), COALESCE(confidences, '')
+		FROM detections WHERE snapshot_file IN (?, ?) AND (? = 0 OR id = ?)
+		ORDER BY id DESC LIMIT 1`,
+		key, "./snapshots/"+key, eventID, eventID,
+	).Scan(&id, &labels, &boxes, &confs)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, parseDetections(labels, boxes, confs), nil
+}
+
+// renderOverlay draws dets onto the JPEG in src. If only is non-empty,
+// just those labels are drawn.
+func renderOverlay(src []byte, dets []Detection, only []string) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	canvas := image.NewRGBA(img.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	// Scale line width with the frame so boxes stay visible on 4K and 320px alike.
+	thickness := canvas.Bounds().Dx() / 320
+	if thickness < 2 {
+		thickness = 2
+	}
+
+	for _, d := range dets {
+		if len(d.Box) < 4 || (len(only) > 0 && !containsFold(only, d.Label)) {
+			continue
+		}
+		c := labelColour(d.Label)
+		r := image.Rect(int(d.Box[0]), int(d.Box[1]), int(d.Box[2]), int(d.Box[3])).Intersect(canvas.Bounds())
+		if r.Empty() {
+			continue
+		}
+		strokeRect(canvas, r, thickness, c)
+
+		text := d.Label
+		if d.Confidence > 0 {
+			text = fmt.Sprintf("%s %.2f", d.Label, d.Confidence)
+		}
+		drawLabel(canvas, r.Min, text, c)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("encode overlay: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// strokeRect draws the outline of r with the given thickness.
+func strokeRect(dst *image.RGBA, r image.Rectangle, t int, c color.RGBA) {
+	u := image.NewUniform(c)
+	edges := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+t), // top
+		image.Rect(r.Min.X, r.Max.Y-t, r.Max.X, r.Max.Y), // bottom
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+t, r.Max.Y), // left
+		image.Rect(r.Max.X-t, r.Min.Y, r.Max.X, r.Max.Y), // right
+	}
+	for _, e := range edges {
+		draw.Draw(dst, e.Intersect(r), u, image.Point{}, draw.Src)
+	}
+}
+
+// drawLabel writes text on a filled tag just above (or inside) the box corner.
+func drawLabel(dst *image.RGBA, at image.Point, text string, bg color.RGBA) {
+	face := basicfont.Face7x13
+	w := font.MeasureString(face, text).Ceil() + 6
+	h := face.Height + 4
+
+	y := at.Y - h
+	if y < dst.Bounds().Min.Y {
+		y = at.Y // no room above the box, put the tag inside it
+	}
+	tag := image.Rect(at.X, y, at.X+w, y+h).Intersect(dst.Bounds())
+	draw.Draw(dst, tag, image.NewUniform(bg), image.Point{}, draw.Src)
+
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+		Dot:  fixed.P(tag.Min.X+3, tag.Min.Y+face.Ascent+2),
+	}
+	d.DrawString(text)
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayLabels narrows a labels= filter to the labels the detection has,
+// lower-cased, deduped and sorted, so every way of asking for the same
+// drawing shares one cached overlay. A filter that matches nothing, or
+// every label drawn anyway, is nil: the plain overlay.
+func overlayLabels(only []string, dets []Detection) []string {
+	if len(only) == 0 {
+		return nil
+	}
+	var kept, all []string
+	for _, d := range dets {
+		l := strings.ToLower(d.Label)
+		if containsFold(all, l) {
+			continue
+		}
+		all = append(all, l)
+		if containsFold(only, l) {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == 0 || len(kept) == len(all) {
+		return nil
+	}
+	sort.Strings(kept)
+	return kept
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOverlayLabels(t *testing.T) {
+	dets := []Detection{{Label: "car"}, {Label: "person"}, {Label: "Person"}, {Label: "dog"}}
+	tests := []struct {
+		only []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"car"}, []string{"car"}},
+		{[]string{"car", "car", "CAR"}, []string{"car"}},
+		{[]string{"person", "car"}, []string{"car", "person"}},
+		{[]string{"car", "x1"}, []string{"car"}},
+		{[]string{"x1"}, nil},                          // matches nothing
+		{[]string{"dog", "person", "car", "cat"}, nil}, // everything: the plain overlay
+	}
+	for _, tt := range tests {
+		if got := overlayLabels(tt.only, dets); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("overlayLabels(%v) = %v, want %v", tt.only, got, tt.want)
+		}
+	}
+}
+
+func TestOverlayCacheIsBounded(t *testing.T) {
+	app := newTestApp(t, nil)
+	var frame bytes.Buffer
+	jpeg.Encode(&frame, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil)
+	if err := app.Snapshots.Put(context.Background(), "garage/2025-07-11/a.jpg", frame.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertDetection(1752224400, "garage", `["car","person"]`, `[[1,1,20,20],[30,10,60,40]]`, `[0.9,0.8]`, "garage/2025-07-11/a.jpg", "[]"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two distinct drawings (car only, and everything), however they are asked for.
+	for _, labels := range []string{"car", "car,car", "CAR", "car,x1", "x1", "x2", "", "car,person", "person,car,dog"} {
+		w := httptest.NewRecorder()
+		app.handleSnapshot(w, httptest.NewRequest("GET", "/snapshot?file=garage/2025-07-11/a.jpg&overlay=true&labels="+labels, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("labels=%s: status %d", labels, w.Code)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(app.Config.Snapshots.ThumbnailDir, "garage", "2025-07-11", "*.jpg"))
+	if len(files) != 2 {
+		var names []string
+		for _, f := range files {
+			names = append(names, filepath.Base(f))
+		}
+		t.Errorf("%d cached overlays %v, want 2", len(files), names)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/*
+prompts.go
+----------
+
+The chat prompts are Go text/template files referenced from config.yaml:
+
+	prompts:
+	  extraction_system: prompts/extraction_system.tmpl
+	  extraction: prompts/extraction.tmpl
+	  system: prompts/system.tmpl
+	  answer: prompts/answer.tmpl
+	  tools_system: prompts/tools_system.tmpl
+	  digest: prompts/digest.tmpl
+
+Relative paths are read from the config directory. A file is re-read when
+its modification time changes, so prompts can be tuned on a running
+backend. Prompts left out (or whose file fails to load) use the built-in
+defaults below, which the files in config/prompts start out as.
+
+Every template gets a PromptData. GET /admin/prompts/preview renders them
+for a question without calling the LLM.
+*/
+
+// PromptData holds the variables available to every prompt template.
+type PromptData struct {
+	Question    string   // the user's question
+	Camera      string   // searched cameras, comma-separated
+	Cameras     []string // searched cameras
+	MultiCamera bool     // more than one camera searched
+	Objects     []string // objects extracted from the question
+	Context     string   // detection context from SQLite, or the digest facts
+	Time        string   // current time, RFC3339 in the configured timezone
+	TimeRange   string   // resolved time range ("last night (...)"), or the digest period; empty if none
+	RangeStart  string   // RFC3339 start of TimeRange
+	RangeEnd    string   // RFC3339 end of TimeRange
+}
+
+// promptNames lists the templates, in pipeline order.
+var promptNames = []string{"extraction_system", "extraction", "system", "answer", "tools_system", "digest"}
+
+// builtinPrompts are used for prompts that are not configured.
+var builtinPrompts = map[string]string{
+	"extraction_system": `You extract objects only. No explanation.`,
+
+	"extraction": `User question: {{.Question}}
+
+Extract the main object(s) or labels the user wants to know about. Return ONLY a JSON array, e.g. ["car"] or ["person", "dog"]. If no object, return [].`,
+
+	"system": `You are a helpful camera assistant.`,
+
+	"answer": `{{if .MultiCamera}}Cameras{{else}}Camera{{end}}: {{.Camera}}
+{{- if .TimeRange}}
+Time range searched: {{.TimeRange}}{{end}}
+
+Detection context:
+{{.Context}}
+
+User question: {{.Question}}
+{{- if or .MultiCamera .TimeRange}}
+
+{{if .MultiCamera}}The detections are in time order; say which camera saw what.{{end}}
+{{- if and .MultiCamera .TimeRange}} {{end}}
+{{- if .TimeRange}}Say which time range you searched.{{end}}
+{{- end}}`,
+
+	"tools_system": `You are a helpful camera assistant. Answer questions about what the cameras saw using the tools; ` +
+		`never guess — if the tools find nothing, say so. The question is about cameras: {{.Camera}} ` +
+		`(tools search these unless you pass camera_id). When several cameras are involved, say which camera saw what, in time order. ` +
+		`The current time is {{.Time}}. Pass times to tools as RFC3339.` +
+		`{{if .TimeRange}} The question refers to {{.TimeRange}}: use start={{.RangeStart}} and end={{.RangeEnd}}, and say which range you searched.{{end}}`,
+
+	"digest": `Camera: {{.Camera}}
+Period: {{.TimeRange}}
+
+Activity:
+{{.Context}}
+
+Write a short summary (at most 5 sentences) of this camera's activity for the household: what was seen, ` +
+		`the busiest times and anything seen for the first time. Use only the facts above.`,
+}
+
+// promptFuncs are available in every template.
+var promptFuncs = template.FuncMap{"join": strings.Join}
+
+// loadedPrompt is a parsed template file and the mtime it was parsed at.
+type loadedPrompt struct {
+	modTime time.Time
+	tmpl    *template.Template
+}
+
+// PromptSet renders the configured prompts, reloading changed files.
+type PromptSet struct {
+	cfg PromptsConfig
+
+	mu     sync.Mutex
+	loaded map[string]*loadedPrompt
+}
+
+// NewPromptSet returns the prompts configured under prompts:.
+func NewPromptSet(cfg PromptsConfig) *PromptSet {
+	return &PromptSet{cfg: cfg, loaded: map[string]*loadedPrompt{}}
+}
+
+// path returns where a prompt is read from, or "" for the built-in one.
+func (p *PromptSet) path(name string) string {
+	var file string
+	switch name {
+	case "extraction_system":
+		file = p.cfg.ExtractionSystem
+	case "extraction":
+		file = p.cfg.Extraction
+	case "system":
+		file = p.cfg.System
+	case "answer":
+		file = p.cfg.Answer
+	case "tools_system":
+		file = p.cfg.ToolsSystem
+	case "digest":
+		file = p.cfg.Digest
+	}
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(configDir, file)
+}
+
+// template returns the parsed template for name, re-reading its file when
+// it changed. If the file can't be loaded the built-in prompt is returned
+// along with the error.
+func (p *PromptSet) template(name string) (*template.Template, error) {
+	builtin, ok := builtinPrompts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt %q", name)
+	}
+	fallback := template.Must(template.New(name).Funcs(promptFuncs).Parse(builtin))
+
+	path := p.path(name)
+	if path == "" {
+		return fallback, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fallback, fmt.Errorf("prompt %s: %w", name, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if lp := p.loaded[name]; lp != nil && lp.modTime.Equal(info.ModTime()) {
+		return lp.tmpl, nil
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return fallback, fmt.Errorf("prompt %s: %w", name, err)
+	}
+	tmpl, err := template.New(name).Funcs(promptFuncs).Parse(string(text))
+	if err != nil {
+		return fallback, fmt.Errorf("prompt %s: %w", name, err)
+	}
+	log.Printf("[Prompts] Loaded %s from %s", name, path)
+	p.loaded[name] = &loadedPrompt{modTime: info.ModTime(), tmpl: tmpl}
+	return tmpl, nil
+}
+
+// Render executes a prompt template. On error it still returns the
+// built-in prompt rendered with the same data, so chat keeps working.
+func (p *PromptSet) Render(name string, data PromptData) (string, error) {
+	tmpl, loadErr := p.template(name)
+	if tmpl == nil {
+		return "", loadErr
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		buf.Reset()
+		template.Must(template.New(name).Funcs(promptFuncs).Parse(builtinPrompts[name])).Execute(&buf, data)
+		return buf.String(), fmt.Errorf("prompt %s: %w", name, err)
+	}
+	// Editors end files with a newline; the prompt shouldn't.
+	return strings.TrimRight(buf.String(), "\n"), loadErr
+}
+
+// renderPrompt renders a prompt for the chat pipeline, logging failures.
+func (app *App) renderPrompt(name string, data PromptData) string {
+	out, err := app.Prompts.Render(name, data)
+	if err != nil {
+		log.Printf("Prompt template failed, using the built-in one: %v", err)
+	}
+	return out
+}
+
+// promptData fills in the variables shared by all prompts.
+func (app *App) promptData(question string, cameras, objects []string, rng *TimeRange) PromptData {
+	data := PromptData{
+		Question:    question,
+		Camera:      strings.Join(cameras, ", "),
+		Cameras:     cameras,
+		MultiCamera: len(cameras) > 1,
+		Objects:     objects,
+		Time:        app.now().Format(time.RFC3339),
+	}
+	if rng != nil {
+		data.TimeRange = rng.String()
+		data.RangeStart = rng.Start.Format(time.RFC3339)
+		data.RangeEnd = rng.End.Format(time.RFC3339)
+	}
+	return data
+}
+
+// handlePromptPreview handles GET|POST /admin/prompts/preview. It takes the
+// /chat query (GET: ?message=...&camera_id=...&cameras=a,b) plus an optional
+// objects=car,person, and returns every rendered prompt without calling the
+// LLM. Without objects, labels named in the question stand in for the
+// extraction step. Semantic search results show as a placeholder, since
+// they'd need the embedding model.
+func (app *App) handlePromptPreview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req struct {
+		chatQuery
+		Objects []string `json:"objects"`
+	}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Message = q.Get("message")
+		req.CameraID = q.Get("camera_id")
+		if c := q.Get("cameras"); c != "" {
+			req.Cameras = strings.Split(c, ",")
+		}
+		if o := q.Get("objects"); o != "" {
+			req.Objects = strings.Split(o, ",")
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "Missing message", http.StatusBadRequest)
+		return
+	}
+
+	objects := req.Objects
+	if objects == nil {
+		objects = []string{}
+		named := labelsIn(req.Message)
+		for _, l := range watchedLabels {
+			if named[l] {
+				objects = append(objects, l)
+			}
+		}
+	}
+
+	req.preview = true
+	plan, err := app.planAnswer(r.Context(), req.chatQuery, objects, noProgress)
+	if err != nil {
+		log.Printf("handlePromptPreview: %v", err)
+		http.Error(w, "Preview failed", http.StatusInternalServerError)
+		return
+	}
+
+	data := app.promptData(req.Message, plan.Cameras, objects, plan.Range)
+	data.Context = plan.Context
+	prompts := map[string]string{}
+	sources := map[string]string{}
+	var problems []string
+	for _, name := range promptNames {
+		out, err := app.Prompts.Render(name, data)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		prompts[name] = out
+		sources[name] = firstNonEmpty(app.Prompts.path(name), "built-in")
+	}
+
+	// The messages the final LLM call would get; images are left out.
+	messages := plan.Messages
+	if app.Config.Chat.Tools {
+		messages = []ChatMessage{{Role: "system", Content: prompts["tools_system"]}, {Role: "user", Content: req.Message}}
+	}
+	for i := range messages {
+		messages[i].Images = nil
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"objects":  objects,
+		"cameras":  plan.Cameras,
+		"context":  plan.Context,
+		"prompts":  prompts,
+		"sources":  sources,
+		"messages": messages,
+		"errors":   problems,
+	})
+}
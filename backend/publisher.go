@@ -1,6 +1,325 @@
 package main
 
-// Placeholder for Home Assistant or MQTT push logic.
-func publishToHomeAssistant() {
-    // Not implemented yet.
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+publisher.go
+------------
+
+Home Assistant integration over MQTT discovery (needs mqtt.broker, see
+mqtt.go):
+
+	home_assistant:
+	  enabled: true
+	  discovery_prefix: homeassistant   # Home Assistant's default
+	  labels: [person, car]             # default
+	  off_delay_seconds: 30
+	  off_delays: {car: 300}            # per label
+
+Each camera shows up as a device with:
+
+- a binary sensor per label ("Person detected"), on from a detection of
+  the label until off_delay_seconds pass without one
+- a count sensor per label: objects of it in the latest detection, back to
+  0 when the binary sensor turns off
+- a "Last event" timestamp sensor, with labels, event ID and snapshot URL
+  as attributes
+- a camera entity showing the latest snapshot
+
+State topics are <topic_prefix>/<camera>/<label>, .../<label>/count,
+.../last_event and .../snapshot. Discovery configs and states are
+retained, and published again whenever the backend (re)connects or Home
+Assistant announces itself on <discovery_prefix>/status. The entities go
+unavailable when the backend disconnects (mqtt.go's last will).
+
+Detections are published one at a time, in the order they were stored, by
+a single worker, so the retained states always end on the newest one and
+a slow broker never holds up the subscriber.
+*/
+
+const (
+	defaultHADiscoveryPrefix = "homeassistant"
+	defaultHAOffDelaySeconds = 30
+	haQueueSize              = 256 // detections waiting to be published
+)
+
+var defaultHALabels = []string{"person", "car"}
+
+// haPublisher holds the pending "detected → off" timers, keyed by camera|label,
+// and the detections queued for the publishing worker.
+type haPublisher struct {
+	mu     sync.Mutex
+	off    map[string]*time.Timer
+	events chan DetectionEvent
+}
+
+func newHAPublisher() *haPublisher {
+	return &haPublisher{off: map[string]*time.Timer{}, events: make(chan DetectionEvent, haQueueSize)}
+}
+
+// haLabels are the labels that get sensors.
+func (app *App) haLabels() []string {
+	if len(app.Config.HomeAssistant.Labels) == 0 {
+		return defaultHALabels
+	}
+	var labels []string
+	for _, l := range app.Config.HomeAssistant.Labels {
+		if l = strings.ToLower(strings.TrimSpace(l)); l != "" {
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// haOffDelay is how long a label's binary sensor stays on after a detection.
+func (app *App) haOffDelay(label string) time.Duration {
+	cfg := app.Config.HomeAssistant
+	if s := cfg.OffDelays[label]; s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if cfg.OffDelaySeconds > 0 {
+		return time.Duration(cfg.OffDelaySeconds) * time.Second
+	}
+	return defaultHAOffDelaySeconds * time.Second
+}
+
+// haEnabled reports whether there is something to publish to.
+func (app *App) haEnabled() bool {
+	return app.Config.HomeAssistant.Enabled && app.MQTT != nil
+}
+
+// startHomeAssistant announces the cameras once connected, and again
+// whenever Home Assistant restarts, and starts the worker publishing
+// queued detections.
+func (app *App) startHomeAssistant() {
+	if !app.Config.HomeAssistant.Enabled {
+		return
+	}
+	if app.MQTT == nil {
+		log.Printf("[HomeAssistant] home_assistant.enabled needs mqtt.broker; not publishing")
+		return
+	}
+	prefix := firstNonEmpty(app.Config.HomeAssistant.DiscoveryPrefix, defaultHADiscoveryPrefix)
+	app.MQTT.OnConnect(func() {
+		err := app.MQTT.Subscribe(prefix+"/status", func(payload []byte) {
+			if string(payload) == "online" {
+				go app.publishDiscovery()
+			}
+		})
+		if err != nil {
+			log.Printf("[HomeAssistant] Failed to subscribe to %s/status: %v", prefix, err)
+		}
+		app.publishDiscovery()
+	})
+	go app.runHomeAssistant()
+}
+
+// runHomeAssistant publishes queued detections in order.
+func (app *App) runHomeAssistant() {
+	for ev := range app.HA.events {
+		app.publishToHomeAssistant(ev)
+	}
+}
+
+// queueHomeAssistant hands a stored detection to the publishing worker.
+// It never blocks ingestion: with the queue full, the detection is dropped.
+func (app *App) queueHomeAssistant(ev DetectionEvent) {
+	if !app.haEnabled() {
+		return
+	}
+	select {
+	case app.HA.events <- ev:
+	default:
+		log.Printf("[HomeAssistant] Publish queue full, dropping event %d on %s", ev.ID, ev.CameraID)
+	}
+}
+
+// publishDiscovery publishes every camera's entity configs and current state.
+func (app *App) publishDiscovery() {
+	prefix := firstNonEmpty(app.Config.HomeAssistant.DiscoveryPrefix, defaultHADiscoveryPrefix)
+	availability := app.MQTT.Topic("status")
+	for _, cam := range app.Config.Cameras {
+		id := safeKeyPart(cam.ID)
+		node := "chatcam_" + id
+		device := map[string]interface{}{
+			"identifiers":  []string{node},
+			"name":         firstNonEmpty(cam.Name, cam.ID),
+			"manufacturer": "chat-with-my-camera",
+			"model":        firstNonEmpty(cam.Type, "camera"),
+		}
+		entity := func(component, object string, cfg map[string]interface{}) {
+			cfg["unique_id"] = node + "_" + object
+			cfg["availability_topic"] = availability
+			cfg["device"] = device
+			app.haPublish(fmt.Sprintf("%s/%s/%s/%s/config", prefix, component, node, object), cfg)
+		}
+
+		for _, label := range app.haLabels() {
+			l := safeKeyPart(label)
+			name := strings.ToUpper(label[:1]) + label[1:]
+			entity("binary_sensor", l, map[string]interface{}{
+				"name":         name + " detected",
+				"state_topic":  app.MQTT.Topic(id, l),
+				"payload_on":   "ON",
+				"payload_off":  "OFF",
+				"device_class": "occupancy",
+			})
+			entity("sensor", l+"_count", map[string]interface{}{
+				"name":        name + " count",
+				"state_topic": app.MQTT.Topic(id, l, "count"),
+				"state_class": "measurement",
+				"icon":        "mdi:counter",
+			})
+			if !app.haPending(cam.ID, label) {
+				app.haState(cam.ID, label, 0)
+			}
+		}
+		entity("sensor", "last_event", map[string]interface{}{
+			"name":                  "Last event",
+			"state_topic":           app.MQTT.Topic(id, "last_event"),
+			"value_template":        "{{ value_json.time }}",
+			"json_attributes_topic": app.MQTT.Topic(id, "last_event"),
+			"device_class":          "timestamp",
+		})
+		entity("camera", "snapshot", map[string]interface{}{
+			"name":  "Snapshot",
+			"topic": app.MQTT.Topic(id, "snapshot"),
+		})
+
+		// Last event and snapshot from before the (re)connection
+		ev, err := app.latestEvent(cam.ID)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("[HomeAssistant] Failed to load latest event of %s: %v", cam.ID, err)
+			}
+			continue
+		}
+		app.haLastEvent(ev)
+	}
+	log.Printf("[HomeAssistant] Published discovery for %d camera(s)", len(app.Config.Cameras))
+}
+
+// latestEvent is a camera's newest stored detection.
+func (app *App) latestEvent(cameraID string) (DetectionEvent, error) {
+	var id int64
+	var ts float64
+	var labels, boxes, confidences, snap, zones string
+	err := app.DB.QueryRow(`SELECT id, timestamp, labels, COALESCE(boxes, '[]'), COALESCE(confidences, '[]'), COALESCE(snapshot_file, ''), COALESCE(zones, '[]')
+		FROM detections WHERE camera_id = ? ORDER BY timestamp DESC LIMIT 1`, cameraID).
+		Scan(&id, &ts, &labels, &boxes, &confidences, &snap, &zones)
+	if err != nil {
+		return DetectionEvent{}, err
+	}
+	return parseDetectionEvent(id, ts, cameraID, labels, boxes, confidences, snap, zones), nil
+}
+
+// publishToHomeAssistant updates a camera's entities for a stored detection.
+func (app *App) publishToHomeAssistant(ev DetectionEvent) {
+	if !app.haEnabled() {
+		return
+	}
+	counts := map[string]int{}
+	for _, l := range ev.Labels {
+		counts[strings.ToLower(l)]++
+	}
+	for _, label := range app.haLabels() {
+		if counts[label] == 0 {
+			continue // turns off on its own timer
+		}
+		app.haState(ev.CameraID, label, counts[label])
+		app.haScheduleOff(ev.CameraID, label)
+	}
+	app.haLastEvent(ev)
+}
+
+// haState publishes a label's binary sensor (on when count > 0) and count.
+func (app *App) haState(cameraID, label string, count int) {
+	id, l := safeKeyPart(cameraID), safeKeyPart(label)
+	state := "OFF"
+	if count > 0 {
+		state = "ON"
+	}
+	if err := app.MQTT.Publish(app.MQTT.Topic(id, l), []byte(state), true); err != nil {
+		log.Printf("[HomeAssistant] Failed to publish %s on %s: %v", label, cameraID, err)
+		return
+	}
+	if err := app.MQTT.Publish(app.MQTT.Topic(id, l, "count"), []byte(fmt.Sprint(count)), true); err != nil {
+		log.Printf("[HomeAssistant] Failed to publish %s count on %s: %v", label, cameraID, err)
+	}
+}
+
+// haScheduleOff (re)starts the timer that turns a label off again.
+func (app *App) haScheduleOff(cameraID, label string) {
+	key := cameraID + "|" + label
+	app.HA.mu.Lock()
+	defer app.HA.mu.Unlock()
+	if t := app.HA.off[key]; t != nil {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(app.haOffDelay(label), func() {
+		app.HA.mu.Lock()
+		current := app.HA.off[key] == t
+		if current {
+			delete(app.HA.off, key)
+		}
+		app.HA.mu.Unlock()
+		if current {
+			app.haState(cameraID, label, 0)
+		}
+	})
+	app.HA.off[key] = t
+}
+
+// haPending reports whether a label is on and waiting for its off-delay.
+func (app *App) haPending(cameraID, label string) bool {
+	app.HA.mu.Lock()
+	defer app.HA.mu.Unlock()
+	return app.HA.off[cameraID+"|"+label] != nil
+}
+
+// haLastEvent publishes the last-event sensor and the snapshot.
+func (app *App) haLastEvent(ev DetectionEvent) {
+	id := safeKeyPart(ev.CameraID)
+	app.haPublish(app.MQTT.Topic(id, "last_event"), map[string]interface{}{
+		"time":         time.Unix(int64(ev.Timestamp), 0).In(app.Loc).Format(time.RFC3339),
+		"event_id":     ev.ID,
+		"labels":       ev.Labels,
+		"snapshot_url": snapshotURL(ev.SnapshotFile),
+	})
+
+	key, err := cleanSnapshotKey(ev.SnapshotFile)
+	if ev.SnapshotFile == "" || err != nil {
+		return
+	}
+	data, err := app.Snapshots.Get(context.Background(), key)
+	if err != nil {
+		log.Printf("[HomeAssistant] Failed to read snapshot %s: %v", key, err)
+		return
+	}
+	if err := app.MQTT.Publish(app.MQTT.Topic(id, "snapshot"), data, true); err != nil {
+		log.Printf("[HomeAssistant] Failed to publish snapshot of %s: %v", ev.CameraID, err)
+	}
+}
+
+// haPublish publishes v as retained JSON.
+func (app *App) haPublish(topic string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[HomeAssistant] Failed to encode %s: %v", topic, err)
+		return
+	}
+	if err := app.MQTT.Publish(topic, payload, true); err != nil {
+		log.Printf("[HomeAssistant] Failed to publish %s: %v", topic, err)
+	}
 }
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBroker is just enough of an MQTT 3.1.1 broker for the paho client:
+// it accepts connections, acks publishes and subscriptions, keeps retained
+// messages and forwards publishes to exact-topic subscribers.
+type testBroker struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	retained map[string][]byte
+	will     string // "topic=payload" from the last CONNECT
+	subs     map[string][]net.Conn
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &testBroker{ln: ln, retained: map[string][]byte{}, subs: map[string][]net.Conn{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(c)
+		}
+	}()
+	return b
+}
+
+func (b *testBroker) url() string { return "tcp://" + b.ln.Addr().String() }
+
+// get returns a retained message, and whether there is one.
+func (b *testBroker) get(topic string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.retained[topic]
+	return string(v), ok
+}
+
+// waitFor polls until topic has a retained message.
+func (b *testBroker) waitFor(t *testing.T, topic string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, ok := b.get(topic); ok {
+			return v
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("nothing published to %s", topic)
+	return ""
+}
+
+// publish sends a message to the subscribers of topic, as another client would.
+func (b *testBroker) publish(topic string, payload []byte) {
+	b.mu.Lock()
+	conns := append([]net.Conn{}, b.subs[topic]...)
+	b.mu.Unlock()
+	for _, c := range conns {
+		c.Write(mqttPacket(0x30, append(mqttString(topic), payload...)))
+	}
+}
+
+func (b *testBroker) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		body, err := readMQTTBody(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.connect(body)
+			c.Write([]byte{0x20, 2, 0, 0})
+		case 3: // PUBLISH
+			qos := (header >> 1) & 3
+			n := int(binary.BigEndian.Uint16(body))
+			topic, rest := string(body[2:2+n]), body[2+n:]
+			if qos > 0 {
+				c.Write(mqttPacket(0x40, rest[:2]))
+				rest = rest[2:]
+			}
+			if header&1 == 1 {
+				b.mu.Lock()
+				b.retained[topic] = append([]byte{}, rest...)
+				b.mu.Unlock()
+			}
+			b.publish(topic, rest)
+		case 8: // SUBSCRIBE
+			id, rest := body[:2], body[2:]
+			granted := []byte{}
+			for len(rest) > 2 {
+				n := int(binary.BigEndian.Uint16(rest))
+				topic := string(rest[2 : 2+n])
+				rest = rest[3+n:]
+				b.mu.Lock()
+				b.subs[topic] = append(b.subs[topic], c)
+				b.mu.Unlock()
+				granted = append(granted, 0)
+			}
+			c.Write(mqttPacket(0x90, append(id, granted...)))
+		case 12: // PINGREQ
+			c.Write([]byte{0xd0, 0})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// connect records the will of a CONNECT packet.
+func (b *testBroker) connect(body []byte) {
+	n := int(binary.BigEndian.Uint16(body))
+	flags := body[2+n+1]
+	rest := body[2+n+4:] // protocol name, level, flags, keep-alive
+	str := func() string {
+		n := int(binary.BigEndian.Uint16(rest))
+		s := string(rest[2 : 2+n])
+		rest = rest[2+n:]
+		return s
+	}
+	str() // client ID
+	if flags&0x04 != 0 {
+		topic := str()
+		b.mu.Lock()
+		b.will = topic + "=" + str()
+		b.mu.Unlock()
+	}
+}
+
+func readMQTTBody(r *bufio.Reader) ([]byte, error) {
+	length, mult := 0, 1
+	for {
+		d, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(d&0x7f) * mult
+		if d&0x80 == 0 {
+			break
+		}
+		mult *= 128
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	out := []byte{header}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		out = append(out, d)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func TestHomeAssistantDiscovery(t *testing.T) {
+	broker := newTestBroker(t)
+	app := newTestApp(t, &Config{
+		Cameras:       []CameraConfig{{ID: "garage cam", Name: "Garage", Type: "webcam"}},
+		HomeAssistant: HomeAssistantConfig{Enabled: true, Labels: []string{"Person", "car"}, OffDelaySeconds: 60},
+	})
+	snapshot := []byte{0xff, 0xd8, 0xff, 0xd9}
+	if err := app.Snapshots.Put(context.Background(), "garage_cam/2025-07-11/a.jpg", snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := insertDetection(1752224400, "garage cam", `["car"]`, `[]`, `[]`, "garage_cam/2025-07-11/a.jpg", "[]"); err != nil {
+		t.Fatal(err)
+	}
+
+	app.MQTT = newMQTTClient(MQTTConfig{Broker: broker.url(), ClientID: "test", TopicPrefix: "chatcam"})
+	t.Cleanup(func() { app.MQTT.client.Disconnect(0) })
+	app.startHomeAssistant()
+	broker.waitFor(t, "homeassistant/camera/chatcam_garage_cam/snapshot/config")
+
+	if status, _ := broker.get("chatcam/status"); status != "online" {
+		t.Errorf("chatcam/status = %q, want online", status)
+	}
+	broker.mu.Lock()
+	will := broker.will
+	broker.mu.Unlock()
+	if will != "chatcam/status=offline" {
+		t.Errorf("last will %q, want chatcam/status=offline", will)
+	}
+
+	configs := []struct {
+		topic string
+		want  map[string]string
+	}{
+		{"homeassistant/binary_sensor/chatcam_garage_cam/person/config", map[string]string{
+			"name": "Person detected", "state_topic": "chatcam/garage_cam/person", "unique_id": "chatcam_garage_cam_person",
+			"device_class": "occupancy", "availability_topic": "chatcam/status",
+		}},
+		{"homeassistant/sensor/chatcam_garage_cam/car_count/config", map[string]string{
+			"name": "Car count", "state_topic": "chatcam/garage_cam/car/count", "unique_id": "chatcam_garage_cam_car_count",
+		}},
+		{"homeassistant/sensor/chatcam_garage_cam/last_event/config", map[string]string{
+			"state_topic": "chatcam/garage_cam/last_event", "device_class": "timestamp",
+		}},
+		{"homeassistant/camera/chatcam_garage_cam/snapshot/config", map[string]string{
+			"topic": "chatcam/garage_cam/snapshot",
+		}},
+	}
+	for _, c := range configs {
+		raw, ok := broker.get(c.topic)
+		if !ok {
+			t.Errorf("no discovery config on %s", c.topic)
+			continue
+		}
+		var cfg map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+			t.Errorf("%s: %v", c.topic, err)
+			continue
+		}
+		for k, want := range c.want {
+			if got := fmt.Sprint(cfg[k]); got != want {
+				t.Errorf("%s: %s = %q, want %q", c.topic, k, got, want)
+			}
+		}
+		if device, _ := cfg["device"].(map[string]interface{}); device == nil || device["name"] != "Garage" {
+			t.Errorf("%s: device %v, want name Garage", c.topic, cfg["device"])
+		}
+	}
+
+	states := []struct{ topic, want string }{
+		{"chatcam/garage_cam/person", "OFF"},
+		{"chatcam/garage_cam/person/count", "0"},
+		{"chatcam/garage_cam/car", "OFF"},
+		{"chatcam/garage_cam/snapshot", string(snapshot)},
+	}
+	for _, s := range states {
+		if got := broker.waitFor(t, s.topic); got != s.want {
+			t.Errorf("%s = %q, want %q", s.topic, got, s.want)
+		}
+	}
+	if got := broker.waitFor(t, "chatcam/garage_cam/last_event"); !strings.Contains(got, `"time":"2025-07-11T09:00:00Z"`) {
+		t.Errorf("last_event = %s", got)
+	}
+
+	// A detection turns the sensors on.
+	app.publishToHomeAssistant(parseDetectionEvent(2, 1752224500, "garage cam", `["person","person","car"]`, "[]", "[]", "", "[]"))
+	for _, s := range []struct{ topic, want string }{
+		{"chatcam/garage_cam/person", "ON"},
+		{"chatcam/garage_cam/person/count", "2"},
+		{"chatcam/garage_cam/car/count", "1"},
+	} {
+		if got, _ := broker.get(s.topic); got != s.want {
+			t.Errorf("after a detection: %s = %q, want %q", s.topic, got, s.want)
+		}
+	}
+
+	// Queued detections are published in order: the retained last event is
+	// the newest one.
+	for id := int64(3); id <= 50; id++ {
+		app.queueHomeAssistant(parseDetectionEvent(id, 1752224500+float64(id), "garage cam", `["car"]`, "[]", "[]", "", "[]"))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, _ := broker.get("chatcam/garage_cam/last_event")
+		if strings.Contains(got, `"event_id":50`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last_event after queueing events 3-50 = %s", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond) // nothing older lands after it
+	if got, _ := broker.get("chatcam/garage_cam/last_event"); !strings.Contains(got, `"event_id":50`) {
+		t.Errorf("last_event went back to %s", got)
+	}
+	if got, _ := broker.get("chatcam/garage_cam/person"); got != "ON" {
+		t.Errorf("after car detections: person = %q, want ON until its off-delay", got)
+	}
+
+	// Home Assistant restarting gets the discovery configs again.
+	broker.mu.Lock()
+	delete(broker.retained, "homeassistant/camera/chatcam_garage_cam/snapshot/config")
+	broker.mu.Unlock()
+	broker.publish("homeassistant/status", []byte("online"))
+	broker.waitFor(t, "homeassistant/camera/chatcam_garage_cam/snapshot/config")
+	if got, _ := broker.get("chatcam/garage_cam/person"); got != "ON" {
+		t.Errorf("republishing reset a pending sensor: person = %q, want ON", got)
+	}
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
-	"os"
 	"time"
 )
 
 // runRetention runs in a loop: every hour it deletes events older than retention_days.
+// Rows covered by a legal hold (see holds.go) are skipped, along with their snapshots.
 func (app *App) runRetention() {
 	retentionDays := app.Config.RetentionDays
 	for {
@@ -15,8 +16,8 @@ func (app *App) runRetention() {
 		// Calculate cutoff timestamp
 		cutoff := time.Now().AddDate(0, 0, -retentionDays).Unix()
 
-		// Query old rows to get snapshot filenames
-		rows, err := app.DB.Query("SELECT snapshot_file FROM detections WHERE timestamp < ?", cutoff)
+		// Query old, un-held rows to get snapshot filenames
+		rows, err := app.DB.Query("SELECT snapshot_file FROM detections WHERE timestamp < ? AND "+notHeldClause, cutoff)
 		if err != nil {
 			log.Printf("Retention query failed: %v", err)
 			time.Sleep(time.Hour)
@@ -24,25 +25,18 @@ func (app *App) runRetention() {
 		}
 
 		var snapshotsToDelete []string
+		seen := make(map[string]bool)
 		for rows.Next() {
 			var snap string
-			if err := rows.Scan(&snap); err == nil && snap != "" {
+			if err := rows.Scan(&snap); err == nil && snap != "" && !seen[snap] {
+				seen[snap] = true
 				snapshotsToDelete = append(snapshotsToDelete, snap)
 			}
 		}
 		rows.Close()
 
-		// Delete snapshots from disk
-		for _, snap := range snapshotsToDelete {
-			if err := os.Remove(snap); err != nil {
-				log.Printf("Failed to remove snapshot: %s (%v)", snap, err)
-			} else {
-				log.Printf("Deleted snapshot: %s", snap)
-			}
-		}
-
-		// Delete old rows from DB
-		res, err := app.DB.Exec("DELETE FROM detections WHERE timestamp < ?", cutoff)
+		// Delete old, un-held rows from DB
+		res, err := app.DB.Exec("DELETE FROM detections WHERE timestamp < ? AND "+notHeldClause, cutoff)
 		if err != nil {
 			log.Printf("Retention delete failed: %v", err)
 		} else {
@@ -50,6 +44,72 @@ func (app *App) runRetention() {
 			log.Printf("[Retention] Deleted %d rows older than cutoff", affected)
 		}
 
+		// Drop semantic search entries of deleted events (see embeddings.go).
+		if n, err := pruneEmbeddings(app.DB); err != nil {
+			log.Printf("Retention embeddings cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[Retention] Deleted %d embeddings", n)
+		}
+
+		// Tripwire crossings, after folding them into the occupancy snapshot (see crossings.go).
+		if n, err := app.pruneCrossings(float64(cutoff)); err != nil {
+			log.Printf("Retention crossings cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[Retention] Deleted %d crossings", n)
+		}
+
+		// Chat conversations nobody has continued within the retention window.
+		if n, err := pruneConversations(app.DB, cutoff); err != nil {
+			log.Printf("Retention conversations cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[Retention] Deleted %d conversations", n)
+		}
+
+		// Rule firing log, except for firings on held detections.
+		if n, err := pruneRuleFirings(app.DB, cutoff); err != nil {
+			log.Printf("Retention rule firings cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[Retention] Deleted %d rule firings", n)
+		}
+
+		// Loitering events, which point at the snapshots deleted below.
+		if n, err := pruneDwellEvents(app.DB, cutoff); err != nil {
+			log.Printf("Retention loitering events cleanup failed: %v", err)
+		} else if n > 0 {
+			log.Printf("[Retention] Deleted %d loitering events", n)
+		}
+
+		// Webhook delivery log; pending deliveries are kept until they finish (see webhooks.go).
+		if res, err := app.DB.Exec("DELETE FROM webhook_deliveries WHERE created_at < ? AND status != 'pending'", cutoff); err != nil {
+			log.Printf("Retention webhook log cleanup failed: %v", err)
+		} else if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[Retention] Deleted %d webhook deliveries", n)
+		}
+
+		// Delete snapshots from the snapshot store, unless a remaining
+		// (newer or held) row still shares the same content-addressed file.
+		for _, snap := range snapshotsToDelete {
+			key, err := cleanSnapshotKey(snap)
+			if err == nil {
+				var inUse bool
+				inUse, err = snapshotInUse(app.DB, key)
+				if err == nil && inUse {
+					continue
+				}
+			}
+			if err == nil {
+				err = app.Snapshots.Delete(context.Background(), key)
+			}
+			if err == nil {
+				app.Thumbs.Purge(key)
+			}
+			if err != nil {
+				log.Printf("Failed to remove snapshot: %s (%v)", snap, err)
+			} else {
+				log.Printf("Deleted snapshot: %s", snap)
+			}
+		}
+
 		// Sleep until next run
 		time.Sleep(time.Hour)
 	}
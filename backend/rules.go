@@ -0,0 +1,855 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+rules.go
+--------
+
+Rules turn ingested detections into actions. All of a rule's conditions
+must hold for a detection to fire it:
+
+	rules:
+	  - name: person at night
+	    on: detection                 # or loitering: events from dwell alerts (dwell.go)
+	    cameras: [outside]            # camera IDs or camera_groups; empty = all
+	    labels: [person]              # any of these; empty = any label
+	    zones: [driveway]             # only objects in one of these zones count (zones.go)
+	    min_confidence: 0.6           # objects below it don't count
+	    min_count: 1                  # matching objects in the detection, default 1
+	    max_count: 0                  # 0 = no upper bound
+	    schedule:                     # local time; from > to wraps midnight
+	      days: [mon, tue, wed, thu, fri]
+	      from: "22:00"
+	      to: "06:00"
+	    cooldown_seconds: 300         # per camera
+	    actions:
+	      - {type: webhook, webhook: node-red}                   # queues the firing for a webhooks endpoint (webhooks.go)
+	      - {type: webhook, url: "http://nodered:1880/person"}   # or for a plain URL, unsigned
+	      - {type: mqtt, topic: alerts/person}                   # under mqtt.topic_prefix, default rules/<name>
+	      - {type: notify, channels: [household]}                # notification channels (notify.go)
+	      - {type: flag, flag: intruder}                         # recorded with the firing
+
+Rules from config.yaml are read-only; more can be managed through /rules
+and are kept in the rules table. Every firing is logged in rule_firings
+(GET /rules/firings). POST /rules/test evaluates a rule against stored
+detections without running its actions.
+*/
+
+// Rule is one detection-triggered rule.
+type Rule struct {
+	ID     int64  `yaml:"-" json:"id"`     // 0 for rules from config.yaml
+	Source string `yaml:"-" json:"source"` // config or api
+
+	Name            string        `yaml:"name" json:"name"`
+	Disabled        bool          `yaml:"disabled" json:"disabled"`
+	On              string        `yaml:"on" json:"on,omitempty"` // detection (default) or loitering
+	Cameras         []string      `yaml:"cameras" json:"cameras"`
+	Labels          []string      `yaml:"labels" json:"labels"`
+	Zones           []string      `yaml:"zones" json:"zones"`
+	MinConfidence   float64       `yaml:"min_confidence" json:"min_confidence"`
+	MinCount        int           `yaml:"min_count" json:"min_count"`
+	MaxCount        int           `yaml:"max_count" json:"max_count"`
+	Schedule        *RuleSchedule `yaml:"schedule" json:"schedule,omitempty"`
+	CooldownSeconds int           `yaml:"cooldown_seconds" json:"cooldown_seconds"`
+	Actions         []RuleAction  `yaml:"actions" json:"actions"`
+}
+
+// RuleSchedule limits a rule to a daily time window.
+type RuleSchedule struct {
+	Days []string `yaml:"days" json:"days,omitempty"` // mon ... sun; empty = every day
+	From string   `yaml:"from" json:"from,omitempty"` // HH:MM, default 00:00
+	To   string   `yaml:"to" json:"to,omitempty"`     // HH:MM, default 24:00
+}
+
+// RuleAction is what a firing rule does.
+type RuleAction struct {
+	Type     string   `yaml:"type" json:"type"` // webhook, mqtt, notify or flag
+	URL      string   `yaml:"url,omitempty" json:"url,omitempty"`
+	Webhook  string   `yaml:"webhook,omitempty" json:"webhook,omitempty"` // endpoint name, instead of url
+	Topic    string   `yaml:"topic,omitempty" json:"topic,omitempty"`
+	Channels []string `yaml:"channels,omitempty" json:"channels,omitempty"`
+	Flag     string   `yaml:"flag,omitempty" json:"flag,omitempty"`
+}
+
+// DetectionEvent is a stored detection as rules see it, or a loitering
+// event raised on one (dwell.go).
+type DetectionEvent struct {
+	ID           int64
+	Kind         string // "" for a detection, or loitering
+	DwellSeconds float64
+	Timestamp    float64
+	CameraID     string
+	Labels       []string
+	Boxes        [][]float64
+	Confidences  []float64
+	SnapshotFile string
+	Zones        [][]string // per object, nil when the camera has no zones (zones.go)
+}
+
+// RuleFiring is one logged firing, as served by /rules/firings and sent
+// to webhooks and MQTT.
+type RuleFiring struct {
+	ID          int64          `json:"id"`
+	RuleID      int64          `json:"rule_id,omitempty"`
+	Rule        string         `json:"rule"`
+	Kind        string         `json:"kind"` // detection or loitering
+	EventID     int64          `json:"event_id"`
+	CameraID    string         `json:"camera_id"`
+	Time        string         `json:"time"`
+	Timestamp   float64        `json:"timestamp"`
+	Labels      []string       `json:"labels"`
+	Count       int            `json:"count"` // objects that matched
+	Dwell       float64        `json:"dwell_seconds,omitempty"`
+	SnapshotURL string         `json:"snapshot_url,omitempty"`
+	Flags       []string       `json:"flags"`
+	Actions     []ActionResult `json:"actions"`
+}
+
+// ActionResult is the outcome of one action of a firing.
+type ActionResult struct {
+	Type  string `json:"type"`
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	reRuleClock = regexp.MustCompile(`^([01]?\d|2[0-4]):([0-5]\d)$`)
+	ruleDays    = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"} // time.Weekday order
+)
+
+// ruleEngine holds the rules stored through the API and the cooldowns.
+type ruleEngine struct {
+	mu        sync.Mutex
+	loaded    bool
+	stored    []Rule
+	lastFired map[string]float64 // rule + camera → timestamp of the last firing
+}
+
+func newRuleEngine() *ruleEngine {
+	return &ruleEngine{lastFired: map[string]float64{}}
+}
+
+// parseRuleClock turns "HH:MM" into minutes past midnight.
+func parseRuleClock(s string) (int, error) {
+	m := reRuleClock.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	if h == 24 && min > 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + min, nil
+}
+
+// validateRule checks a rule and normalises its labels and days.
+func validateRule(r *Rule) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("rule without a name")
+	}
+	switch r.On {
+	case "", "detection", "loitering":
+	default:
+		return fmt.Errorf("rule %q: on must be detection or loitering", r.Name)
+	}
+	if r.MinConfidence < 0 || r.MinConfidence > 1 {
+		return fmt.Errorf("rule %q: min_confidence must be between 0 and 1", r.Name)
+	}
+	if r.MinCount < 0 || r.MaxCount < 0 || (r.MaxCount > 0 && r.MaxCount < r.MinCount) {
+		return fmt.Errorf("rule %q: invalid min_count/max_count", r.Name)
+	}
+	if r.CooldownSeconds < 0 {
+		return fmt.Errorf("rule %q: cooldown_seconds must not be negative", r.Name)
+	}
+	for i, l := range r.Labels {
+		r.Labels[i] = strings.ToLower(strings.TrimSpace(l))
+	}
+	if r.Schedule != nil {
+		if err := validateSchedule(r.Schedule); err != nil {
+			return fmt.Errorf("rule %q: schedule: %w", r.Name, err)
+		}
+	}
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("rule %q: no actions", r.Name)
+	}
+	for _, a := range r.Actions {
+		switch a.Type {
+		case "webhook":
+			if (a.URL == "") == (a.Webhook == "") {
+				return fmt.Errorf("rule %q: webhook needs either a url or a webhook name", r.Name)
+			}
+			if a.URL != "" && !strings.HasPrefix(a.URL, "http://") && !strings.HasPrefix(a.URL, "https://") {
+				return fmt.Errorf("rule %q: webhook needs an http(s) url", r.Name)
+			}
+		case "mqtt":
+		case "notify":
+			if len(a.Channels) == 0 {
+				return fmt.Errorf("rule %q: notify needs channels", r.Name)
+			}
+		case "flag":
+			if a.Flag == "" {
+				return fmt.Errorf("rule %q: flag action needs a flag", r.Name)
+			}
+		default:
+			return fmt.Errorf("rule %q: unknown action type %q", r.Name, a.Type)
+		}
+	}
+	return nil
+}
+
+// checkRuleTargets checks that the webhooks and notification channels a
+// rule's actions name exist. validateRule can't, it has no App.
+func (app *App) checkRuleTargets(r Rule) error {
+	for _, a := range r.Actions {
+		if a.Type == "webhook" && a.Webhook != "" {
+			if _, err := app.webhookByName(a.Webhook); err != nil {
+				return fmt.Errorf("rule %q: %w", r.Name, err)
+			}
+		}
+		if a.Type == "notify" {
+			for _, c := range a.Channels {
+				if _, ok := app.Notifiers[c]; !ok {
+					return fmt.Errorf("rule %q: no notification channel named %q", r.Name, c)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateSchedule checks a schedule's times and shortens its days to
+// "mon", "tue", ... Notification quiet hours (notify.go) use it too.
+func validateSchedule(s *RuleSchedule) error {
+	for i, d := range s.Days {
+		s.Days[i] = strings.ToLower(d)
+		if len(s.Days[i]) > 3 {
+			s.Days[i] = s.Days[i][:3]
+		}
+		if !containsFold(ruleDays, s.Days[i]) {
+			return fmt.Errorf("unknown day %q", d)
+		}
+	}
+	for _, t := range []string{s.From, s.To} {
+		if t == "" {
+			continue
+		}
+		if _, err := parseRuleClock(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inSchedule reports whether t falls in the schedule's window. A window
+// that wraps midnight belongs to the day it starts on.
+func (s *RuleSchedule) inSchedule(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	from, _ := parseRuleClock(firstNonEmpty(s.From, "00:00"))
+	to, _ := parseRuleClock(firstNonEmpty(s.To, "24:00"))
+	onDay := func(d time.Time) bool {
+		return len(s.Days) == 0 || containsFold(s.Days, ruleDays[d.Weekday()])
+	}
+	tod := t.Hour()*60 + t.Minute()
+	if from <= to {
+		return onDay(t) && tod >= from && tod < to
+	}
+	return (tod >= from && onDay(t)) || (tod < to && onDay(t.AddDate(0, 0, -1)))
+}
+
+// matchRule checks a rule's conditions (not its cooldown) against ev. It
+// returns the number of matching objects, or why the rule didn't match.
+func (app *App) matchRule(r Rule, ev DetectionEvent) (int, string) {
+	if r.Disabled {
+		return 0, "disabled"
+	}
+	if (r.On == "loitering") != (ev.Kind == "loitering") {
+		return 0, "event"
+	}
+	if len(r.Cameras) > 0 && !containsFold(app.expandCameras(r.Cameras), ev.CameraID) {
+		return 0, "camera"
+	}
+	if !r.Schedule.inSchedule(time.Unix(int64(ev.Timestamp), 0).In(app.Loc)) {
+		return 0, "schedule"
+	}
+	count := 0
+	for i, l := range ev.Labels {
+		if len(r.Labels) > 0 && !containsFold(r.Labels, l) {
+			continue
+		}
+		// Detections stored before confidences were recorded always pass.
+		if i < len(ev.Confidences) && ev.Confidences[i] < r.MinConfidence {
+			continue
+		}
+		if len(r.Zones) > 0 && (i >= len(ev.Zones) || !anyIn(ev.Zones[i], r.Zones)) {
+			continue
+		}
+		count++
+	}
+	if count == 0 {
+		return 0, "labels"
+	}
+	if count < max(r.MinCount, 1) || (r.MaxCount > 0 && count > r.MaxCount) {
+		return count, "count"
+	}
+	return count, ""
+}
+
+// anyIn reports whether any of vals is in list.
+func anyIn(vals, list []string) bool {
+	for _, v := range vals {
+		if containsFold(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleKey identifies a rule for cooldowns.
+func ruleKey(r Rule) string {
+	if r.Source == "api" {
+		return fmt.Sprintf("api:%d", r.ID)
+	}
+	return "config:" + r.Name
+}
+
+// claim records a firing at ts unless the rule is cooling down on that camera.
+func (e *ruleEngine) claim(key string, ts float64, cooldown int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if last, ok := e.lastFired[key]; ok && ts-last < float64(cooldown) {
+		return false
+	}
+	e.lastFired[key] = ts
+	return true
+}
+
+// allRules returns the rules from config.yaml followed by the stored ones.
+func (app *App) allRules() ([]Rule, error) {
+	e := app.Rules
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.loaded {
+		stored, err := loadStoredRules(app.DB)
+		if err != nil {
+			return nil, err
+		}
+		e.stored, e.loaded = stored, true
+	}
+	rules := make([]Rule, 0, len(app.Config.Rules)+len(e.stored))
+	for _, r := range app.Config.Rules {
+		r.Source = "config"
+		rules = append(rules, r)
+	}
+	return append(rules, e.stored...), nil
+}
+
+// loadStoredRules reads the rules table.
+func loadStoredRules(d *sql.DB) ([]Rule, error) {
+	rows, err := d.Query("SELECT id, definition FROM rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []Rule{}
+	for rows.Next() {
+		var id int64
+		var def string
+		if err := rows.Scan(&id, &def); err != nil {
+			return nil, err
+		}
+		var r Rule
+		if err := json.Unmarshal([]byte(def), &r); err != nil {
+			log.Printf("[Rules] Skipping unreadable rule %d: %v", id, err)
+			continue
+		}
+		r.ID, r.Source = id, "api"
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// reloadRules makes the next allRules re-read the rules table.
+func (app *App) reloadRules() {
+	app.Rules.mu.Lock()
+	app.Rules.loaded = false
+	app.Rules.mu.Unlock()
+}
+
+// evaluateRules fires every rule that matches a newly stored detection.
+// Actions run in the background so ingest isn't held up.
+func (app *App) evaluateRules(ev DetectionEvent) {
+	rules, err := app.allRules()
+	if err != nil {
+		log.Printf("[Rules] Failed to load rules: %v", err)
+		return
+	}
+	for _, r := range rules {
+		count, reason := app.matchRule(r, ev)
+		if reason != "" {
+			continue
+		}
+		if !app.Rules.claim(ruleKey(r)+"|"+ev.CameraID, ev.Timestamp, r.CooldownSeconds) {
+			continue
+		}
+		go app.fireRule(context.Background(), r, ev, count)
+	}
+}
+
+// fireRule logs a firing and runs the rule's actions.
+func (app *App) fireRule(ctx context.Context, r Rule, ev DetectionEvent, count int) {
+	f := RuleFiring{
+		RuleID:      r.ID,
+		Rule:        r.Name,
+		Kind:        firstNonEmpty(ev.Kind, "detection"),
+		EventID:     ev.ID,
+		CameraID:    ev.CameraID,
+		Time:        time.Unix(int64(ev.Timestamp), 0).In(app.Loc).Format(time.RFC3339),
+		Timestamp:   ev.Timestamp,
+		Labels:      ev.Labels,
+		Count:       count,
+		Dwell:       ev.DwellSeconds,
+		SnapshotURL: snapshotURL(ev.SnapshotFile),
+		Flags:       []string{},
+		Actions:     []ActionResult{},
+	}
+	for _, a := range r.Actions {
+		if a.Type == "flag" {
+			f.Flags = append(f.Flags, a.Flag)
+		}
+	}
+	labels, _ := json.Marshal(f.Labels)
+	flags, _ := json.Marshal(f.Flags)
+	res, err := app.DB.Exec(`
+		INSERT INTO rule_firings (rule_id, rule, kind, event_id, camera_id, timestamp, labels, count, dwell_seconds, flags, actions, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '[]', ?)`,
+		f.RuleID, f.Rule, f.Kind, f.EventID, f.CameraID, f.Timestamp, string(labels), f.Count, f.Dwell, string(flags), float64(time.Now().Unix()))
+	if err != nil {
+		log.Printf("[Rules] Failed to log firing of %q: %v", r.Name, err)
+	} else {
+		f.ID, _ = res.LastInsertId()
+	}
+	log.Printf("[Rules] %q fired on event %d (%s)", r.Name, ev.ID, ev.CameraID)
+
+	for _, a := range r.Actions {
+		result := ActionResult{Type: a.Type}
+		if err := app.runAction(ctx, r, a, f); err != nil {
+			result.Error = err.Error()
+			log.Printf("[Rules] %q: %s action failed: %v", r.Name, a.Type, err)
+		}
+		f.Actions = append(f.Actions, result)
+	}
+	if f.ID > 0 {
+		actions, _ := json.Marshal(f.Actions)
+		if _, err := app.DB.Exec("UPDATE rule_firings SET actions = ? WHERE id = ?", string(actions), f.ID); err != nil {
+			log.Printf("[Rules] Failed to record actions of firing %d: %v", f.ID, err)
+		}
+	}
+	// Endpoints a webhook action named have their copy already.
+	targeted := map[string]bool{}
+	for _, a := range r.Actions {
+		if a.Type == "webhook" && a.Webhook != "" {
+			targeted[a.Webhook] = true
+		}
+	}
+	app.queueWebhooksExcept("rule_firing", f.CameraID, f.Labels, f, targeted)
+}
+
+// runAction performs one action for a firing.
+func (app *App) runAction(ctx context.Context, r Rule, a RuleAction, f RuleFiring) error {
+	switch a.Type {
+	case "webhook":
+		// Queued like the webhooks.go events, so it is retried, logged in
+		// /webhooks/deliveries and signed when the endpoint has a secret.
+		key, name := "url:"+a.URL, "rule "+r.Name
+		if a.Webhook != "" {
+			h, err := app.webhookByName(a.Webhook)
+			if err != nil {
+				return err
+			}
+			key, name = webhookKey(h), h.Name
+		}
+		payload, err := app.webhookPayload("rule_firing", f)
+		if err != nil {
+			return err
+		}
+		if _, err := app.queueDelivery(key, name, "rule_firing", f.CameraID, payload); err != nil {
+			return fmt.Errorf("queue webhook: %w", err)
+		}
+		app.wakeWebhooks()
+		return nil
+
+	case "mqtt":
+		if app.MQTT == nil {
+			return fmt.Errorf("mqtt.broker is not configured")
+		}
+		topic := a.Topic
+		if topic == "" {
+			topic = "rules/" + ruleSlug(r.Name)
+		}
+		body, _ := json.Marshal(f)
+		return app.MQTT.Publish(app.MQTT.Topic(topic), body, false)
+
+	case "notify":
+		body := fmt.Sprintf("%s on %s at %s", strings.Join(f.Labels, ", "), app.cameraLabel(f.CameraID), f.Time)
+		if f.Kind == "loitering" {
+			body += " (there for " + formatDwell(f.Dwell) + ")"
+		}
+		n := Notification{
+			Title:    r.Name,
+			Body:     body,
+			URL:      f.SnapshotURL,
+			Snapshot: strings.TrimPrefix(f.SnapshotURL, "/snapshots/"),
+			Camera:   app.cameraLabel(f.CameraID),
+			Labels:   f.Labels,
+			Time:     time.Unix(int64(f.Timestamp), 0).In(app.Loc),
+		}
+		return app.notify(ctx, a.Channels, n)
+
+	case "flag":
+		return nil // stored with the firing
+	}
+	return fmt.Errorf("unknown action type %q", a.Type)
+}
+
+// ruleSlug turns a rule name into a topic segment: "Person at night" → person_at_night.
+func ruleSlug(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}), "_")
+}
+
+// parseDetectionEvent decodes the JSON columns of a detections row.
+func parseDetectionEvent(id int64, ts float64, camera, labels, boxes, confidences, snapshot, zones string) DetectionEvent {
+	ev := DetectionEvent{ID: id, Timestamp: ts, CameraID: camera, SnapshotFile: snapshot}
+	json.Unmarshal([]byte(labels), &ev.Labels)
+	json.Unmarshal([]byte(boxes), &ev.Boxes)
+	json.Unmarshal([]byte(confidences), &ev.Confidences)
+	if zones != "" && zones != "[]" {
+		json.Unmarshal([]byte(zones), &ev.Zones)
+	}
+	return ev
+}
+
+// handleRules handles /rules:
+//
+//	GET    /rules          → all rules (config.yaml ones have "source": "config")
+//	POST   /rules          → add a rule
+//	PUT    /rules?id=...   → replace a stored rule
+//	DELETE /rules?id=...   → delete a stored rule
+func (app *App) handleRules(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+
+	case http.MethodGet:
+		rules, err := app.allRules()
+		if err != nil {
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			log.Printf("List rules failed: %v", err)
+			return
+		}
+		json.NewEncoder(w).Encode(rules)
+
+	case http.MethodPost, http.MethodPut:
+		var rule Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := validateRule(&rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := app.checkRuleTargets(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rule.Source = "api"
+		def, _ := json.Marshal(rule)
+		now := float64(time.Now().Unix())
+
+		if r.Method == http.MethodPost {
+			res, err := app.DB.Exec("INSERT INTO rules (definition, created_at, updated_at) VALUES (?, ?, ?)", string(def), now, now)
+			if err != nil {
+				http.Error(w, "Insert failed", http.StatusInternalServerError)
+				log.Printf("Insert rule failed: %v", err)
+				return
+			}
+			rule.ID, _ = res.LastInsertId()
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+				return
+			}
+			res, err := app.DB.Exec("UPDATE rules SET definition = ?, updated_at = ? WHERE id = ?", string(def), now, id)
+			if err != nil {
+				http.Error(w, "Update failed", http.StatusInternalServerError)
+				log.Printf("Update rule failed: %v", err)
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				http.Error(w, "Rule not found", http.StatusNotFound)
+				return
+			}
+			rule.ID = id
+		}
+		app.reloadRules()
+		json.NewEncoder(w).Encode(rule)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+			return
+		}
+		res, err := app.DB.Exec("DELETE FROM rules WHERE id = ?", id)
+		if err != nil {
+			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Delete rule failed: %v", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+		app.reloadRules()
+		json.NewEncoder(w).Encode(map[string]interface{}{"deleted": id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ruleTestResult is one matching detection in a /rules/test response.
+type ruleTestResult struct {
+	EventID  int64    `json:"event_id"`
+	Time     string   `json:"time"`
+	CameraID string   `json:"camera_id"`
+	Labels   []string `json:"labels"`
+	Count    int      `json:"count"`
+	Fired    bool     `json:"fired"` // false: suppressed by the cooldown
+}
+
+// handleRuleTest handles POST /rules/test, a dry run of a rule against
+// stored detections (loitering events for on: loitering). Body:
+// { "rule": {...} } or { "rule_id": 3 } or { "name": "..." }, plus optional
+// start_time/end_time (Unix seconds, default the last 24 hours) and limit
+// (events checked, default 1000).
+// No actions are run and live cooldowns are left alone.
+func (app *App) handleRuleTest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Rule      *Rule   `json:"rule"`
+		RuleID    int64   `json:"rule_id"`
+		Name      string  `json:"name"`
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+		Limit     int     `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	rule := req.Rule
+	if rule == nil {
+		rules, err := app.allRules()
+		if err != nil {
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			log.Printf("List rules failed: %v", err)
+			return
+		}
+		for i := range rules {
+			if (req.RuleID > 0 && rules[i].ID == req.RuleID) || (req.RuleID == 0 && req.Name != "" && rules[i].Name == req.Name) {
+				rule = &rules[i]
+				break
+			}
+		}
+		if rule == nil {
+			http.Error(w, "Rule not found", http.StatusNotFound)
+			return
+		}
+	} else if err := validateRule(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	test := *rule
+	test.Disabled = false // a dry run of a disabled rule still shows what it would do
+
+	if req.EndTime <= 0 {
+		req.EndTime = float64(app.Now().Unix())
+	}
+	if req.StartTime <= 0 {
+		req.StartTime = req.EndTime - 24*3600
+	}
+	if req.Limit <= 0 || req.Limit > 10000 {
+		req.Limit = 1000
+	}
+
+	query := `
+		SELECT id, timestamp, camera_id, labels, COALESCE(boxes, '[]'), COALESCE(confidences, '[]'), COALESCE(snapshot_file, ''), COALESCE(zones, '[]'), 0
+		FROM detections WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC LIMIT ?`
+	kind := ""
+	if test.On == "loitering" {
+		// Loitering events, shaped like detections of their one label (dwell.go).
+		query = `
+		SELECT event_id, timestamp, camera_id, '["' || label || '"]', '[]', '[]', COALESCE(snapshot_file, ''),
+			CASE WHEN COALESCE(zones, '') = '' THEN '[]' ELSE '[["' || REPLACE(zones, ',', '","') || '"]]' END, dwell_seconds
+		FROM dwell_events WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC LIMIT ?`
+		kind = "loitering"
+	}
+	rows, err := app.DB.QueryContext(r.Context(), query, req.StartTime, req.EndTime, req.Limit)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("Rule test query failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	cooldowns := newRuleEngine()
+	results := []ruleTestResult{}
+	checked, fired := 0, 0
+	for rows.Next() {
+		var id int64
+		var ts float64
+		var dwell float64
+		var camera, labels, boxes, confidences, snap, zones string
+		if err := rows.Scan(&id, &ts, &camera, &labels, &boxes, &confidences, &snap, &zones, &dwell); err != nil {
+			log.Printf("Rule test row scan failed: %v", err)
+			continue
+		}
+		checked++
+		ev := parseDetectionEvent(id, ts, camera, labels, boxes, confidences, snap, zones)
+		ev.Kind, ev.DwellSeconds = kind, dwell
+		count, reason := app.matchRule(test, ev)
+		if reason != "" {
+			continue
+		}
+		res := ruleTestResult{
+			EventID:  ev.ID,
+			Time:     time.Unix(int64(ev.Timestamp), 0).In(app.Loc).Format(time.RFC3339),
+			CameraID: ev.CameraID,
+			Labels:   ev.Labels,
+			Count:    count,
+			Fired:    cooldowns.claim(ev.CameraID, ev.Timestamp, test.CooldownSeconds),
+		}
+		if res.Fired {
+			fired++
+		}
+		results = append(results, res)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rule":    test,
+		"checked": checked,
+		"matched": len(results),
+		"fired":   fired,
+		"results": results,
+	})
+}
+
+// pruneRuleFirings deletes firings from before cutoff whose detection is
+// gone, so ones on held detections (see holds.go) stay with them.
+func pruneRuleFirings(d *sql.DB, cutoff int64) (int64, error) {
+	res, err := d.Exec(`DELETE FROM rule_firings WHERE timestamp < ?
+		AND NOT EXISTS (SELECT 1 FROM detections WHERE detections.id = rule_firings.event_id)`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// handleRuleFirings handles GET /rules/firings?rule=&rule_id=&camera_id=&flag=&limit=,
+// newest first.
+func (app *App) handleRuleFirings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	query := `SELECT id, COALESCE(rule_id, 0), rule, COALESCE(kind, 'detection'), event_id, camera_id, timestamp, labels, count, COALESCE(dwell_seconds, 0), flags, actions
+	          FROM rule_firings WHERE 1=1`
+	var args []interface{}
+	if v := q.Get("rule"); v != "" {
+		query += " AND rule = ?"
+		args = append(args, v)
+	}
+	if v := q.Get("rule_id"); v != "" {
+		query += " AND rule_id = ?"
+		args = append(args, v)
+	}
+	if v := q.Get("camera_id"); v != "" {
+		query += " AND camera_id = ?"
+		args = append(args, v)
+	}
+	if v := q.Get("flag"); v != "" {
+		query += " AND flags LIKE ?"
+		args = append(args, `%"`+v+`"%`)
+	}
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("List rule firings failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	firings := []RuleFiring{}
+	for rows.Next() {
+		var f RuleFiring
+		var labels, flags, actions string
+		if err := rows.Scan(&f.ID, &f.RuleID, &f.Rule, &f.Kind, &f.EventID, &f.CameraID, &f.Timestamp, &labels, &f.Count, &f.Dwell, &flags, &actions); err != nil {
+			log.Printf("Rule firing row scan failed: %v", err)
+			continue
+		}
+		f.Time = time.Unix(int64(f.Timestamp), 0).In(app.Loc).Format(time.RFC3339)
+		json.Unmarshal([]byte(labels), &f.Labels)
+		json.Unmarshal([]byte(flags), &f.Flags)
+		json.Unmarshal([]byte(actions), &f.Actions)
+		firings = append(firings, f)
+	}
+	json.NewEncoder(w).Encode(firings)
+}
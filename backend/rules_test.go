@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInSchedule(t *testing.T) {
+	at := func(day, hour, min int) time.Time { return time.Date(2025, 7, day, hour, min, 0, 0, time.UTC) } // 11 July is a Friday
+	night := &RuleSchedule{Days: []string{"Friday"}, From: "22:00", To: "06:00"}
+	if err := validateSchedule(night); err != nil {
+		t.Fatal(err)
+	}
+	work := &RuleSchedule{From: "09:00", To: "17:00"}
+	evening := &RuleSchedule{From: "18:00"}
+	late := &RuleSchedule{From: "20:00", To: "24:00"}
+	weekend := &RuleSchedule{Days: []string{"sat", "sun"}}
+
+	tests := []struct {
+		name  string
+		sched *RuleSchedule
+		t     time.Time
+		want  bool
+	}{
+		{"no schedule", nil, at(11, 3, 0), true},
+		{"friday night", night, at(11, 23, 0), true},
+		{"start is inclusive", night, at(11, 22, 0), true},
+		{"saturday small hours belong to friday", night, at(12, 2, 0), true},
+		{"end is exclusive", night, at(12, 6, 0), false},
+		{"friday small hours belong to thursday", night, at(11, 2, 0), false},
+		{"saturday night", night, at(12, 23, 0), false},
+		{"working hours", work, at(12, 12, 0), true},
+		{"before work", work, at(12, 8, 59), false},
+		{"after work", work, at(12, 17, 0), false},
+		{"open end", evening, at(11, 23, 59), true},
+		{"before an open end", evening, at(11, 17, 59), false},
+		{"until midnight", late, at(11, 23, 30), true},
+		{"sunday", weekend, at(13, 10, 0), true},
+		{"monday", weekend, at(14, 10, 0), false},
+	}
+	for _, tt := range tests {
+		if got := tt.sched.inSchedule(tt.t); got != tt.want {
+			t.Errorf("%s: inSchedule(%s) = %v, want %v", tt.name, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		sched RuleSchedule
+		ok    bool
+	}{
+		{RuleSchedule{Days: []string{"Monday", "tue"}, From: "7:30", To: "24:00"}, true},
+		{RuleSchedule{Days: []string{"someday"}}, false},
+		{RuleSchedule{From: "25:00"}, false},
+		{RuleSchedule{To: "24:30"}, false},
+		{RuleSchedule{From: "noon"}, false},
+	}
+	for _, tt := range tests {
+		s := tt.sched
+		if err := validateSchedule(&s); (err == nil) != tt.ok {
+			t.Errorf("validateSchedule(%+v) = %v, want ok=%v", tt.sched, err, tt.ok)
+		}
+	}
+}
+
+func TestPruneRuleFirings(t *testing.T) {
+	app := newTestApp(t, nil)
+	kept, err := insertDetection(1000, "garage", `["person"]`, "[]", "[]", "", "[]") // e.g. held
+	if err != nil {
+		t.Fatal(err)
+	}
+	firings := []struct {
+		eventID   int64
+		timestamp float64
+		kept      bool
+	}{
+		{kept, 1000, true}, // its detection is still there
+		{kept + 1, 1000, false},
+		{kept + 2, 5000, true}, // newer than the cutoff
+	}
+	for _, f := range firings {
+		app.DB.Exec("INSERT INTO rule_firings (rule, event_id, camera_id, timestamp) VALUES ('r', ?, 'garage', ?)", f.eventID, f.timestamp)
+	}
+	if n, err := pruneRuleFirings(app.DB, 2000); err != nil || n != 1 {
+		t.Fatalf("pruneRuleFirings = %d, %v; want 1 deleted", n, err)
+	}
+	for _, f := range firings {
+		var n int
+		app.DB.QueryRow("SELECT COUNT(*) FROM rule_firings WHERE event_id = ?", f.eventID).Scan(&n)
+		if (n == 1) != f.kept {
+			t.Errorf("firing on event %d at %v: %d left, kept = %v", f.eventID, f.timestamp, n, f.kept)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	app := newTestApp(t, &Config{CameraGroups: map[string][]string{"outside": {"garage", "porch"}}})
+	night := &RuleSchedule{From: "22:00", To: "06:00"}
+	validateSchedule(night)
+	const noon, midnight = 1752235200, 1752192000 // Friday 11 July 2025 12:00 and 00:00 UTC
+
+	// Two people and a car on the garage camera: the first person on the drive.
+	ev := DetectionEvent{
+		Timestamp: noon, CameraID: "garage",
+		Labels:      []string{"person", "person", "car"},
+		Confidences: []float64{0.9, 0.4, 0.8},
+		Zones:       [][]string{{"drive"}, {"lawn"}, {"drive", "street"}},
+	}
+	loiter := DetectionEvent{Kind: "loitering", DwellSeconds: 600, Timestamp: noon, CameraID: "garage", Labels: []string{"car"}}
+	old := DetectionEvent{Timestamp: noon, CameraID: "garage", Labels: []string{"person", "dog"}} // no confidences or zones stored
+
+	tests := []struct {
+		name   string
+		rule   Rule
+		ev     DetectionEvent
+		count  int
+		reason string
+	}{
+		{"any label", Rule{}, ev, 3, ""},
+		{"labels", Rule{Labels: []string{"Person"}}, ev, 2, ""},
+		{"label not seen", Rule{Labels: []string{"dog"}}, ev, 0, "labels"},
+		{"min_confidence", Rule{Labels: []string{"person"}, MinConfidence: 0.5}, ev, 1, ""},
+		{"min_confidence drops all", Rule{MinConfidence: 0.95}, ev, 0, "labels"},
+		{"no stored confidences pass", Rule{MinConfidence: 0.95}, old, 2, ""},
+		{"zones", Rule{Labels: []string{"person"}, Zones: []string{"drive"}}, ev, 1, ""},
+		{"zones, any of them", Rule{Zones: []string{"street", "lawn"}}, ev, 2, ""},
+		{"zones on a camera without", Rule{Zones: []string{"drive"}}, old, 0, "labels"},
+		{"min_count", Rule{Labels: []string{"person"}, MinCount: 2}, ev, 2, ""},
+		{"min_count not reached", Rule{Labels: []string{"person"}, MinCount: 3}, ev, 2, "count"},
+		{"max_count", Rule{MaxCount: 2}, ev, 3, "count"},
+		{"max_count held", Rule{Labels: []string{"car"}, MaxCount: 1}, ev, 1, ""},
+		{"camera", Rule{Cameras: []string{"porch"}}, ev, 0, "camera"},
+		{"camera group", Rule{Cameras: []string{"outside"}}, ev, 3, ""},
+		{"schedule", Rule{Schedule: night}, ev, 0, "schedule"},
+		{"schedule at night", Rule{Schedule: night}, DetectionEvent{Timestamp: midnight, CameraID: "garage", Labels: []string{"car"}}, 1, ""},
+		{"disabled", Rule{Disabled: true}, ev, 0, "disabled"},
+		{"on loitering", Rule{On: "loitering", Labels: []string{"car"}}, loiter, 1, ""},
+		{"on loitering ignores detections", Rule{On: "loitering"}, ev, 0, "event"},
+		{"on detection ignores loitering", Rule{}, loiter, 0, "event"},
+	}
+	for _, tt := range tests {
+		count, reason := app.matchRule(tt.rule, tt.ev)
+		if count != tt.count || reason != tt.reason {
+			t.Errorf("%s: matchRule = %d, %q; want %d, %q", tt.name, count, reason, tt.count, tt.reason)
+		}
+	}
+}
+
+func TestRuleEngineClaim(t *testing.T) {
+	e := newRuleEngine()
+	tests := []struct {
+		key      string
+		ts       float64
+		cooldown int
+		want     bool
+	}{
+		{"config:r|garage", 1000, 60, true},
+		{"config:r|garage", 1030, 60, false}, // cooling down
+		{"config:r|porch", 1030, 60, true},   // per camera
+		{"config:other|garage", 1030, 60, true},
+		{"config:r|garage", 1059, 60, false},
+		{"config:r|garage", 1060, 60, true}, // the cooldown runs from the last firing
+		{"config:r|garage", 1061, 0, true},  // no cooldown
+		{"config:r|garage", 1062, 0, true},
+	}
+	for i, tt := range tests {
+		if got := e.claim(tt.key, tt.ts, tt.cooldown); got != tt.want {
+			t.Errorf("%d: claim(%s, %v, %d) = %v, want %v", i, tt.key, tt.ts, tt.cooldown, got, tt.want)
+		}
+	}
+}
+
+func TestHandleRuleTest(t *testing.T) {
+	app := newTestApp(t, &Config{Rules: []Rule{
+		{Name: "person", Labels: []string{"person"}, CooldownSeconds: 120, Disabled: true, Actions: []RuleAction{{Type: "flag", Flag: "x"}}},
+		{Name: "parked", On: "loitering", Labels: []string{"car"}, Zones: []string{"drive"}, Actions: []RuleAction{{Type: "flag", Flag: "x"}}},
+	}})
+	detections := []struct {
+		ts     float64
+		camera string
+		labels string
+	}{
+		{1000, "garage", `["person"]`},
+		{1060, "garage", `["person","car"]`}, // in the cooldown
+		{1090, "porch", `["person"]`},        // another camera
+		{1100, "garage", `["car"]`},
+		{1200, "garage", `["person"]`}, // cooldown over
+		{5000, "garage", `["person"]`}, // after end_time
+	}
+	for _, d := range detections {
+		if _, err := insertDetection(d.ts, d.camera, d.labels, "[]", "[]", "", "[]"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.DB.Exec(`INSERT INTO dwell_events (alert, camera_id, label, zones, event_id, timestamp, dwell_seconds)
+		VALUES ('loitering', 'garage', 'car', 'drive,street', 4, 1100, 600), ('loitering', 'porch', 'car', '', 9, 1150, 300)`)
+
+	type result struct {
+		Checked, Matched, Fired int
+		Results                 []ruleTestResult
+	}
+	tests := []struct {
+		name  string
+		body  string
+		code  int
+		want  result
+		fired []bool
+	}{
+		{"by name, disabled", `{"name": "person", "start_time": 900, "end_time": 2000}`, 200,
+			result{Checked: 5, Matched: 4, Fired: 3}, []bool{true, false, true, true}},
+		{"inline rule", `{"rule": {"name": "cars", "labels": ["car"], "actions": [{"type": "flag", "flag": "car"}]}, "start_time": 900, "end_time": 2000}`, 200,
+			result{Checked: 5, Matched: 2, Fired: 2}, []bool{true, true}},
+		{"on loitering", `{"name": "parked", "start_time": 900, "end_time": 2000}`, 200,
+			result{Checked: 2, Matched: 1, Fired: 1}, []bool{true}},
+		{"limit", `{"name": "person", "start_time": 900, "end_time": 2000, "limit": 2}`, 200,
+			result{Checked: 2, Matched: 2, Fired: 1}, []bool{true, false}},
+		{"unknown rule", `{"name": "nope"}`, 404, result{}, nil},
+		{"invalid rule", `{"rule": {"name": "no actions"}}`, 400, result{}, nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		app.handleRuleTest(w, httptest.NewRequest("POST", "/rules/test", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.code, w.Body)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var got result
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var fired []bool
+		for _, r := range got.Results {
+			fired = append(fired, r.Fired)
+		}
+		if got.Checked != tt.want.Checked || got.Matched != tt.want.Matched || got.Fired != tt.want.Fired || !reflect.DeepEqual(fired, tt.fired) {
+			t.Errorf("%s: checked %d, matched %d, fired %d %v; want %d, %d, %d %v", tt.name,
+				got.Checked, got.Matched, got.Fired, fired, tt.want.Checked, tt.want.Matched, tt.want.Fired, tt.fired)
+		}
+	}
+
+	// A dry run fires nothing.
+	var firings int
+	app.DB.QueryRow("SELECT COUNT(*) FROM rule_firings").Scan(&firings)
+	if firings != 0 {
+		t.Errorf("%d firings logged by dry runs", firings)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestS3SnapshotStore runs against a real S3-compatible server, e.g.
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	MINIO_ENDPOINT=localhost:9000 go test -run S3 ./...
+//
+// MINIO_ACCESS_KEY and MINIO_SECRET_KEY default to minioadmin, and
+// MINIO_BUCKET to chatcam-test. Everything is written under a fresh prefix
+// and deleted again.
+func TestS3SnapshotStore(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT not set")
+	}
+	env := func(name, def string) string { return firstNonEmpty(os.Getenv(name), def) }
+	store, err := NewS3SnapshotStore(S3Config{
+		Endpoint:  endpoint,
+		Bucket:    env("MINIO_BUCKET", "chatcam-test"),
+		AccessKey: env("MINIO_ACCESS_KEY", "minioadmin"),
+		SecretKey: env("MINIO_SECRET_KEY", "minioadmin"),
+		Prefix:    fmt.Sprintf("/test-%d/", time.Now().UnixNano()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	keys := []string{"garage/2025-07-11/a.jpg", "garage/2025-07-12/b.jpg", "porch/2025-07-11/c.jpg"}
+	t.Cleanup(func() {
+		for _, k := range keys {
+			store.Delete(ctx, k)
+		}
+	})
+
+	for _, k := range keys {
+		if err := store.Put(ctx, k, []byte("jpeg "+k)); err != nil {
+			t.Fatalf("Put %s: %v", k, err)
+		}
+	}
+	if data, err := store.Get(ctx, keys[0]); err != nil || string(data) != "jpeg "+keys[0] {
+		t.Errorf("Get %s = %q, %v", keys[0], data, err)
+	}
+
+	lists := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", keys},
+		{"garage/", keys[:2]},
+		{"porch/2025-07-11/", keys[2:]},
+		{"drive/", nil},
+	}
+	for _, l := range lists {
+		got, err := store.List(ctx, l.prefix)
+		if err != nil || !reflect.DeepEqual(got, l.want) {
+			t.Errorf("List(%q) = %v, %v; want %v", l.prefix, got, err, l.want)
+		}
+	}
+
+	if err := store.Delete(ctx, keys[0]); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, keys[0]); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Get after Delete: %v, want ErrSnapshotNotFound", err)
+	}
+	if _, err := store.Get(ctx, "garage/never.jpg"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Get of a missing key: %v, want ErrSnapshotNotFound", err)
+	}
+	if got, _ := store.List(ctx, "garage/"); !reflect.DeepEqual(got, keys[1:2]) {
+		t.Errorf("List after Delete = %v, want %v", got, keys[1:2])
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+snapshotlayout.go
+-----------------
+
+Snapshot keys are sharded by camera and day and named by content hash:
+
+	<camera_id>/<YYYY-MM-DD>/<sha256>.jpg
+
+The day is the UTC date of the detection, so keys don't depend on the
+host's time zone or the timezone setting.
+
+- Two events from the same camera in the same second no longer overwrite each other.
+- Identical frames on the same camera/day are stored once and shared by rows.
+- Directory listings stay small.
+
+migrateSnapshotLayout moves old flat "<camera>_<ts>.jpg" files into this layout.
+*/
+
+// snapshotKeyFor builds the content-addressed key for a snapshot, sharded
+// by the UTC day of timestamp.
+func snapshotKeyFor(cameraID string, timestamp float64, data []byte) string {
+	sum := sha256.Sum256(data)
+	day := time.Unix(int64(timestamp), 0).UTC().Format("2006-01-02")
+	return fmt.Sprintf("%s/%s/%s.jpg", safeKeyPart(cameraID), day, hex.EncodeToString(sum[:]))
+}
+
+// safeKeyPart keeps camera IDs usable as a single path segment.
+func safeKeyPart(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// snapshotInUse reports whether any detection row still points at key.
+// Content-addressed snapshots can be shared, so cleanup must check this
+// before deleting a file.
+func snapshotInUse(d *sql.DB, key string) (bool, error) {
+	var n int
+	err := d.QueryRow(
+		"SELECT COUNT(*) FROM detections WHERE snapshot_file IN (?, ?)",
+		key, "./snapshots/"+key,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// migrateSnapshotLayout moves legacy flat snapshots into the sharded layout
+// and rewrites detections.snapshot_file to match. Safe to re-run: only keys
+// without a "/" are touched.
+func (app *App) migrateSnapshotLayout(ctx context.Context) error {
+	keys, err := app.Snapshots.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("list snapshots: %w", err)
+	}
+
+	moved := 0
+	for _, oldKey := range keys {
+		if strings.Contains(oldKey, "/") || path.Ext(oldKey) != ".jpg" {
+			continue // already migrated, or not a snapshot
+		}
+
+		data, err := app.Snapshots.Get(ctx, oldKey)
+		if err != nil {
+			log.Printf("[Migrate] Failed to read %s: %v", oldKey, err)
+			continue
+		}
+
+		// Prefer the DB row for camera + time, fall back to the file name.
+		var cameraID string
+		var ts float64
+		err = app.DB.QueryRow(
+			"SELECT camera_id, timestamp FROM detections WHERE snapshot_file IN (?, ?) LIMIT 1",
+			oldKey, "./snapshots/"+oldKey,
+		).Scan(&cameraID, &ts)
+		if err != nil {
+			var ok bool
+			cameraID, ts, ok = parseLegacySnapshotName(oldKey)
+			if !ok {
+				log.Printf("[Migrate] Skipping %s: no DB row and unrecognised name", oldKey)
+				continue
+			}
+		}
+
+		newKey := snapshotKeyFor(cameraID, ts, data)
+		if err := app.Snapshots.Put(ctx, newKey, data); err != nil {
+			return fmt.Errorf("write %s: %w", newKey, err)
+		}
+		if _, err := app.DB.Exec(
+			"UPDATE detections SET snapshot_file = ? WHERE snapshot_file IN (?, ?)",
+			newKey, oldKey, "./snapshots/"+oldKey,
+		); err != nil {
+			return fmt.Errorf("update rows for %s: %w", oldKey, err)
+		}
+		if err := app.Snapshots.Delete(ctx, oldKey); err != nil {
+			log.Printf("[Migrate] Failed to remove %s: %v", oldKey, err)
+		}
+		moved++
+	}
+
+	log.Printf("[Migrate] Moved %d snapshots into the sharded layout", moved)
+	return nil
+}
+
+// parseLegacySnapshotName splits "<camera>_<unix>.jpg" into its parts.
+func parseLegacySnapshotName(name string) (string, float64, bool) {
+	base := strings.TrimSuffix(name, ".jpg")
+	i := strings.LastIndex(base, "_")
+	if i <= 0 {
+		return "", 0, false
+	}
+	ts, err := strconv.ParseFloat(base[i+1:], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return base[:i], ts, true
+}
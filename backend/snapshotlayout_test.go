@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnapshotKeyForUsesUTCDay(t *testing.T) {
+	// Near midnight UTC, where the day differs in most other zones.
+	tests := []struct {
+		ts   float64
+		want string
+	}{
+		{1752276600, "garage_cam/2025-07-11/"}, // 23:30 UTC, the 12th in Auckland
+		{1752194200, "garage_cam/2025-07-11/"}, // 00:30 UTC, the 10th in Los Angeles
+	}
+	for _, tt := range tests {
+		if key := snapshotKeyFor("garage cam", tt.ts, []byte("jpeg")); !strings.HasPrefix(key, tt.want) {
+			t.Errorf("snapshotKeyFor(%v) = %s, want it under %s", tt.ts, key, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+snapshotstore.go
+----------------
+
+All snapshot reads and writes go through a SnapshotStore, so the JPEGs can
+live on local disk or in an S3-compatible bucket (e.g. MinIO).
+
+Snapshots are addressed by a slash-separated key such as
+"garage_webcam_1752205055.jpg". The key is what gets stored in the
+detections.snapshot_file column.
+*/
+
+// ErrSnapshotNotFound is returned by Get when the key does not exist.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// SnapshotStore stores snapshot images by key.
+type SnapshotStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// List returns all keys starting with prefix ("" for all), sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// newSnapshotStore builds the store selected in config.yaml.
+func newSnapshotStore(cfg SnapshotConfig) (SnapshotStore, error) {
+	switch cfg.Backend {
+	case "", "local":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "./snapshots"
+		}
+		return NewLocalSnapshotStore(dir)
+	case "s3":
+		return NewS3SnapshotStore(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown snapshot backend %q", cfg.Backend)
+	}
+}
+
+// cleanSnapshotKey validates a client-supplied key and strips the legacy
+// "./snapshots/" prefix that older rows stored in snapshot_file. Legacy
+// keys were flat, so the prefix is only stripped when no "/" follows it:
+// "snapshots/2025-07-11/<sha>.jpg" is a camera called "snapshots".
+// It rejects anything that could escape the store root.
+func cleanSnapshotKey(key string) (string, error) {
+	key = filepath.ToSlash(key)
+	key = strings.TrimPrefix(key, "./")
+	if rest := strings.TrimPrefix(key, "snapshots/"); rest != key && !strings.Contains(rest, "/") {
+		key = rest
+	}
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("invalid snapshot key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid snapshot key %q", key)
+		}
+	}
+	return path.Clean(key), nil
+}
+
+// LocalSnapshotStore keeps snapshots as files under a root directory.
+type LocalSnapshotStore struct {
+	Root string
+}
+
+// NewLocalSnapshotStore makes sure root exists and returns a store for it.
+func NewLocalSnapshotStore(root string) (*LocalSnapshotStore, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create snapshot dir: %w", err)
+	}
+	return &LocalSnapshotStore{Root: root}, nil
+}
+
+func (s *LocalSnapshotStore) path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+func (s *LocalSnapshotStore) Put(ctx context.Context, key string, data []byte) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+func (s *LocalSnapshotStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrSnapshotNotFound
+	}
+	return data, err
+}
+
+func (s *LocalSnapshotStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalSnapshotStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
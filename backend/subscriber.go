@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	zmq4 "github.com/pebbe/zmq4"
@@ -21,13 +21,13 @@ func (app *App) runSubscriber() {
 	fmt.Println("[ZeroMQSubscriber] Connecting to tcp://localhost:5555...")
 
 	// Create ZeroMQ context and SUB socket
-	context, err := zmq4.NewContext()
+	zctx, err := zmq4.NewContext()
 	if err != nil {
 		log.Fatalf("Failed to create ZeroMQ context: %v", err)
 	}
-	defer context.Term()
+	defer zctx.Term()
 
-	subscriber, err := context.NewSocket(zmq4.SUB)
+	subscriber, err := zctx.NewSocket(zmq4.SUB)
 	if err != nil {
 		log.Fatalf("Failed to create SUB socket: %v", err)
 	}
@@ -44,14 +44,6 @@ func (app *App) runSubscriber() {
 
 	fmt.Println("[ZeroMQSubscriber] Connected! Waiting for messages...")
 
-	// Make sure snapshot folder exists
-	if _, err := os.Stat("./snapshots"); os.IsNotExist(err) {
-		err := os.MkdirAll("./snapshots", os.ModePerm)
-		if err != nil {
-			log.Fatalf("Failed to create snapshots folder: %v", err)
-		}
-	}
-
 	// Loop forever: receive -> parse -> filter -> save
 	for {
 		msg, err := subscriber.RecvMessage(0)
@@ -77,12 +69,39 @@ func (app *App) runSubscriber() {
 		labelsJSON, _ := json.Marshal(event["labels"])
 		labelsStr := string(labelsJSON)
 		boxesJSON, _ := json.Marshal(event["boxes"])
+		confidencesJSON, _ := json.Marshal(event["confidences"])
+
+		// Zones each object is in (zones.go)
+		var labels []string
+		var boxes [][]float64
+		json.Unmarshal(labelsJSON, &labels)
+		json.Unmarshal(boxesJSON, &boxes)
+		zones := app.cameraZones(cameraID)
+		var width, height float64
+		if len(zones) > 0 || len(app.cameraLines(cameraID)) > 0 {
+			width, height = eventFrameSize(event, boxes)
+		}
+		objectZones := assignZones(zones, boxes, width, height)
+		zonesJSON, _ := json.Marshal(objectZones)
+		if objectZones == nil {
+			zonesJSON = []byte("[]")
+		}
+
+		// Tripwires see every event, before dedup drops any (crossings.go)
+		app.trackCrossings(cameraID, timestamp, labels, boxes, width, height)
+
+		// What dedup compares: every label, or only those inside a zone.
+		dedupKey := labelsStr
+		if app.Config.Subscriber.IgnoreOutsideZones && objectZones != nil {
+			kept, _ := json.Marshal(inAnyZone(labels, objectZones))
+			dedupKey = string(kept)
+		}
 
 		lastEvent, found := lastEvents[cameraID]
 		lastTime := lastSaved[cameraID]
 
 		if app.Config.Subscriber.Deduplicate {
-			if labelsStr == lastEvent  && found {
+			if dedupKey == lastEvent  && found {
 				if app.Config.Subscriber.ThrottleN > 0 {
 					if time.Since(lastTime) < time.Duration(app.Config.Subscriber.ThrottleN)*time.Second {
 						continue // Skip duplicate within throttle window
@@ -99,27 +118,38 @@ func (app *App) runSubscriber() {
 			if err != nil {
 				log.Printf("Failed to decode snapshot: %v", err)
 			} else {
-				filename := fmt.Sprintf("./snapshots/%s_%.0f.jpg", cameraID, timestamp)
-				err := os.WriteFile(filename, jpgBytes, 0644)
+				key := snapshotKeyFor(cameraID, timestamp, jpgBytes)
+				err := app.Snapshots.Put(context.Background(), key, jpgBytes)
 				if err != nil {
 					log.Printf("Failed to save snapshot: %v", err)
 				} else {
-					snapshotPath = filename
+					snapshotPath = key
+					// Pre-generate the timeline-card thumbnail off the hot path.
+					go app.pregenerateThumbnail(key, jpgBytes)
 				}
 			}
 		}
 
 		// Insert into SQLite
-		err = insertDetection(timestamp, cameraID, labelsStr, string(boxesJSON), snapshotPath)
+		id, err := insertDetection(timestamp, cameraID, labelsStr, string(boxesJSON), string(confidencesJSON), snapshotPath, string(zonesJSON))
 		if err != nil {
 			log.Printf("Failed to insert detection: %v", err)
 		} else {
 			fmt.Printf("[ZeroMQSubscriber] Logged event: cam=%s labels=%s\n", cameraID, labelsStr)
+			app.afterIngest(parseDetectionEvent(id, timestamp, cameraID, labelsStr, string(boxesJSON), string(confidencesJSON), snapshotPath, string(zonesJSON)))
 		}
 
 		// Update dedup state
-		lastEvents[cameraID] = labelsStr
+		lastEvents[cameraID] = dedupKey
 		// lastEvents[cameraID] = labelsAndBoxesStr
 		lastSaved[cameraID] = time.Now()
 	}
 }
+
+// afterIngest runs everything that reacts to a newly stored detection.
+func (app *App) afterIngest(ev DetectionEvent) {
+	app.evaluateRules(ev)
+	app.trackDwell(ev)
+	app.queueHomeAssistant(ev)
+	app.queueDetectionWebhooks(ev)
+}
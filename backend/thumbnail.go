@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+/*
+thumbnail.go
+------------
+
+Resized snapshot variants for /snapshot?file=...&w=...&h=...&quality=...
+
+- Resizing happens in Go, aspect ratio is kept, images are never upscaled.
+- Variants are cached on local disk (even when snapshots live in S3).
+- A small thumbnail is pre-generated at ingest so timeline cards load fast.
+- Requested sizes and qualities are snapped to a few allowed steps, so a
+  client can't fill the disk with one cached file per pixel width.
+*/
+
+const (
+	maxThumbnailSide        = 4096
+	defaultThumbnailQuality = 75
+	// ingestThumbnailWidth is the variant pre-generated for every new snapshot.
+	// Keep it in sync with the width the frontend asks for in timeline cards.
+	ingestThumbnailWidth = 320
+)
+
+// thumbnailSizes are the widths/heights variants are rendered at; a
+// requested w or h is rounded up to the next one.
+var thumbnailSizes = []int{160, 320, 480, 640, 960, 1280, 1920, 2560, 3840, maxThumbnailSide}
+
+// thumbnailQualities are the JPEG qualities variants are encoded at; a
+// requested quality is rounded up to the next one.
+var thumbnailQualities = []int{50, 75, 90, 100}
+
+// snapUp rounds v up to the next value in steps (v = 0 stays 0).
+func snapUp(v int, steps []int) int {
+	if v == 0 {
+		return 0
+	}
+	for _, s := range steps {
+		if v <= s {
+			return s
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+// ThumbSpec describes one resized variant. Zero W or H means "keep aspect".
+type ThumbSpec struct {
+	W, H    int
+	Quality int
+
+	// Overlay draws stored boxes on the frame (see overlay.go),
+	// limited to Labels when non-empty. EventID is the detection whose
+	// boxes are drawn; a shared snapshot has one overlay per event.
+	Overlay bool
+	Labels  []string
+	EventID int64
+}
+
+// snapped returns the spec with its size and quality rounded up to the
+// allowed steps, which bounds the number of cached variants per snapshot.
+func (s ThumbSpec) snapped() ThumbSpec {
+	s.W = snapUp(s.W, thumbnailSizes)
+	s.H = snapUp(s.H, thumbnailSizes)
+	s.Quality = snapUp(s.Quality, thumbnailQualities)
+	return s
+}
+
+// IsOriginal reports whether no resize/re-encode/overlay was requested.
+func (s ThumbSpec) IsOriginal() bool {
+	return s.W == 0 && s.H == 0 && s.Quality == 0 && !s.Overlay
+}
+
+// resizes reports whether the variant needs resizing or re-encoding.
+func (s ThumbSpec) resizes() bool {
+	return s.W != 0 || s.H != 0 || s.Quality != 0
+}
+
+// suffix is the cache-file suffix for this variant.
+func (s ThumbSpec) suffix() string {
+	q := s.Quality
+	if q == 0 {
+		q = defaultThumbnailQuality
+	}
+	suffix := fmt.Sprintf("%dx%d_q%d", s.W, s.H, q)
+	if s.Overlay {
+		suffix += fmt.Sprintf("_ov%d", s.EventID)
+		if len(s.Labels) > 0 {
+			sum := sha256.Sum256([]byte(strings.ToLower(strings.Join(s.Labels, ","))))
+			suffix += "-" + hex.EncodeToString(sum[:4])
+		}
+	}
+	return suffix
+}
+
+// ThumbnailCache stores resized variants under Dir, mirroring snapshot keys.
+type ThumbnailCache struct {
+	Dir string
+}
+
+// NewThumbnailCache returns a cache rooted at dir (default ./data/thumbnails).
+func NewThumbnailCache(dir string) *ThumbnailCache {
+	if dir == "" {
+		dir = "./data/thumbnails"
+	}
+	return &ThumbnailCache{Dir: dir}
+}
+
+func (c *ThumbnailCache) path(key string, spec ThumbSpec) string {
+	base := strings.TrimSuffix(filepath.FromSlash(key), ".jpg")
+	return filepath.Join(c.Dir, base+"_"+spec.suffix()+".jpg")
+}
+
+// Get returns the cached variant, generating and caching it from original if missing.
+func (c *ThumbnailCache) Get(key string, spec ThumbSpec, original func() ([]byte, error)) ([]byte, error) {
+	p := c.path(key, spec)
+	if data, err := os.ReadFile(p); err == nil {
+		return data, nil
+	}
+
+	data, err := original()
+	if err != nil {
+		return nil, err
+	}
+	if spec.resizes() {
+		data, err = resizeJPEG(data, spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Cache write failures only cost us a re-render next time.
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err == nil {
+		os.WriteFile(p, data, 0644)
+	}
+	return data, nil
+}
+
+// Purge removes every cached variant of key (called when the snapshot is deleted).
+func (c *ThumbnailCache) Purge(key string) {
+	base := strings.TrimSuffix(filepath.FromSlash(key), ".jpg")
+	matches, _ := filepath.Glob(filepath.Join(c.Dir, base) + "_*x*_q*.jpg")
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
+
+// resizeJPEG decodes src, fits it inside spec.W x spec.H and re-encodes as JPEG.
+func resizeJPEG(src []byte, spec ThumbSpec) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	b := img.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), spec.W, spec.H)
+
+	var out image.Image = img
+	if w != b.Dx() || h != b.Dy() {
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+		out = dst
+	}
+
+	quality := spec.Quality
+	if quality == 0 {
+		quality = defaultThumbnailQuality
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fitSize scales srcW x srcH to fit inside maxW x maxH (0 = unbounded),
+// keeping the aspect ratio and never upscaling.
+func fitSize(srcW, srcH, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && srcW > maxW {
+		scale = float64(maxW) / float64(srcW)
+	}
+	if maxH > 0 && srcH > maxH {
+		if s := float64(maxH) / float64(srcH); s < scale {
+			scale = s
+		}
+	}
+	w := int(float64(srcW)*scale + 0.5)
+	h := int(float64(srcH)*scale + 0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// contentETag is a strong ETag for the bytes being served.
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// pregenerateThumbnail renders the small timeline thumbnail for a new snapshot.
+func (app *App) pregenerateThumbnail(key string, data []byte) {
+	spec := ThumbSpec{W: ingestThumbnailWidth}
+	_, err := app.Thumbs.Get(key, spec, func() ([]byte, error) { return data, nil })
+	if err != nil {
+		log.Printf("Failed to pre-generate thumbnail for %s: %v", key, err)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+timerange.go
+------------
+
+Deterministic resolution of the time part of a chat question, so "did a
+car come by last night?" searches last night instead of "ever". Phrases
+are resolved in the server's configured timezone (timezone: in
+config.yaml), not by the LLM.
+
+Understood, roughly in order of precedence:
+- "in the past hour", "last 30 minutes", "past 3 days", "last 24 hours"
+- "between 2 and 4pm yesterday", "from 9am to noon on monday"
+- "since 3pm", "after 8am", "before 9am yesterday"
+- "this morning", "yesterday afternoon", "tonight", "last night"
+- "today", "yesterday", "monday", "last friday", "3 days ago", "2025-07-11"
+- "this week", "last week", "this month", "last month"
+*/
+
+// TimeRange is a resolved time expression. Start is inclusive, End exclusive.
+type TimeRange struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Phrase string    `json:"phrase"` // the words it was resolved from
+}
+
+// String formats the range for prompts and answers, e.g.
+// "last night (Fri 11 Jul 18:00 – Sat 12 Jul 06:00 BST)".
+func (r TimeRange) String() string {
+	const layout = "Mon 2 Jan 15:04"
+	end := r.End.Format(layout)
+	if sameDay(r.Start, r.End) {
+		end = r.End.Format("15:04")
+	}
+	return fmt.Sprintf("%s (%s – %s %s)", r.Phrase, r.Start.Format(layout), end, r.End.Format("MST"))
+}
+
+// loadLocation returns the configured timezone, falling back to the host's.
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown timezone %q, using the system timezone: %v", name, err)
+		return time.Local
+	}
+	return loc
+}
+
+var (
+	numberWord = `(\d+|an?|one|two|three|four|five|six|seven|eight|nine|ten|twelve|few|couple(?: of)?)`
+	unitWord   = `(minute|min|hour|hr|day|week|month)s?`
+
+	reRelative  = regexp.MustCompile(`\b(?:in|within|over|during)?\s*(?:the\s+)?(past|last)\s+(?:` + numberWord + `\s+)?` + unitWord + `\b`)
+	reClock     = `(\d{1,2})(?::(\d{2}))?\s*(am|pm)?`
+	reBetween   = regexp.MustCompile(`\b(?:between|from)\s+` + reClock + `\s+(?:and|to|until|till|-)\s+` + reClock + `\b`)
+	reSince     = regexp.MustCompile(`\b(since|after|before|until)\s+` + reClock + `\b`)
+	rePartOfDay = regexp.MustCompile(`\b(?:(this|yesterday|today|tomorrow|last|[a-z]+day)\s+)?(morning|afternoon|evening|night)\b|\btonight\b`)
+	reAgo       = regexp.MustCompile(`\b` + numberWord + `\s+days?\s+ago\b`)
+	reISODate   = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b`)
+	reWeekday   = regexp.MustCompile(`\b(last\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)\b`)
+	reCalendar  = regexp.MustCompile(`\b(this|last|past|previous)\s+(week|month)\b`)
+	reDayWord   = regexp.MustCompile(`\b(today|yesterday|the day before yesterday)\b`)
+	reNoon      = regexp.MustCompile(`\bnoon\b`)
+	reMidnight  = regexp.MustCompile(`\bmidnight\b`)
+)
+
+// parseTimeRange finds the first time expression in text, relative to now
+// (whose location is used for calendar boundaries).
+func parseTimeRange(text string, now time.Time) (*TimeRange, bool) {
+	s := strings.ToLower(text)
+	s = reNoon.ReplaceAllString(s, "12pm")
+	s = reMidnight.ReplaceAllString(s, "12am")
+	s = strings.NewReplacer("p.m.", "pm", "a.m.", "am").Replace(s)
+
+	if r, ok := relativeRange(s, now); ok {
+		return r, true
+	}
+	if m := reBetween.FindStringSubmatch(s); m != nil {
+		day, dayPhrase, _ := dayFor(s, now)
+		h1, m1, h2, m2, ok := clockPair(m[1], m[2], m[3], m[4], m[5], m[6])
+		if ok {
+			start := atClock(day, h1, m1)
+			end := atClock(day, h2, m2)
+			if !end.After(start) {
+				end = end.AddDate(0, 0, 1) // "between 10pm and 2am"
+			}
+			return &TimeRange{Start: start, End: end, Phrase: joinPhrase(m[0], dayPhrase)}, true
+		}
+	}
+	if m := reSince.FindStringSubmatch(s); m != nil {
+		if h, min, ok := clock(m[2], m[3], m[4]); ok {
+			day, dayPhrase, _ := dayFor(s, now)
+			at := atClock(day, h, min)
+			if m[1] == "before" || m[1] == "until" {
+				return &TimeRange{Start: startOfDay(day), End: at, Phrase: joinPhrase(m[0], dayPhrase)}, true
+			}
+			end := startOfDay(day).AddDate(0, 0, 1)
+			if now.Before(end) && now.After(at) {
+				end = now
+			}
+			return &TimeRange{Start: at, End: end, Phrase: joinPhrase(m[0], dayPhrase)}, true
+		}
+	}
+	if r, ok := partOfDayRange(s, now); ok {
+		return r, true
+	}
+	if day, phrase, ok := dayFor(s, now); ok {
+		return &TimeRange{Start: startOfDay(day), End: startOfDay(day).AddDate(0, 0, 1), Phrase: phrase}, true
+	}
+	if m := reCalendar.FindStringSubmatch(s); m != nil {
+		var start time.Time
+		if m[2] == "week" {
+			// Weeks start on Monday.
+			offset := (int(now.Weekday()) + 6) % 7
+			start = startOfDay(now).AddDate(0, 0, -offset)
+		} else {
+			start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		}
+		if m[1] == "this" {
+			return &TimeRange{Start: start, End: now, Phrase: m[0]}, true
+		}
+		prev := start.AddDate(0, 0, -7)
+		if m[2] == "month" {
+			prev = start.AddDate(0, -1, 0)
+		}
+		return &TimeRange{Start: prev, End: start, Phrase: m[0]}, true
+	}
+	return nil, false
+}
+
+// relativeRange handles "past hour", "last 30 minutes", "past 3 days".
+// Bare "last week"/"last month" are calendar ranges and left to the caller.
+func relativeRange(s string, now time.Time) (*TimeRange, bool) {
+	m := reRelative.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	unit := m[3]
+	if m[2] == "" && m[1] == "last" && (unit == "week" || unit == "month") {
+		return nil, false
+	}
+	n := 1
+	if m[2] != "" {
+		n = wordNumber(m[2])
+	}
+
+	var start time.Time
+	switch unit {
+	case "minute", "min":
+		start = now.Add(-time.Duration(n) * time.Minute)
+	case "hour", "hr":
+		start = now.Add(-time.Duration(n) * time.Hour)
+	case "day":
+		start = now.AddDate(0, 0, -n)
+	case "week":
+		start = now.AddDate(0, 0, -7*n)
+	case "month":
+		start = now.AddDate(0, -n, 0)
+	}
+	return &TimeRange{Start: start, End: now, Phrase: strings.TrimSpace(m[0])}, true
+}
+
+// partOfDayRange handles "this morning", "yesterday evening", "last night", "tonight".
+func partOfDayRange(s string, now time.Time) (*TimeRange, bool) {
+	m := rePartOfDay.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	qualifier, part := m[1], m[2]
+	if m[0] == "tonight" {
+		part = "night"
+	}
+
+	day := now
+	switch qualifier {
+	case "yesterday":
+		day = now.AddDate(0, 0, -1)
+	case "tomorrow":
+		day = now.AddDate(0, 0, 1)
+	case "last":
+		// "last night" is the night that ended this morning.
+		day = now.AddDate(0, 0, -1)
+	case "", "this", "today":
+	default:
+		if d, _, ok := dayFor(qualifier, now); ok {
+			day = d
+		}
+	}
+
+	hours := map[string][2]int{
+		"morning":   {6, 12},
+		"afternoon": {12, 17},
+		"evening":   {17, 22},
+		"night":     {18, 30}, // 18:00 until 06:00 the next day
+	}[part]
+	start := atClock(day, hours[0], 0)
+	end := atClock(startOfDay(day).AddDate(0, 0, hours[1]/24), hours[1]%24, 0)
+	return &TimeRange{Start: start, End: end, Phrase: strings.TrimSpace(m[0])}, true
+}
+
+// dayFor finds a day reference ("yesterday", "monday", "3 days ago", an ISO
+// date). Without one it returns today and ok=false.
+func dayFor(s string, now time.Time) (time.Time, string, bool) {
+	if m := reISODate.FindStringSubmatch(s); m != nil {
+		if d, err := time.ParseInLocation("2006-01-02", m[1], now.Location()); err == nil {
+			return d, m[0], true
+		}
+	}
+	if m := reDayWord.FindStringSubmatch(s); m != nil {
+		switch m[1] {
+		case "today":
+			return now, m[0], true
+		case "yesterday":
+			return now.AddDate(0, 0, -1), m[0], true
+		default:
+			return now.AddDate(0, 0, -2), m[0], true
+		}
+	}
+	if m := reAgo.FindStringSubmatch(s); m != nil {
+		return now.AddDate(0, 0, -wordNumber(m[1])), m[0], true
+	}
+	if m := reWeekday.FindStringSubmatch(s); m != nil {
+		target := weekdays[m[2]]
+		back := (int(now.Weekday()) - int(target) + 7) % 7
+		if back == 0 && m[1] != "" {
+			back = 7 // "last monday" on a Monday is a week ago
+		}
+		return now.AddDate(0, 0, -back), strings.TrimSpace(m[0]), true
+	}
+	return now, "", false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// clock turns "2", "30", "pm" into 14:30.
+func clock(hour, minute, ampm string) (int, int, bool) {
+	h, err := strconv.Atoi(hour)
+	if err != nil || h > 23 {
+		return 0, 0, false
+	}
+	m := 0
+	if minute != "" {
+		m, _ = strconv.Atoi(minute)
+		if m > 59 {
+			return 0, 0, false
+		}
+	}
+	switch ampm {
+	case "am":
+		if h == 12 {
+			h = 0
+		}
+	case "pm":
+		if h < 12 {
+			h += 12
+		}
+	}
+	return h, m, true
+}
+
+// clockPair resolves both ends of "between 2 and 4pm": a bare start hour
+// takes the end's am/pm when that keeps the range in order.
+func clockPair(h1, m1, ap1, h2, m2, ap2 string) (int, int, int, int, bool) {
+	if ap1 == "" && ap2 != "" {
+		if sh, sm, ok := clock(h1, m1, ap2); ok {
+			if eh, em, ok := clock(h2, m2, ap2); ok && sh*60+sm < eh*60+em {
+				ap1 = ap2
+			}
+		}
+	}
+	sh, sm, ok1 := clock(h1, m1, ap1)
+	eh, em, ok2 := clock(h2, m2, ap2)
+	return sh, sm, eh, em, ok1 && ok2
+}
+
+// wordNumber parses "3", "a", "two", "few".
+func wordNumber(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	words := map[string]int{
+		"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+		"seven": 7, "eight": 8, "nine": 9, "ten": 10, "twelve": 12, "few": 3, "couple": 2, "couple of": 2,
+	}
+	if n, ok := words[s]; ok {
+		return n
+	}
+	return 1
+}
+
+// joinPhrase appends the day words ("yesterday") to a clock phrase.
+func joinPhrase(clockPhrase, dayPhrase string) string {
+	return strings.TrimSpace(strings.TrimSpace(clockPhrase) + " " + dayPhrase)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func atClock(day time.Time, hour, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
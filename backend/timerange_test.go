@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeRange(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	now := time.Date(2025, 7, 16, 15, 30, 0, 0, loc) // a Wednesday
+	const layout = "2006-01-02 15:04"
+
+	tests := []struct {
+		text       string
+		start, end string // "" = no time expression
+	}{
+		{"anything in the past hour?", "2025-07-16 14:30", "2025-07-16 15:30"},
+		{"last 30 minutes", "2025-07-16 15:00", "2025-07-16 15:30"},
+		{"cars over the past 3 days", "2025-07-13 15:30", "2025-07-16 15:30"},
+		{"between 2 and 4pm yesterday", "2025-07-15 14:00", "2025-07-15 16:00"},
+		{"between 10pm and 2am", "2025-07-16 22:00", "2025-07-17 02:00"},
+		{"from 9am to noon on monday", "2025-07-14 09:00", "2025-07-14 12:00"},
+		{"since 3pm", "2025-07-16 15:00", "2025-07-16 15:30"},
+		{"before 9am yesterday", "2025-07-15 00:00", "2025-07-15 09:00"},
+		{"anyone this morning?", "2025-07-16 06:00", "2025-07-16 12:00"},
+		{"yesterday afternoon", "2025-07-15 12:00", "2025-07-15 17:00"},
+		{"Did a car come by last night?", "2025-07-15 18:00", "2025-07-16 06:00"},
+		{"tonight", "2025-07-16 18:00", "2025-07-17 06:00"},
+		{"last monday evening", "2025-07-14 17:00", "2025-07-14 22:00"},
+		{"today", "2025-07-16 00:00", "2025-07-17 00:00"},
+		{"yesterday", "2025-07-15 00:00", "2025-07-16 00:00"},
+		{"the day before yesterday", "2025-07-14 00:00", "2025-07-15 00:00"},
+		{"3 days ago", "2025-07-13 00:00", "2025-07-14 00:00"},
+		{"last friday", "2025-07-11 00:00", "2025-07-12 00:00"},
+		{"last wednesday", "2025-07-09 00:00", "2025-07-10 00:00"},
+		{"on 2025-07-11", "2025-07-11 00:00", "2025-07-12 00:00"},
+		{"this week", "2025-07-14 00:00", "2025-07-16 15:30"},
+		{"what happened last week", "2025-07-07 00:00", "2025-07-14 00:00"},
+		{"this month", "2025-07-01 00:00", "2025-07-16 15:30"},
+		{"last month", "2025-06-01 00:00", "2025-07-01 00:00"},
+		{"when did you last see a car?", "", ""},
+		{"is the garage door open?", "", ""},
+	}
+	for _, tt := range tests {
+		r, ok := parseTimeRange(tt.text, now)
+		if tt.start == "" {
+			if ok {
+				t.Errorf("parseTimeRange(%q) = %s, want none", tt.text, r)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("parseTimeRange(%q) found nothing, want %s – %s", tt.text, tt.start, tt.end)
+			continue
+		}
+		if got := r.Start.Format(layout); got != tt.start {
+			t.Errorf("parseTimeRange(%q).Start = %s, want %s", tt.text, got, tt.start)
+		}
+		if got := r.End.Format(layout); got != tt.end {
+			t.Errorf("parseTimeRange(%q).End = %s, want %s", tt.text, got, tt.end)
+		}
+	}
+}
+
+func TestParseTimeRangeAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	// Clocks went forward at 01:00 on Sunday 30 March 2025.
+	now := time.Date(2025, 3, 31, 10, 0, 0, 0, loc)
+	r, ok := parseTimeRange("yesterday", now)
+	if !ok {
+		t.Fatal("yesterday not found")
+	}
+	if got := r.End.Sub(r.Start); got != 23*time.Hour {
+		t.Errorf("yesterday spans %v, want 23h", got)
+	}
+	r, ok = parseTimeRange("last night", now)
+	if !ok {
+		t.Fatal("last night not found")
+	}
+	if got := r.End.Sub(r.Start); got != 12*time.Hour {
+		t.Errorf("last night spans %v, want 12h", got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+vision.go
+---------
+
+With chat.vision.enabled, the snapshots of the detections found for a
+question are attached to the final prompt so a multimodal model (llava,
+qwen2.5-vl, ...) can answer "what colour was the car?". Snapshots are
+downscaled in Go (through the thumbnail cache) to chat.vision.max_size
+and capped at chat.vision.max_images, newest first. The model is asked to
+cite the snapshot it relied on as [n]; the list comes back in the
+response as "snapshots".
+*/
+
+const (
+	defaultVisionMaxImages = 3
+	defaultVisionMaxSize   = 512
+)
+
+// AttachedSnapshot is a snapshot shown to the model, as cited by [Index].
+type AttachedSnapshot struct {
+	Index    int      `json:"index"`
+	URL      string   `json:"url"`
+	CameraID string   `json:"camera_id"`
+	Time     string   `json:"time"`
+	Labels   []string `json:"labels"`
+}
+
+// visionEnabled reports whether chat attaches snapshots.
+func (app *App) visionEnabled() bool {
+	return app.Config.Chat.Vision.Enabled
+}
+
+// attachSnapshots loads, resizes and attaches the snapshots of the newest
+// hits to msg, and lists them in its text so the model can cite them.
+func (app *App) attachSnapshots(ctx context.Context, msg *ChatMessage, hits []detectionHit) []AttachedSnapshot {
+	vc := app.Config.Chat.Vision
+	maxImages, maxSize := vc.MaxImages, vc.MaxSize
+	if maxImages <= 0 {
+		maxImages = defaultVisionMaxImages
+	}
+	if maxSize <= 0 {
+		maxSize = defaultVisionMaxSize
+	}
+	spec := ThumbSpec{W: maxSize, H: maxSize}
+
+	var attached []AttachedSnapshot
+	var listing []string
+	seen := map[string]bool{}
+	for i := len(hits) - 1; i >= 0 && len(attached) < maxImages; i-- {
+		key, err := cleanSnapshotKey(hits[i].SnapshotFile)
+		if hits[i].SnapshotFile == "" || err != nil || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		img, err := app.Thumbs.Get(key, spec, func() ([]byte, error) {
+			return app.Snapshots.Get(ctx, key)
+		})
+		if err != nil {
+			log.Printf("Vision: skipping snapshot %s: %v", key, err)
+			continue
+		}
+
+		snap := AttachedSnapshot{
+			Index:    len(attached) + 1,
+			URL:      snapshotURL(key),
+			CameraID: hits[i].CameraID,
+			Time:     time.Unix(int64(hits[i].Timestamp), 0).In(app.Loc).Format(time.RFC3339),
+		}
+		json.Unmarshal([]byte(hits[i].Labels), &snap.Labels)
+		attached = append(attached, snap)
+		msg.Images = append(msg.Images, img)
+		listing = append(listing, fmt.Sprintf("[%d] %s at %s, labels: %s", snap.Index, snap.CameraID, snap.Time, strings.Join(snap.Labels, ", ")))
+	}
+
+	if len(attached) > 0 {
+		msg.Content += "\n\nAttached snapshots, in the order of the images:\n" + strings.Join(listing, "\n") +
+			"\n\nLook at the images to answer, and cite the snapshot you relied on as [n]."
+	}
+	return attached
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVisionAttachesSnapshots(t *testing.T) {
+	app := newTestApp(t, &Config{Chat: ChatConfig{Vision: VisionConfig{Enabled: true, Model: "llava", MaxImages: 2, MaxSize: 320}}})
+	var frame bytes.Buffer
+	jpeg.Encode(&frame, image.NewRGBA(image.Rect(0, 0, 1280, 720)), nil)
+	snapshots := []string{"cam/2025-07-11/a.jpg", "cam/2025-07-11/b.jpg", "cam/2025-07-11/c.jpg", "cam/2025-07-11/c.jpg", "cam/2025-07-11/gone.jpg"}
+	for i, key := range snapshots {
+		if key != "cam/2025-07-11/gone.jpg" {
+			app.Snapshots.Put(context.Background(), key, frame.Bytes())
+		}
+		if _, err := insertDetection(float64(1752224400+i*60), "cam", `["car"]`, "[]", "[]", key, "[]"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake := app.LLM.(*FakeProvider)
+
+	for _, tools := range []bool{false, true} {
+		app.Config.Chat.Tools = tools
+		w := httptest.NewRecorder()
+		app.handleChat(w, httptest.NewRequest("POST", "/chat", strings.NewReader(`{"camera_id":"cam","message":"what colour was the car on 2025-07-11?"}`)))
+		var resp struct {
+			Answer    string             `json:"answer"`
+			Snapshots []AttachedSnapshot `json:"snapshots"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("tools %v: %v: %s", tools, err, w.Body)
+		}
+
+		// Newest first, the missing file skipped and the shared one attached once.
+		var urls []string
+		for _, s := range resp.Snapshots {
+			urls = append(urls, s.URL)
+		}
+		if got := strings.Join(urls, " "); got != "/snapshots/cam/2025-07-11/c.jpg /snapshots/cam/2025-07-11/b.jpg" {
+			t.Errorf("tools %v: snapshots %s", tools, got)
+		}
+		if len(resp.Snapshots) > 0 && (resp.Snapshots[0].Index != 1 || resp.Snapshots[0].Time != "2025-07-11T09:03:00Z") {
+			t.Errorf("tools %v: first snapshot %+v", tools, resp.Snapshots[0])
+		}
+
+		last := fake.Calls[len(fake.Calls)-1]
+		msg := last.Messages[len(last.Messages)-1]
+		if last.Model != "llava" {
+			t.Errorf("tools %v: model %q, want llava", tools, last.Model)
+		}
+		if len(msg.Images) != 2 {
+			t.Fatalf("tools %v: %d images sent, want 2", tools, len(msg.Images))
+		}
+		for i, img := range msg.Images {
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(img))
+			if err != nil || cfg.Width != 320 || cfg.Height != 180 {
+				t.Errorf("tools %v: image %d is %dx%d (%v), want 320x180", tools, i+1, cfg.Width, cfg.Height, err)
+			}
+		}
+		if !strings.Contains(msg.Content, "[1] cam at 2025-07-11T09:03:00Z, labels: car") || !strings.Contains(msg.Content, "cite the snapshot") {
+			t.Errorf("tools %v: prompt doesn't list the snapshots:\n%s", tools, msg.Content)
+		}
+		if !strings.Contains(resp.Answer, "snapshot [1]") {
+			t.Errorf("tools %v: answer %q doesn't cite a snapshot", tools, resp.Answer)
+		}
+	}
+}
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+webhooks.go
+-----------
+
+Outgoing webhooks: endpoints (Node-RED, n8n, ...) that get a JSON POST for
+every event they subscribe to:
+
+	webhooks:
+	  max_attempts: 8            # then the delivery is marked failed
+	  retry_seconds: 10          # first retry; doubles after every failure
+	  max_retry_seconds: 3600
+	  visit_gap_seconds: 120     # absence that makes the next detection a new visit
+	  endpoints:
+	    - name: node-red
+	      url: http://nodered:1880/chatcam
+	      secret: change-me      # HMAC-SHA256 signing key, optional
+	      events: [detection, visit, rule_firing]   # empty = all
+	      cameras: [outside]     # IDs or camera_groups; empty = all
+	      labels: [person, car]  # any of these; empty = any label
+
+Events:
+- detection    — every stored detection
+- visit        — the first detection of a label on a camera after
+                 visit_gap_seconds without one (a car arriving)
+- rule_firing  — a rule fired (rules.go), with its action outcomes
+
+Every delivery is queued in webhook_deliveries before it is sent, so
+pending ones survive a restart (delivery is at least once). Each
+endpoint gets its own worker, which sends its deliveries oldest first;
+anything but a 2xx is retried with exponential backoff, and the rest of
+that endpoint's queue waits for the same retry, so an endpoint that is
+down only delays its own deliveries. A delivery's next retry is booked before it is sent, so
+nothing is resent in a tight loop. The queue doubles as the delivery log:
+GET /webhooks/deliveries, and POST /webhooks/deliveries/replay?id=
+queues a copy of a delivery again.
+
+Requests carry X-Chatcam-Event, X-Chatcam-Delivery and
+X-Chatcam-Timestamp headers; with a secret, X-Chatcam-Signature is
+"sha256=" + hex HMAC-SHA256 of "<timestamp>.<body>".
+
+Endpoints from config.yaml are read-only; more can be managed through
+/webhooks and are kept in the webhooks table.
+*/
+
+const (
+	defaultWebhookMaxAttempts     = 8
+	defaultWebhookRetrySeconds    = 10
+	defaultWebhookMaxRetrySeconds = 3600
+	defaultWebhookVisitGapSeconds = 120
+)
+
+var webhookEvents = []string{"detection", "visit", "rule_firing"}
+
+// webhookHTTPClient sends webhook deliveries.
+var webhookHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhook is one endpoint that receives events.
+type Webhook struct {
+	ID        int64  `yaml:"-" json:"id"`         // 0 for endpoints from config.yaml
+	Source    string `yaml:"-" json:"source"`     // config or api
+	HasSecret bool   `yaml:"-" json:"has_secret"` // secrets are never listed
+
+	Name     string   `yaml:"name" json:"name"`
+	URL      string   `yaml:"url" json:"url"`
+	Secret   string   `yaml:"secret" json:"secret,omitempty"`
+	Events   []string `yaml:"events" json:"events"`
+	Cameras  []string `yaml:"cameras" json:"cameras"`
+	Labels   []string `yaml:"labels" json:"labels"`
+	Disabled bool     `yaml:"disabled" json:"disabled"`
+}
+
+// WebhookDelivery is one queued or attempted POST to an endpoint.
+type WebhookDelivery struct {
+	ID          int64           `json:"id"`
+	Webhook     string          `json:"webhook"` // endpoint name
+	Event       string          `json:"event"`
+	CameraID    string          `json:"camera_id"`
+	Status      string          `json:"status"` // pending, delivered or failed
+	Attempts    int             `json:"attempts"`
+	NextAttempt string          `json:"next_attempt,omitempty"`
+	LastStatus  int             `json:"last_status,omitempty"` // HTTP status of the last attempt
+	LastError   string          `json:"last_error,omitempty"`
+	Created     string          `json:"created"`
+	Delivered   string          `json:"delivered,omitempty"`
+	ReplayOf    int64           `json:"replay_of,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+
+	key string // webhookKey of the endpoint
+}
+
+// webhookStore holds the endpoints stored through the API, wakes the
+// delivery loop and tracks which endpoints have a worker sending to them.
+type webhookStore struct {
+	mu      sync.Mutex
+	loaded  bool
+	stored  []Webhook
+	wake    chan struct{}
+	busy    map[string]bool // webhookKey → a worker is delivering
+	workers sync.WaitGroup
+}
+
+func newWebhookStore() *webhookStore {
+	return &webhookStore{wake: make(chan struct{}, 1), busy: map[string]bool{}}
+}
+
+// validateWebhook checks an endpoint and normalises its events and labels.
+func validateWebhook(h *Webhook) error {
+	if h.Name == "" {
+		return fmt.Errorf("webhook without a name")
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook %q: url must be http(s)://...", h.Name)
+	}
+	for i, e := range h.Events {
+		h.Events[i] = strings.ToLower(strings.TrimSpace(e))
+		if !containsFold(webhookEvents, h.Events[i]) {
+			return fmt.Errorf("webhook %q: unknown event %q (want %s)", h.Name, e, strings.Join(webhookEvents, ", "))
+		}
+	}
+	for i, l := range h.Labels {
+		h.Labels[i] = strings.ToLower(strings.TrimSpace(l))
+	}
+	return nil
+}
+
+// webhookKey identifies an endpoint in the delivery queue.
+func webhookKey(h Webhook) string {
+	if h.Source == "api" {
+		return fmt.Sprintf("api:%d", h.ID)
+	}
+	return "config:" + h.Name
+}
+
+// allWebhooks returns the endpoints from config.yaml followed by the stored ones.
+func (app *App) allWebhooks() ([]Webhook, error) {
+	s := app.Hooks
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded {
+		stored, err := loadStoredWebhooks(app.DB)
+		if err != nil {
+			return nil, err
+		}
+		s.stored, s.loaded = stored, true
+	}
+	hooks := make([]Webhook, 0, len(app.Config.Webhooks.Endpoints)+len(s.stored))
+	for _, h := range app.Config.Webhooks.Endpoints {
+		h.Source = "config"
+		hooks = append(hooks, h)
+	}
+	return append(hooks, s.stored...), nil
+}
+
+// webhookByName finds an endpoint by name, for rule actions.
+func (app *App) webhookByName(name string) (Webhook, error) {
+	hooks, err := app.allWebhooks()
+	if err != nil {
+		return Webhook{}, err
+	}
+	for _, h := range hooks {
+		if h.Name == name {
+			return h, nil
+		}
+	}
+	return Webhook{}, fmt.Errorf("no webhook named %q", name)
+}
+
+// loadStoredWebhooks reads the webhooks table.
+func loadStoredWebhooks(d *sql.DB) ([]Webhook, error) {
+	rows, err := d.Query("SELECT id, definition FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		var id int64
+		var def string
+		if err := rows.Scan(&id, &def); err != nil {
+			return nil, err
+		}
+		var h Webhook
+		if err := json.Unmarshal([]byte(def), &h); err != nil {
+			log.Printf("[Webhooks] Skipping unreadable webhook %d: %v", id, err)
+			continue
+		}
+		h.ID, h.Source = id, "api"
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// reloadWebhooks makes the next allWebhooks re-read the webhooks table.
+func (app *App) reloadWebhooks() {
+	app.Hooks.mu.Lock()
+	app.Hooks.loaded = false
+	app.Hooks.mu.Unlock()
+}
+
+// webhookMatches reports whether an endpoint wants an event.
+func (app *App) webhookMatches(h Webhook, event, camera string, labels []string) bool {
+	if h.Disabled {
+		return false
+	}
+	if len(h.Events) > 0 && !containsFold(h.Events, event) {
+		return false
+	}
+	if len(h.Cameras) > 0 && !containsFold(app.expandCameras(h.Cameras), camera) {
+		return false
+	}
+	if len(h.Labels) > 0 {
+		var lower []string
+		for _, l := range labels {
+			lower = append(lower, strings.ToLower(l))
+		}
+		if !anyIn(lower, h.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// queueWebhooks queues data for every endpoint that wants the event.
+func (app *App) queueWebhooks(event, camera string, labels []string, data interface{}) {
+	app.queueWebhooksExcept(event, camera, labels, data, nil)
+}
+
+// queueWebhooksExcept is queueWebhooks minus the endpoints named in skip,
+// which already got the event some other way.
+func (app *App) queueWebhooksExcept(event, camera string, labels []string, data interface{}, skip map[string]bool) {
+	hooks, err := app.allWebhooks()
+	if err != nil {
+		log.Printf("[Webhooks] Failed to load webhooks: %v", err)
+		return
+	}
+	var payload []byte
+	queued := 0
+	for _, h := range hooks {
+		if skip[h.Name] || !app.webhookMatches(h, event, camera, labels) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = app.webhookPayload(event, data); err != nil {
+				log.Printf("[Webhooks] Failed to encode %s event: %v", event, err)
+				return
+			}
+		}
+		if _, err := app.queueDelivery(webhookKey(h), h.Name, event, camera, payload); err != nil {
+			log.Printf("[Webhooks] Failed to queue %s for %q: %v", event, h.Name, err)
+			continue
+		}
+		queued++
+	}
+	if queued > 0 {
+		app.wakeWebhooks()
+	}
+}
+
+// webhookPayload is the JSON body sent for an event.
+func (app *App) webhookPayload(event string, data interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"event": event,
+		"time":  time.Now().In(app.Loc).Format(time.RFC3339),
+		"data":  data,
+	})
+}
+
+// queueDelivery adds a pending delivery of payload for the endpoint with
+// the given webhookKey and returns its ID.
+func (app *App) queueDelivery(key, name, event, camera string, payload []byte) (int64, error) {
+	now := float64(time.Now().Unix())
+	res, err := app.DB.Exec(`
+		INSERT INTO webhook_deliveries (webhook, webhook_name, event, camera_id, payload, status, attempts, next_attempt, created_at)
+		VALUES (?, ?, ?, ?, ?, 'pending', 0, ?, ?)`,
+		key, name, event, camera, string(payload), now, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// wakeWebhooks tells the delivery worker there is something to send.
+func (app *App) wakeWebhooks() {
+	select {
+	case app.Hooks.wake <- struct{}{}:
+	default:
+	}
+}
+
+// queueDetectionWebhooks queues detection and visit events for a stored detection.
+func (app *App) queueDetectionWebhooks(ev DetectionEvent) {
+	hooks, err := app.allWebhooks()
+	if err != nil {
+		log.Printf("[Webhooks] Failed to load webhooks: %v", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	wantVisits := false
+	for _, h := range hooks {
+		wantVisits = wantVisits || (!h.Disabled && (len(h.Events) == 0 || containsFold(h.Events, "visit")))
+	}
+	app.queueWebhooks("detection", ev.CameraID, ev.Labels, map[string]interface{}{
+		"id":           ev.ID,
+		"time":         time.Unix(int64(ev.Timestamp), 0).In(app.Loc).Format(time.RFC3339),
+		"timestamp":    ev.Timestamp,
+		"camera_id":    ev.CameraID,
+		"labels":       ev.Labels,
+		"confidences":  ev.Confidences,
+		"boxes":        ev.Boxes,
+		"zones":        ev.Zones,
+		"snapshot_url": snapshotURL(ev.SnapshotFile),
+	})
+
+	if !wantVisits {
+		return
+	}
+	gap := float64(firstPositive(app.Config.Webhooks.VisitGapSeconds, defaultWebhookVisitGapSeconds))
+	for _, label := range uniqueStrings(ev.Labels) {
+		var prev sql.NullFloat64
+		err := app.DB.QueryRow("SELECT MAX(timestamp) FROM detections WHERE camera_id = ? AND labels LIKE ? AND timestamp < ?",
+			ev.CameraID, `%"`+label+`"%`, ev.Timestamp).Scan(&prev)
+		if err != nil {
+			log.Printf("[Webhooks] Failed to look up previous %s on %s: %v", label, ev.CameraID, err)
+			continue
+		}
+		if prev.Valid && ev.Timestamp-prev.Float64 <= gap {
+			continue // same visit
+		}
+		visit := map[string]interface{}{
+			"camera_id":    ev.CameraID,
+			"label":        label,
+			"start":        time.Unix(int64(ev.Timestamp), 0).In(app.Loc).Format(time.RFC3339),
+			"timestamp":    ev.Timestamp,
+			"event_id":     ev.ID,
+			"snapshot_url": snapshotURL(ev.SnapshotFile),
+		}
+		if prev.Valid {
+			visit["previous_seen"] = time.Unix(int64(prev.Float64), 0).In(app.Loc).Format(time.RFC3339)
+		}
+		app.queueWebhooks("visit", ev.CameraID, []string{label}, visit)
+	}
+}
+
+// runWebhookDelivery sends queued deliveries as they come in and retries
+// failed ones when they are due.
+func (app *App) runWebhookDelivery() {
+	for {
+		app.deliverDueWebhooks()
+		select {
+		case <-app.Hooks.wake:
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// webhookBatch bounds how many due deliveries one pass reads per endpoint.
+const webhookBatch = 500
+
+// deliverDueWebhooks hands the pending deliveries whose time has come to
+// one worker per endpoint, so a slow or dead endpoint only delays its own
+// deliveries. Endpoints that already have a worker are left to it. The
+// batch is per endpoint: a backlog for one can't crowd out the others.
+func (app *App) deliverDueWebhooks() {
+	hooks, err := app.allWebhooks()
+	if err != nil {
+		log.Printf("[Webhooks] Failed to load webhooks: %v", err)
+		return
+	}
+	rows, err := app.DB.Query(`SELECT id, webhook, webhook_name, event, payload, attempts FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY webhook ORDER BY id) AS n FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt <= ?)
+		WHERE n <= ? ORDER BY id`, float64(time.Now().Unix()), webhookBatch)
+	if err != nil {
+		log.Printf("[Webhooks] Failed to read the queue: %v", err)
+		return
+	}
+	due := map[string][]WebhookDelivery{}
+	var keys []string
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload string
+		if err := rows.Scan(&d.ID, &d.key, &d.Webhook, &d.Event, &payload, &d.Attempts); err != nil {
+			log.Printf("[Webhooks] Delivery row scan failed: %v", err)
+			continue
+		}
+		d.Payload = json.RawMessage(payload)
+		if due[d.key] == nil {
+			keys = append(keys, d.key)
+		}
+		due[d.key] = append(due[d.key], d)
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		hook := findWebhook(hooks, key, due[key][0].Webhook)
+		if hook == nil {
+			for _, d := range due[key] {
+				app.DB.Exec("UPDATE webhook_deliveries SET status = 'failed', last_error = ? WHERE id = ? AND status = 'pending'", "webhook no longer exists", d.ID)
+			}
+			continue
+		}
+		if !app.Hooks.startWorker(key) {
+			continue
+		}
+		go func(key string, h Webhook, ds []WebhookDelivery) {
+			defer app.Hooks.stopWorker(key)
+			app.deliverToEndpoint(h, ds)
+		}(key, *hook, due[key])
+	}
+}
+
+// findWebhook returns the endpoint with the given webhookKey, or nil.
+// "url:" keys are the plain URLs of rule actions (rules.go), which have
+// no endpoint of their own.
+func findWebhook(hooks []Webhook, key, name string) *Webhook {
+	if u := strings.TrimPrefix(key, "url:"); u != key {
+		return &Webhook{Name: name, URL: u}
+	}
+	for i := range hooks {
+		if webhookKey(hooks[i]) == key {
+			return &hooks[i]
+		}
+	}
+	return nil
+}
+
+// startWorker marks an endpoint as being delivered to; false means a
+// worker is already at it.
+func (s *webhookStore) startWorker(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[key] {
+		return false
+	}
+	s.busy[key] = true
+	s.workers.Add(1)
+	return true
+}
+
+func (s *webhookStore) stopWorker(key string) {
+	s.mu.Lock()
+	delete(s.busy, key)
+	s.mu.Unlock()
+	s.workers.Done()
+}
+
+// deliverToEndpoint sends an endpoint's due deliveries oldest first and
+// stops at the first failure; the rest wait for the next pass.
+func (app *App) deliverToEndpoint(h Webhook, ds []WebhookDelivery) {
+	for _, d := range ds {
+		if !app.claimDelivery(d) {
+			continue
+		}
+		if !app.deliverWebhook(h, d) {
+			return
+		}
+	}
+}
+
+// claimDelivery moves a delivery's next_attempt to where a failure would
+// put it before it is sent, so a crash or a failed status update can't
+// make the worker send it again straight away. False means it is no
+// longer due (delivered, failed or claimed meanwhile).
+func (app *App) claimDelivery(d WebhookDelivery) bool {
+	now := time.Now()
+	res, err := app.DB.Exec("UPDATE webhook_deliveries SET next_attempt = ? WHERE id = ? AND status = 'pending' AND next_attempt <= ?",
+		float64(now.Add(app.webhookBackoff(d.Attempts+1)).Unix()), d.ID, float64(now.Unix()))
+	if err != nil {
+		log.Printf("[Webhooks] Failed to claim delivery %d: %v", d.ID, err)
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// deliverWebhook makes one attempt at a claimed delivery, records the
+// outcome and reports whether the endpoint accepted it.
+func (app *App) deliverWebhook(h Webhook, d WebhookDelivery) bool {
+	now := time.Now()
+	status, err := sendWebhook(h, d, now)
+	d.Attempts++
+	if err == nil {
+		_, err = app.DB.Exec("UPDATE webhook_deliveries SET status = 'delivered', attempts = ?, last_status = ?, last_error = NULL, delivered_at = ? WHERE id = ?",
+			d.Attempts, status, float64(now.Unix()), d.ID)
+		if err != nil {
+			log.Printf("[Webhooks] Failed to record delivery %d: %v", d.ID, err)
+		}
+		return true
+	}
+
+	log.Printf("[Webhooks] Delivery %d of %s to %q failed (attempt %d): %v", d.ID, d.Event, h.Name, d.Attempts, err)
+	state, next := "pending", now.Add(app.webhookBackoff(d.Attempts))
+	if d.Attempts >= firstPositive(app.Config.Webhooks.MaxAttempts, defaultWebhookMaxAttempts) {
+		state = "failed"
+	}
+	_, dbErr := app.DB.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, last_status = ?, last_error = ? WHERE id = ?",
+		state, d.Attempts, float64(next.Unix()), status, err.Error(), d.ID)
+	if dbErr != nil {
+		log.Printf("[Webhooks] Failed to record delivery %d: %v", d.ID, dbErr)
+	}
+	// The endpoint is likely down for the rest of its queue too: hold it
+	// all back until this retry instead of rereading it every pass.
+	_, dbErr = app.DB.Exec("UPDATE webhook_deliveries SET next_attempt = ? WHERE webhook = ? AND status = 'pending' AND next_attempt < ?",
+		float64(next.Unix()), d.key, float64(next.Unix()))
+	if dbErr != nil {
+		log.Printf("[Webhooks] Failed to postpone deliveries to %q: %v", h.Name, dbErr)
+	}
+	return false
+}
+
+// webhookBackoff is the wait after the given number of failed attempts:
+// retry_seconds, doubling each time, at most max_retry_seconds.
+func (app *App) webhookBackoff(attempts int) time.Duration {
+	wait := time.Duration(firstPositive(app.Config.Webhooks.RetrySeconds, defaultWebhookRetrySeconds)) * time.Second
+	limit := time.Duration(firstPositive(app.Config.Webhooks.MaxRetrySeconds, defaultWebhookMaxRetrySeconds)) * time.Second
+	for i := 1; i < attempts && wait < limit; i++ {
+		wait *= 2
+	}
+	if wait > limit {
+		wait = limit
+	}
+	return wait
+}
+
+// firstPositive returns v, or def when v is not set.
+func firstPositive(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// sendWebhook POSTs a delivery's payload, signed when the endpoint has a
+// secret. It returns the HTTP status (0 if there was no response).
+func sendWebhook(h Webhook, d WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "chat-with-my-camera")
+	req.Header.Set("X-Chatcam-Event", d.Event)
+	req.Header.Set("X-Chatcam-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Chatcam-Timestamp", ts)
+	if h.Secret != "" {
+		req.Header.Set("X-Chatcam-Signature", signWebhook(h.Secret, ts, d.Payload))
+	}
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook is the X-Chatcam-Signature of a body sent at ts.
+func signWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleWebhooks handles /webhooks:
+//
+//	GET    /webhooks          → all endpoints (config.yaml ones have "source": "config")
+//	POST   /webhooks          → add an endpoint
+//	PUT    /webhooks?id=...   → replace a stored endpoint (an empty secret keeps the old one)
+//	DELETE /webhooks?id=...   → delete a stored endpoint
+func (app *App) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+
+	case http.MethodGet:
+		hooks, err := app.allWebhooks()
+		if err != nil {
+			http.Error(w, "Query failed", http.StatusInternalServerError)
+			log.Printf("List webhooks failed: %v", err)
+			return
+		}
+		for i := range hooks {
+			hooks[i].HasSecret, hooks[i].Secret = hooks[i].Secret != "", ""
+		}
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost, http.MethodPut:
+		var hook Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := validateWebhook(&hook); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hook.Source, hook.HasSecret = "api", false
+		now := float64(time.Now().Unix())
+
+		if r.Method == http.MethodPost {
+			def, _ := json.Marshal(hook)
+			res, err := app.DB.Exec("INSERT INTO webhooks (definition, created_at, updated_at) VALUES (?, ?, ?)", string(def), now, now)
+			if err != nil {
+				http.Error(w, "Insert failed", http.StatusInternalServerError)
+				log.Printf("Insert webhook failed: %v", err)
+				return
+			}
+			hook.ID, _ = res.LastInsertId()
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+				return
+			}
+			var old string
+			err = app.DB.QueryRow("SELECT definition FROM webhooks WHERE id = ?", id).Scan(&old)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Webhook not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, "Query failed", http.StatusInternalServerError)
+				log.Printf("Load webhook failed: %v", err)
+				return
+			}
+			if hook.Secret == "" {
+				var prev Webhook
+				json.Unmarshal([]byte(old), &prev)
+				hook.Secret = prev.Secret
+			}
+			def, _ := json.Marshal(hook)
+			if _, err := app.DB.Exec("UPDATE webhooks SET definition = ?, updated_at = ? WHERE id = ?", string(def), now, id); err != nil {
+				http.Error(w, "Update failed", http.StatusInternalServerError)
+				log.Printf("Update webhook failed: %v", err)
+				return
+			}
+			hook.ID = id
+		}
+		app.reloadWebhooks()
+		hook.HasSecret, hook.Secret = hook.Secret != "", ""
+		json.NewEncoder(w).Encode(hook)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+			return
+		}
+		res, err := app.DB.Exec("DELETE FROM webhooks WHERE id = ?", id)
+		if err != nil {
+			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Delete webhook failed: %v", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		app.reloadWebhooks()
+		json.NewEncoder(w).Encode(map[string]interface{}{"deleted": id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deliveryColumns are the webhook_deliveries columns scanDelivery reads.
+const deliveryColumns = `id, webhook, webhook_name, event, COALESCE(camera_id, ''), status, attempts, next_attempt,
+	COALESCE(last_status, 0), COALESCE(last_error, ''), created_at, COALESCE(delivered_at, 0), COALESCE(replay_of, 0), payload`
+
+// scanDelivery reads one row selected with deliveryColumns.
+func (app *App) scanDelivery(row interface{ Scan(...interface{}) error }) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var next, created, delivered float64
+	var payload string
+	err := row.Scan(&d.ID, &d.key, &d.Webhook, &d.Event, &d.CameraID, &d.Status, &d.Attempts, &next,
+		&d.LastStatus, &d.LastError, &created, &delivered, &d.ReplayOf, &payload)
+	if err != nil {
+		return d, err
+	}
+	format := func(ts float64) string { return time.Unix(int64(ts), 0).In(app.Loc).Format(time.RFC3339) }
+	d.Created = format(created)
+	if d.Status == "pending" {
+		d.NextAttempt = format(next)
+	}
+	if delivered > 0 {
+		d.Delivered = format(delivered)
+	}
+	d.Payload = json.RawMessage(payload)
+	return d, nil
+}
+
+// handleWebhookDeliveries handles GET /webhooks/deliveries?webhook=&event=&status=&camera_id=&limit=,
+// the delivery log, newest first.
+func (app *App) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	query := "SELECT " + deliveryColumns + " FROM webhook_deliveries WHERE 1=1"
+	var args []interface{}
+	for param, column := range map[string]string{"webhook": "webhook_name", "event": "event", "status": "status", "camera_id": "camera_id"} {
+		if v := q.Get(param); v != "" {
+			query += " AND " + column + " = ?"
+			args = append(args, v)
+		}
+	}
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("List webhook deliveries failed: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := app.scanDelivery(rows)
+		if err != nil {
+			log.Printf("Webhook delivery row scan failed: %v", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// handleWebhookReplay handles POST /webhooks/deliveries/replay?id=..., which
+// queues a copy of a delivery (same endpoint and payload) to send now.
+func (app *App) handleWebhookReplay(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+		return
+	}
+	now := float64(time.Now().Unix())
+	res, err := app.DB.Exec(`
+		INSERT INTO webhook_deliveries (webhook, webhook_name, event, camera_id, payload, status, attempts, next_attempt, created_at, replay_of)
+		SELECT webhook, webhook_name, event, camera_id, payload, 'pending', 0, ?, ?, id FROM webhook_deliveries WHERE id = ?`,
+		now, now, id)
+	if err != nil {
+		http.Error(w, "Replay failed", http.StatusInternalServerError)
+		log.Printf("Replay webhook delivery failed: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+	newID, _ := res.LastInsertId()
+	app.wakeWebhooks()
+
+	d, err := app.scanDelivery(app.DB.QueryRow("SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE id = ?", newID))
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		log.Printf("Load webhook delivery failed: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(d)
+}
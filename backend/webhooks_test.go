@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		secret, ts, body, want string
+	}{
+		{"change-me", "1752271450", `{"event":"visit"}`, "sha256=2f49287afffc419a1f241b7a860157d349dfff243423052809258e77e9a1e2be"},
+		{"k", "0", "", "sha256=6b4a4b8b3c40f1e8f53a3d36682e5f99f7ad2ac1df1c93dfe336f329167641e7"},
+	}
+	for _, tt := range tests {
+		if got := signWebhook(tt.secret, tt.ts, []byte(tt.body)); got != tt.want {
+			t.Errorf("signWebhook(%q, %q, %q) = %s, want %s", tt.secret, tt.ts, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		retry, max int
+		attempts   int
+		want       time.Duration
+	}{
+		{0, 0, 1, 10 * time.Second}, // defaults: 10s doubling up to an hour
+		{0, 0, 2, 20 * time.Second},
+		{0, 0, 4, 80 * time.Second},
+		{0, 0, 20, time.Hour},
+		{5, 30, 1, 5 * time.Second},
+		{5, 30, 3, 20 * time.Second},
+		{5, 30, 4, 30 * time.Second},
+		{60, 30, 1, 30 * time.Second}, // retry above the cap
+	}
+	for _, tt := range tests {
+		app := &App{Config: &Config{Webhooks: WebhooksConfig{RetrySeconds: tt.retry, MaxRetrySeconds: tt.max}}}
+		if got := app.webhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("retry %d, max %d: webhookBackoff(%d) = %v, want %v", tt.retry, tt.max, tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	app := newTestApp(t, nil)
+	release := make(chan struct{})
+	var slowHits, fastHits int32
+	var sig string
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&slowHits, 1)
+		<-release
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer slow.Close()
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock() // before slow.Close, which waits for the hung request
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&fastHits, 1) == 1 {
+			sig = r.Header.Get("X-Chatcam-Signature")
+			if want := signWebhook("s3cret", r.Header.Get("X-Chatcam-Timestamp"), body); sig != want {
+				t.Errorf("signature %s, want %s", sig, want)
+			}
+		}
+	}))
+	defer fast.Close()
+	app.Config.Webhooks = WebhooksConfig{RetrySeconds: 60, Endpoints: []Webhook{
+		{Name: "slow", URL: slow.URL},
+		{Name: "fast", URL: fast.URL, Secret: "s3cret"},
+	}}
+	for i := 0; i < 3; i++ {
+		app.queueWebhooks("detection", "garage", []string{"car"}, map[string]int{"n": i})
+	}
+
+	// The slow endpoint hangs on its first delivery; the fast one still gets all three.
+	app.deliverDueWebhooks()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&fastHits) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&fastHits); n != 3 {
+		t.Fatalf("fast endpoint got %d deliveries while the slow one hung, want 3", n)
+	}
+	if sig == "" {
+		t.Error("signed endpoint got no X-Chatcam-Signature")
+	}
+	app.deliverDueWebhooks() // the slow endpoint already has a worker
+	unblock()
+	app.Hooks.workers.Wait()
+	if n := atomic.LoadInt32(&slowHits); n != 1 {
+		t.Fatalf("slow endpoint hit %d times, want 1 (a failure ends its pass)", n)
+	}
+
+	counts := map[string]int{}
+	rows, err := app.DB.Query(`SELECT webhook_name || ':' || status || CASE WHEN status = 'pending' AND next_attempt > ? THEN ' later' ELSE '' END
+		FROM webhook_deliveries`,
+		float64(time.Now().Unix()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var s string
+		rows.Scan(&s)
+		counts[s]++
+	}
+	rows.Close()
+	want := map[string]int{
+		"fast:delivered":     3,
+		"slow:pending later": 3, // one failed; the others wait for its retry a minute out
+	}
+	for k, n := range want {
+		if counts[k] != n {
+			t.Errorf("%s: %d deliveries, want %d (all: %v)", k, counts[k], n, counts)
+		}
+	}
+
+	// A due delivery is claimed once: a second claim finds its retry already booked.
+	var d WebhookDelivery
+	app.DB.QueryRow("SELECT id, attempts FROM webhook_deliveries WHERE webhook_name = 'slow' AND attempts = 0 LIMIT 1").Scan(&d.ID, &d.Attempts)
+	app.DB.Exec("UPDATE webhook_deliveries SET next_attempt = 0 WHERE id = ?", d.ID)
+	if !app.claimDelivery(d) {
+		t.Fatal("could not claim a due delivery")
+	}
+	if app.claimDelivery(d) {
+		t.Error("claimed the same delivery twice")
+	}
+}
+
+func TestWebhookBacklogDoesNotStarveOthers(t *testing.T) {
+	app := newTestApp(t, nil)
+	var deadHits, liveHits int32
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&deadHits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&liveHits, 1)
+	}))
+	defer live.Close()
+	app.Config.Webhooks = WebhooksConfig{RetrySeconds: 60, Endpoints: []Webhook{
+		{Name: "dead", URL: dead.URL},
+		{Name: "live", URL: live.URL},
+	}}
+
+	// More due deliveries for the dead endpoint than one pass reads, all
+	// older than the live endpoint's.
+	tx, err := app.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < webhookBatch+20; i++ {
+		tx.Exec(`INSERT INTO webhook_deliveries (webhook, webhook_name, event, camera_id, payload, status, attempts, next_attempt, created_at)
+			VALUES ('config:dead', 'dead', 'detection', 'garage', '{}', 'pending', 0, 0, 0)`)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.queueDelivery("config:live", "live", "detection", "garage", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	app.deliverDueWebhooks()
+	app.Hooks.workers.Wait()
+	if n := atomic.LoadInt32(&liveHits); n != 1 {
+		t.Fatalf("live endpoint got %d deliveries behind the dead one's backlog, want 1", n)
+	}
+	if n := atomic.LoadInt32(&deadHits); n != 1 {
+		t.Errorf("dead endpoint hit %d times, want 1", n)
+	}
+	var due int
+	app.DB.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE webhook = 'config:dead' AND status = 'pending' AND next_attempt <= ?",
+		float64(time.Now().Unix())).Scan(&due)
+	if due != 0 {
+		t.Errorf("%d deliveries to the dead endpoint still due after it failed, want all held back", due)
+	}
+}
+
+func TestRuleWebhookActionIsQueued(t *testing.T) {
+	app := newTestApp(t, nil)
+	app.Config.Webhooks.Endpoints = []Webhook{{Name: "node-red", URL: "http://nodered:1880/chatcam", Events: []string{"visit"}}}
+	r := Rule{Name: "person at night"}
+	f := RuleFiring{Rule: r.Name, CameraID: "garage"}
+
+	tests := []struct {
+		action         RuleAction
+		key, name, err string
+	}{
+		{RuleAction{Type: "webhook", Webhook: "node-red"}, "config:node-red", "node-red", ""},
+		{RuleAction{Type: "webhook", URL: "http://example.com/hook"}, "url:http://example.com/hook", "rule person at night", ""},
+		{RuleAction{Type: "webhook", Webhook: "gone"}, "", "", `no webhook named "gone"`},
+	}
+	for _, tt := range tests {
+		err := app.runAction(context.Background(), r, tt.action, f)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%+v: error %v, want %q", tt.action, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", tt.action, err)
+			continue
+		}
+		var key, name, event, status string
+		app.DB.QueryRow("SELECT webhook, webhook_name, event, status FROM webhook_deliveries ORDER BY id DESC LIMIT 1").
+			Scan(&key, &name, &event, &status)
+		if key != tt.key || name != tt.name || event != "rule_firing" || status != "pending" {
+			t.Errorf("%+v: queued %s/%s/%s/%s, want %s/%s/rule_firing/pending", tt.action, key, name, event, status, tt.key, tt.name)
+		}
+	}
+}
+
+func TestRuleFiringWebhookSentOnce(t *testing.T) {
+	app := newTestApp(t, nil)
+	// The README's endpoint, plus one that takes every event.
+	app.Config.Webhooks.Endpoints = []Webhook{
+		{Name: "node-red", URL: "http://nodered:1880/chatcam", Secret: "change-me", Events: []string{"visit", "rule_firing"}, Labels: []string{"car"}},
+		{Name: "n8n", URL: "http://n8n:5678/webhook/chatcam"},
+	}
+	r := Rule{Name: "car arrives", Labels: []string{"car"}, Actions: []RuleAction{{Type: "webhook", Webhook: "node-red"}}}
+	ev := DetectionEvent{ID: 1, Timestamp: 1752224400, CameraID: "garage", Labels: []string{"car"}}
+	app.fireRule(context.Background(), r, ev, 1)
+
+	counts := map[string]int{}
+	rows, err := app.DB.Query("SELECT webhook_name FROM webhook_deliveries WHERE event = 'rule_firing'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		counts[name]++
+	}
+	rows.Close()
+	if counts["node-red"] != 1 || counts["n8n"] != 1 {
+		t.Errorf("rule_firing deliveries %v, want one each for node-red and n8n", counts)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+zones.go
+--------
+
+Zones are named polygons on a camera's picture — the driveway, but not the
+street behind it:
+
+	cameras:
+	  - id: garage_webcam
+	    zones:
+	      - name: driveway
+	        polygon: [[0.1, 0.55], [0.9, 0.55], [1.0, 1.0], [0.0, 1.0]]   # x, y as fractions of the frame
+	        anchor: bottom      # point of the box tested: bottom (middle of the bottom edge, default) or center
+
+More zones can be managed through /zones. At ingest every object is put in
+the zones that contain its box anchor, and the result is stored per object
+in detections.zones (e.g. [["driveway"], []] for a car on the driveway and
+one on the street), so /timeline, /visits, rules and chat can filter on
+zone. Boxes are in snapshot pixels; the frame size comes from the event's
+frame_size, or else from the snapshot's JPEG header. Zone changes apply to
+new detections only.
+*/
+
+// Zone is a named polygon on one camera.
+type Zone struct {
+	ID       int64  `yaml:"-" json:"id"`        // 0 for zones from config.yaml
+	CameraID string `yaml:"-" json:"camera_id"` // set from the camera entry for config zones
+	Source   string `yaml:"-" json:"source"`    // config or api
+
+	Name    string      `yaml:"name" json:"name"`
+	Polygon [][]float64 `yaml:"polygon" json:"polygon"` // [x, y] points in 0..1
+	Anchor  string      `yaml:"anchor" json:"anchor,omitempty"`
+}
+
+// zoneStore caches the zones stored through the API.
+type zoneStore struct {
+	mu     sync.Mutex
+	loaded bool
+	stored []Zone
+}
+
+// validateZone checks a zone's name, polygon and anchor.
+func validateZone(z *Zone) error {
+	z.Name = strings.TrimSpace(z.Name)
+	if z.Name == "" {
+		return fmt.Errorf("zone without a name")
+	}
+	if len(z.Polygon) < 3 {
+		return fmt.Errorf("zone %q: polygon needs at least 3 points", z.Name)
+	}
+	for _, p := range z.Polygon {
+		if len(p) != 2 || p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+			return fmt.Errorf("zone %q: points must be [x, y] with 0 <= x, y <= 1", z.Name)
+		}
+	}
+	switch z.Anchor {
+	case "", "bottom", "center":
+	default:
+		return fmt.Errorf("zone %q: anchor must be bottom or center", z.Name)
+	}
+	return nil
+}
+
+// contains reports whether the point (x, y) lies inside the polygon (even-odd
+// rule) or on its edge: a box touching the bottom of the frame has its
+// anchor at y = 1, on the edge of a zone that reaches the frame border.
+func (z Zone) contains(x, y float64) bool {
+	in := false
+	p := z.Polygon
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		if onSegment(x, y, p[j], p[i]) {
+			return true
+		}
+		if (p[i][1] > y) != (p[j][1] > y) &&
+			x < (p[j][0]-p[i][0])*(y-p[i][1])/(p[j][1]-p[i][1])+p[i][0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// onSegment reports whether (x, y) lies on the segment a-b.
+func onSegment(x, y float64, a, b []float64) bool {
+	const eps = 1e-9
+	cross := (b[0]-a[0])*(y-a[1]) - (b[1]-a[1])*(x-a[0])
+	if math.Abs(cross) > eps {
+		return false
+	}
+	return x >= math.Min(a[0], b[0])-eps && x <= math.Max(a[0], b[0])+eps &&
+		y >= math.Min(a[1], b[1])-eps && y <= math.Max(a[1], b[1])+eps
+}
+
+// cameraZones returns a camera's zones: config.yaml ones first, then stored ones.
+func (app *App) cameraZones(camera string) []Zone {
+	var zones []Zone
+	for _, cam := range app.Config.Cameras {
+		if cam.ID != camera {
+			continue
+		}
+		for _, z := range cam.Zones {
+			z.CameraID, z.Source = cam.ID, "config"
+			zones = append(zones, z)
+		}
+	}
+	stored, err := app.storedZones()
+	if err != nil {
+		log.Printf("[Zones] Failed to load zones: %v", err)
+	}
+	for _, z := range stored {
+		if z.CameraID == camera {
+			zones = append(zones, z)
+		}
+	}
+	return zones
+}
+
+// storedZones returns the zones table, read once and again after each change.
+func (app *App) storedZones() ([]Zone, error) {
+	s := app.Zones
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		return s.stored, nil
+	}
+	rows, err := app.DB.Query("SELECT id, camera_id, name, polygon, COALESCE(anchor, '') FROM zones ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	zones := []Zone{}
+	for rows.Next() {
+		z := Zone{Source: "api"}
+		var polygon string
+		if err := rows.Scan(&z.ID, &z.CameraID, &z.Name, &polygon, &z.Anchor); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(polygon), &z.Polygon)
+		zones = append(zones, z)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.stored, s.loaded = zones, true
+	return zones, nil
+}
+
+// reloadZones makes the next lookup re-read the zones table.
+func (app *App) reloadZones() {
+	app.Zones.mu.Lock()
+	app.Zones.loaded = false
+	app.Zones.mu.Unlock()
+}
+
+// assignZones returns, for each box, the names of the zones its anchor is
+// in. width and height are the frame size the boxes are measured in. The
+// result is nil when the camera has no zones or the frame size is unknown.
+func assignZones(zones []Zone, boxes [][]float64, width, height float64) [][]string {
+	if len(zones) == 0 || width <= 0 || height <= 0 {
+		return nil
+	}
+	out := make([][]string, len(boxes))
+	for i, b := range boxes {
+		out[i] = []string{}
+		if len(b) < 4 {
+			continue
+		}
+		for _, z := range zones {
+			x, y := (b[0]+b[2])/2/width, b[3]/height
+			if z.Anchor == "center" {
+				y = (b[1] + b[3]) / 2 / height
+			}
+			if z.contains(x, y) {
+				out[i] = append(out[i], z.Name)
+			}
+		}
+	}
+	return out
+}
+
+// eventFrameSize works out the size of the frame an event's boxes refer
+// to: the event's frame_size if the publisher sent one, else 1x1 when the
+// boxes are already normalised, else the snapshot's JPEG header.
+func eventFrameSize(event map[string]interface{}, boxes [][]float64) (float64, float64) {
+	if fs, ok := event["frame_size"].([]interface{}); ok && len(fs) == 2 {
+		w, _ := fs[0].(float64)
+		h, _ := fs[1].(float64)
+		if w > 0 && h > 0 {
+			return w, h
+		}
+	}
+	normalised := len(boxes) > 0
+	for _, b := range boxes {
+		for _, v := range b {
+			if v > 1 {
+				normalised = false
+			}
+		}
+	}
+	if normalised {
+		return 1, 1
+	}
+	if snap, ok := event["snapshot"].(string); ok && snap != "" {
+		cfg, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(snap)))
+		if err == nil {
+			return float64(cfg.Width), float64(cfg.Height)
+		}
+	}
+	return 0, 0
+}
+
+// inAnyZone keeps the labels of objects that are in at least one zone.
+// Without zone information every label is kept.
+func inAnyZone(labels []string, zones [][]string) []string {
+	if zones == nil {
+		return labels
+	}
+	kept := []string{}
+	for i, l := range labels {
+		if i < len(zones) && len(zones[i]) > 0 {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// zoneFilter returns "(zones LIKE ? OR ...)" matching detections with an
+// object in any of the zones, and its arguments.
+func zoneFilter(zones []string) (string, []interface{}) {
+	var likes []string
+	var args []interface{}
+	for _, z := range zones {
+		likes = append(likes, "zones LIKE ?")
+		args = append(args, `%"`+z+`"%`)
+	}
+	return "(" + strings.Join(likes, " OR ") + ")", args
+}
+
+// zonesInQuestion returns zones of the given cameras named in a question
+// ("any cars in the driveway?").
+func (app *App) zonesInQuestion(question string, cameras []string) []string {
+	q := strings.ToLower(question)
+	var names []string
+	for _, camera := range cameras {
+		for _, z := range app.cameraZones(camera) {
+			if mentions(q, []string{z.Name, strings.ReplaceAll(z.Name, "_", " ")}) {
+				names = append(names, z.Name)
+			}
+		}
+	}
+	return uniqueStrings(names)
+}
+
+// resolveZones picks the zones a chat request is limited to: those named
+// in the question, else the request's zones, else none (no limit).
+func (app *App) resolveZones(req chatQuery, cameras []string) []string {
+	if names := app.zonesInQuestion(req.Message, cameras); len(names) > 0 {
+		return names
+	}
+	return uniqueStrings(req.Zones)
+}
+
+// handleZones handles /zones:
+//
+//	GET    /zones?camera_id=  → zones (config.yaml ones have "source": "config")
+//	POST   /zones             → add a zone: { camera_id, name, polygon, anchor? }
+//	PUT    /zones?id=...      → replace a stored zone
+//	DELETE /zones?id=...      → delete a stored zone
+func (app *App) handleZones(w http.ResponseWriter, r *http.Request) {
+	// === CORS ===
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodOptions:
+		return
+
+	case http.MethodGet:
+		cameras := app.cameraIDs()
+		if id := r.URL.Query().Get("camera_id"); id != "" {
+			cameras = []string{id}
+		} else if stored, err := app.storedZones(); err == nil {
+			// Zones may be stored for cameras no longer in the config.
+			for _, z := range stored {
+				cameras = append(cameras, z.CameraID)
+			}
+			cameras = uniqueStrings(cameras)
+		}
+		zones := []Zone{}
+		for _, camera := range cameras {
+			zones = append(zones, app.cameraZones(camera)...)
+		}
+		json.NewEncoder(w).Encode(zones)
+
+	case http.MethodPost, http.MethodPut:
+		var z Zone
+		if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if z.CameraID == "" {
+			http.Error(w, "Missing 'camera_id'", http.StatusBadRequest)
+			return
+		}
+		if err := validateZone(&z); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var id int64
+		if r.Method == http.MethodPut {
+			var err error
+			if id, err = strconv.ParseInt(r.URL.Query().Get("id"), 10, 64); err != nil {
+				http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+				return
+			}
+		}
+		for _, other := range app.cameraZones(z.CameraID) {
+			if other.Name == z.Name && (other.Source == "config" || other.ID != id) {
+				http.Error(w, fmt.Sprintf("Zone %q already exists on %s", z.Name, z.CameraID), http.StatusConflict)
+				return
+			}
+		}
+		polygon, _ := json.Marshal(z.Polygon)
+		now := float64(time.Now().Unix())
+
+		if r.Method == http.MethodPost {
+			res, err := app.DB.Exec("INSERT INTO zones (camera_id, name, polygon, anchor, created_at) VALUES (?, ?, ?, ?, ?)",
+				z.CameraID, z.Name, string(polygon), z.Anchor, now)
+			if err != nil {
+				http.Error(w, "Insert failed", http.StatusInternalServerError)
+				log.Printf("Insert zone failed: %v", err)
+				return
+			}
+			z.ID, _ = res.LastInsertId()
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			res, err := app.DB.Exec("UPDATE zones SET camera_id = ?, name = ?, polygon = ?, anchor = ? WHERE id = ?",
+				z.CameraID, z.Name, string(polygon), z.Anchor, id)
+			if err != nil {
+				http.Error(w, "Update failed", http.StatusInternalServerError)
+				log.Printf("Update zone failed: %v", err)
+				return
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				http.Error(w, "Zone not found", http.StatusNotFound)
+				return
+			}
+			z.ID = id
+		}
+		z.Source = "api"
+		app.reloadZones()
+		json.NewEncoder(w).Encode(z)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Missing or invalid 'id'", http.StatusBadRequest)
+			return
+		}
+		res, err := app.DB.Exec("DELETE FROM zones WHERE id = ?", id)
+		if err != nil {
+			http.Error(w, "Delete failed", http.StatusInternalServerError)
+			log.Printf("Delete zone failed: %v", err)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Zone not found", http.StatusNotFound)
+			return
+		}
+		app.reloadZones()
+		json.NewEncoder(w).Encode(map[string]interface{}{"deleted": id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
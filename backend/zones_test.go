@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZoneContains(t *testing.T) {
+	// The bottom half of the frame, and an L-shaped (concave) zone.
+	bottom := Zone{Name: "drive", Polygon: [][]float64{{0, 0.5}, {1, 0.5}, {1, 1}, {0, 1}}}
+	ell := Zone{Name: "ell", Polygon: [][]float64{{0, 0}, {0.6, 0}, {0.6, 0.4}, {0.3, 0.4}, {0.3, 1}, {0, 1}}}
+
+	tests := []struct {
+		zone Zone
+		x, y float64
+		want bool
+	}{
+		{bottom, 0.5, 0.75, true},
+		{bottom, 0.5, 0.25, false},
+		{bottom, 0.5, 1, true},     // bottom edge: a box touching the frame border
+		{bottom, 0.5, 0.5, true},   // top edge
+		{bottom, 0, 0.7, true},     // left edge
+		{bottom, 1, 1, true},       // corner
+		{bottom, 1.01, 0.7, false}, // just outside
+		{ell, 0.1, 0.9, true},
+		{ell, 0.5, 0.2, true},
+		{ell, 0.5, 0.8, false}, // in the notch
+		{ell, 0.45, 0.4, true}, // on the inner edge
+		{ell, 0.3, 0.7, true},
+	}
+	for _, tt := range tests {
+		if got := tt.zone.contains(tt.x, tt.y); got != tt.want {
+			t.Errorf("%s.contains(%v, %v) = %v, want %v", tt.zone.Name, tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestAssignZones(t *testing.T) {
+	zones := []Zone{
+		{Name: "drive", Polygon: [][]float64{{0, 0.5}, {1, 0.5}, {1, 1}, {0, 1}}},
+		{Name: "door", Polygon: [][]float64{{0.4, 0}, {0.6, 0}, {0.6, 0.6}, {0.4, 0.6}}, Anchor: "center"},
+	}
+	tests := []struct {
+		name          string
+		zones         []Zone
+		boxes         [][]float64
+		width, height float64
+		want          [][]string
+	}{
+		{
+			name:  "pixels",
+			zones: zones,
+			boxes: [][]float64{
+				{100, 300, 200, 400}, // feet at y=400/400: drive
+				{100, 10, 200, 100},  // feet at y=0.25: nowhere
+				{170, 60, 230, 200},  // centre (0.5, 0.325) in door, feet at 0.5 on drive's edge
+				{1, 2},               // malformed
+			},
+			width: 400, height: 400,
+			want: [][]string{{"drive"}, {}, {"drive", "door"}, {}},
+		},
+		{
+			name:  "normalised boxes",
+			zones: zones,
+			boxes: [][]float64{{0.1, 0.6, 0.3, 0.9}},
+			width: 1, height: 1,
+			want: [][]string{{"drive"}},
+		},
+		{name: "no zones", boxes: [][]float64{{0, 0, 1, 1}}, width: 1, height: 1, want: nil},
+		{name: "unknown frame size", zones: zones, boxes: [][]float64{{0, 0, 1, 1}}, want: nil},
+	}
+	for _, tt := range tests {
+		got := assignZones(tt.zones, tt.boxes, tt.width, tt.height)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: assignZones = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ import { BrowserRouter as Router, Routes, Route } from 'react-router-dom';
 
 import Dashboard from './components/Dashboard';
 import CameraPage from './components/CameraPage'; // We’ll create this next!
+import WebhookLog from './components/WebhookLog';
 
 /**
  * App component serves as the main entry point for the Camera Monitoring Dashboard application.
@@ -15,6 +16,7 @@ function App() {
       <Routes>
         <Route path="/" element={<Dashboard />} />
         <Route path="/camera/:cameraId" element={<CameraPage />} />
+        <Route path="/webhooks" element={<WebhookLog />} />
       </Routes>
     </Router>
   );
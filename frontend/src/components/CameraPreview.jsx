@@ -54,11 +54,11 @@ const CameraPreview = ({ cameraId }) => {
         })
         .then((data) => {
           if (data.snapshot_file) {
-            // Your backend returns "./snapshots/filename.jpg"
-            // So split off the filename and build the snapshot URL.
-            const fileName = data.snapshot_file.split('/').pop();
+            // snapshot_file is a store key like "cam/2025-07-11/<hash>.jpg",
+            // so pass it through as-is (URL-encoded).
+            const fileName = encodeURIComponent(data.snapshot_file);
             setSnapshotUrl(
-              `http://localhost:8080/snapshot?file=${fileName}`
+              `http://localhost:8080/snapshot?file=${fileName}&w=640`
             );
           } else {
             // If no detection, fallback to static thumbnail.
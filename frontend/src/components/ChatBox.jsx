@@ -1,17 +1,75 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import '../styles/ChatBox.css';
 
 /**
  * ChatBox
  *
  * - Lets the user type messages to your backend LLM.
- * - Sends { camera_id, message } to `/chat`.
+ * - Sends { camera_id, message, conversation_id } to `/chat/stream` and shows tokens as they arrive.
  * - Shows the full conversation history: user & bot.
+ * - Remembers the conversation per camera (localStorage) and reloads it from
+ *   `/conversations/{id}`, so follow-up questions keep their context.
  */
+
+// Human-friendly text for the backend's progress stages
+const STAGE_LABELS = {
+  extracting: 'Understanding your question...',
+  extracted: 'Understanding your question...',
+  time_range: 'Working out the time range...',
+  lookup: 'Searching detections...',
+  context: 'Searching detections...',
+  thinking: 'Thinking...',
+  tool: 'Searching detections...',
+  answering: 'Answering...',
+};
+
+// localStorage key for a camera's current conversation
+const conversationKey = (cameraId) => `chat-conversation-${cameraId}`;
+
 const ChatBox = ({ cameraId }) => {
   // === Local state ===
   const [messages, setMessages] = useState([]); // [{ sender: 'user'|'llm', text: '...' }]
   const [input, setInput] = useState('');
+  const [conversationId, setConversationId] = useState(null);
+
+  const rememberConversation = (id) => {
+    setConversationId(id);
+    localStorage.setItem(conversationKey(cameraId), String(id));
+  };
+
+  // === Resume this camera's last conversation ===
+  useEffect(() => {
+    setMessages([]);
+    setConversationId(null);
+
+    const saved = localStorage.getItem(conversationKey(cameraId));
+    if (!saved) return;
+
+    fetch(`http://localhost:8080/conversations/${saved}`)
+      .then((res) => {
+        if (!res.ok) throw new Error(`HTTP ${res.status}`);
+        return res.json();
+      })
+      .then((conv) => {
+        setConversationId(conv.id);
+        setMessages((conv.messages || []).map((m) => ({
+          sender: m.role === 'user' ? 'user' : 'llm',
+          text: m.content,
+        })));
+      })
+      .catch((err) => {
+        // Deleted or unknown conversation: start fresh next time
+        console.warn('Could not resume conversation:', err);
+        localStorage.removeItem(conversationKey(cameraId));
+      });
+  }, [cameraId]);
+
+  // === Start over ===
+  const handleNewChat = () => {
+    localStorage.removeItem(conversationKey(cameraId));
+    setConversationId(null);
+    setMessages([]);
+  };
 
   // === Send user input to backend ===
   const handleSend = async () => {
@@ -22,37 +80,66 @@ const ChatBox = ({ cameraId }) => {
     const updatedMessages = [...messages, userMessage];
     setMessages(updatedMessages);
 
-    console.log(`Sending to /chat for camera: ${cameraId}`);
+    console.log(`Sending to /chat/stream for camera: ${cameraId}`);
+
+    // Placeholder LLM message that tokens are appended to as they stream in
+    let answer = '';
+    const showReply = (text, status = '', snapshots = []) =>
+      setMessages([...updatedMessages, { sender: 'llm', text, status, snapshots }]);
+    showReply('', 'Thinking...');
 
     try {
-      // ✅ Send POST to your backend
-      const res = await fetch('http://localhost:8080/chat', {
+      // ✅ Stream the answer over Server-Sent Events
+      const res = await fetch('http://localhost:8080/chat/stream', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({
           camera_id: cameraId,
-          message: input
+          message: input,
+          ...(conversationId && { conversation_id: conversationId }),
         }),
       });
 
-      const data = await res.json();
+      const reader = res.body.getReader();
+      const decoder = new TextDecoder();
+      let buffer = '';
+
+      // Each SSE event is "event: <name>\ndata: <json>\n\n"
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buffer += decoder.decode(value, { stream: true });
 
-      // Add LLM response to chat history
-      const llmReply = {
-        sender: 'llm',
-        text: data.answer || '🤖 No response from LLM!'
-      };
+        const events = buffer.split('\n\n');
+        buffer = events.pop(); // keep the incomplete tail
+        for (const raw of events) {
+          const name = raw.match(/^event: (.*)$/m)?.[1];
+          const data = JSON.parse(raw.match(/^data: (.*)$/m)?.[1] || '{}');
 
-      setMessages([...updatedMessages, llmReply]);
+          if (name === 'conversation') {
+            rememberConversation(data.conversation_id);
+          } else if (name === 'progress') {
+            showReply(answer, STAGE_LABELS[data.stage] || '');
+          } else if (name === 'token') {
+            answer += data.text;
+            showReply(answer);
+          } else if (name === 'done') {
+            answer = data.answer;
+            // Flag answers that mention times/objects not in the detections
+            const unverified = data.verification && !data.verification.grounded
+              ? `⚠ not in the detections: ${data.verification.issues.join('; ')}`
+              : '';
+            showReply(answer || '🤖 No response from LLM!', unverified, data.snapshots || []);
+          } else if (name === 'error') {
+            showReply(`🤖 ${data.error}`);
+          }
+        }
+      }
     } catch (err) {
       console.error('Error calling LLM:', err);
 
       // Add fallback error message
-      const errorReply = {
-        sender: 'llm',
-        text: "🤖 Oops! Couldn't reach the backend."
-      };
-      setMessages([...updatedMessages, errorReply]);
+      showReply("🤖 Oops! Couldn't reach the backend.");
     }
 
     // Clear input box
@@ -71,6 +158,7 @@ const ChatBox = ({ cameraId }) => {
       {/* === Optional camera ID banner === */}
       <div className="chat-camera-info">
         Chatting with camera: <strong>{cameraId}</strong>
+        <button className="chat-new" onClick={handleNewChat}>New chat</button>
       </div>
 
       {/* === Chat conversation === */}
@@ -81,6 +169,21 @@ const ChatBox = ({ cameraId }) => {
             className={`chat-message ${msg.sender}`}
           >
             <strong>{msg.sender === 'user' ? 'You:' : 'LLM:'}</strong> {msg.text}
+            {msg.status && <em className="chat-status"> {msg.status}</em>}
+            {/* Snapshots a vision model looked at, numbered as the answer cites them */}
+            {msg.snapshots?.length > 0 && (
+              <div className="chat-snapshots">
+                {msg.snapshots.map((snap) => (
+                  <a key={snap.index} href={`http://localhost:8080${snap.url}`} target="_blank" rel="noreferrer">
+                    <img
+                      src={`http://localhost:8080/snapshot?file=${encodeURIComponent(snap.url.replace('/snapshots/', ''))}&w=160`}
+                      alt={`[${snap.index}] ${snap.camera_id} ${snap.time}`}
+                      title={`[${snap.index}] ${snap.camera_id} ${snap.time}`}
+                    />
+                  </a>
+                ))}
+              </div>
+            )}
           </div>
         ))}
       </div>
@@ -41,14 +41,17 @@ const HistoryBox = ({ cameraId }) => {
       .then((data) => {
         const chronoItems = data.map((event) => ({
           title: new Date(event.timestamp * 1000).toLocaleString(),
-          cardTitle: event.labels ? JSON.parse(event.labels).join(', ') : 'Detection Event',
+          cardTitle: event.kind === 'loitering'
+            ? `${JSON.parse(event.labels).join(', ')} loitering (${Math.round(event.dwell_seconds / 60)} min)`
+            : event.labels ? JSON.parse(event.labels).join(', ') : 'Detection Event',
           cardSubtitle: event.camera_id || '',
           cardDetailedText: '',
           media: event.snapshot_file
           ? {
               name: 'Snapshot',
               source: {
-                url: `http://localhost:8080/snapshot?file=${event.snapshot_file.split('/').pop()}`
+                // w=320 matches the thumbnail the backend pre-generates at ingest
+                url: `http://localhost:8080/snapshot?file=${encodeURIComponent(event.snapshot_file)}&w=320`
               },
               type: 'IMAGE'
             }
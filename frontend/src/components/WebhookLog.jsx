@@ -0,0 +1,77 @@
+import React, { useEffect, useState } from 'react';
+import { Link } from 'react-router-dom';
+import '../styles/WebhookLog.css';
+
+/**
+ * WebhookLog
+ *
+ * - Lists outgoing webhook deliveries, newest first (GET /webhooks/deliveries).
+ * - Replay queues a copy of a delivery to be sent again.
+ */
+function WebhookLog() {
+  // === State: deliveries + status filter ===
+  const [deliveries, setDeliveries] = useState([]);
+  const [status, setStatus] = useState('');
+
+  const load = () => {
+    const url = `http://localhost:8080/webhooks/deliveries?limit=200${status ? `&status=${status}` : ''}`;
+    fetch(url)
+      .then((res) => res.json())
+      .then(setDeliveries)
+      .catch((err) => console.error('Error fetching webhook deliveries:', err));
+  };
+
+  // === Refresh on filter change, and every 5s while open ===
+  useEffect(() => {
+    load();
+    const timer = setInterval(load, 5000);
+    return () => clearInterval(timer);
+  }, [status]);
+
+  const replay = (id) => {
+    fetch(`http://localhost:8080/webhooks/deliveries/replay?id=${id}`, { method: 'POST' })
+      .then(load)
+      .catch((err) => console.error('Error replaying delivery:', err));
+  };
+
+  return (
+    <div className="webhook-log">
+      <div className="webhook-header">
+        <Link to="/" className="back-button">⬅ Back to Dashboard</Link>
+        <div className="webhook-title">Webhook deliveries</div>
+        <select value={status} onChange={(e) => setStatus(e.target.value)}>
+          <option value="">All</option>
+          <option value="pending">Pending</option>
+          <option value="delivered">Delivered</option>
+          <option value="failed">Failed</option>
+        </select>
+      </div>
+
+      <table>
+        <thead>
+          <tr>
+            <th>#</th><th>Created</th><th>Webhook</th><th>Event</th><th>Camera</th>
+            <th>Status</th><th>Attempts</th><th>Last error</th><th></th>
+          </tr>
+        </thead>
+        <tbody>
+          {deliveries.map((d) => (
+            <tr key={d.id} className={`status-${d.status}`}>
+              <td>{d.id}{d.replay_of ? ` (↺ ${d.replay_of})` : ''}</td>
+              <td>{new Date(d.created).toLocaleString()}</td>
+              <td>{d.webhook}</td>
+              <td>{d.event}</td>
+              <td>{d.camera_id}</td>
+              <td>{d.status}{d.next_attempt ? ` → ${new Date(d.next_attempt).toLocaleTimeString()}` : ''}</td>
+              <td>{d.attempts}</td>
+              <td>{d.last_error}</td>
+              <td><button onClick={() => replay(d.id)}>Replay</button></td>
+            </tr>
+          ))}
+        </tbody>
+      </table>
+    </div>
+  );
+}
+
+export default WebhookLog;
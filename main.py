@@ -80,6 +80,9 @@ def main():
     # Initialize ZeroMQ publisher on port 5555
     publisher = ZeroMQPublisher(port=config["publisher"]["port"])
 
+    # raw_frames: publish clean frames and let the backend draw boxes on demand
+    raw_frames = config["publisher"].get("raw_frames", False)
+
     print("[Main] Running with config:", config)
 
     while True:
@@ -105,10 +108,12 @@ def main():
             for result in results:
                 boxes = result.boxes.xyxy.cpu().numpy().tolist() if result.boxes else []
                 labels = [result.names[i] for i in result.boxes.cls.cpu().numpy().astype(int)] if result.boxes else []
+                confidences = result.boxes.conf.cpu().numpy().tolist() if result.boxes else []
 
-                # Encode frame with drawn boxes
+                # Encode frame (with drawn boxes unless raw_frames is set)
                 annotated_frame = result.plot()
-                ret, buffer = cv2.imencode('.jpg', annotated_frame)
+                snapshot_frame = frame if raw_frames else annotated_frame
+                ret, buffer = cv2.imencode('.jpg', snapshot_frame)
                 jpg_as_text = base64.b64encode(buffer).decode('utf-8')
 
                 # Create event payload
@@ -117,6 +122,8 @@ def main():
                     "camera_id": cam.id,
                     "boxes": boxes,
                     "labels": labels,
+                    "confidences": confidences,
+                    "frame_size": [frame.shape[1], frame.shape[0]],  # width, height the boxes refer to
                     "snapshot": jpg_as_text
                 }
 